@@ -0,0 +1,142 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package appstats
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luci/gae/impl/memory"
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type Foo struct {
+	ID  int64 `gae:"$id"`
+	Val int
+}
+
+// handleRequest simulates a handler with an N+1 access pattern.
+func handleRequest(c context.Context) {
+	d := ds.Get(c)
+	keys := []*ds.Key(nil)
+	if err := d.GetAll(ds.NewQuery("Foo"), &keys); err != nil {
+		panic(err)
+	}
+	for _, k := range keys {
+		if err := d.Get(&Foo{ID: k.IntID()}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestAppstats(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test appstats", t, func() {
+		now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		c, _ := testclock.UseTime(context.Background(), now)
+		c = memory.Use(c)
+
+		d := ds.Get(c)
+		So(d.PutMulti([]*Foo{{ID: 1}, {ID: 2}, {ID: 3}}), ShouldBeNil)
+		d.Testable().CatchupIndexes()
+
+		req, err := http.NewRequest("GET", "http://example.com/things", nil)
+		So(err, ShouldBeNil)
+
+		for _, store := range []Store{&MemoryStore{}, &MemcacheStore{}} {
+			store := store
+
+			Convey(strings.TrimPrefix(typeName(store), "*appstats."), func() {
+				rc, rec := Start(c, req, store)
+				handleRequest(rc)
+				So(rec.Finish(), ShouldBeNil)
+
+				Convey("records the profile", func() {
+					sums, err := store.List(c)
+					So(err, ShouldBeNil)
+					So(len(sums), ShouldEqual, 1)
+					So(sums[0].URL, ShouldEqual, "http://example.com/things")
+					So(sums[0].NumRPCs, ShouldEqual, 4)
+
+					p, err := store.Get(c, sums[0].ID)
+					So(err, ShouldBeNil)
+					So(len(p.RPCs), ShouldEqual, 4)
+					So(p.RPCs[0].Name(), ShouldEqual, "datastore.Run")
+					So(p.RPCs[1].Name(), ShouldEqual, "datastore.GetMulti")
+					So(p.RPCs[1].Keys, ShouldResemble, []string{"dev~app::/Foo,1"})
+
+					Convey("with call sites", func() {
+						site := p.RPCs[1].Site()
+						So(site.Function, ShouldEndWith, "appstats.handleRequest")
+						So(site.File, ShouldEndWith, "appstats_test.go")
+
+						bySite := p.BySite()
+						So(bySite[0].Name, ShouldEqual, "datastore.GetMulti")
+						So(bySite[0].Count, ShouldEqual, 3)
+						So(bySite[0].Site, ShouldResemble, site)
+					})
+				})
+
+				Convey("ignores RPCs after Finish", func() {
+					handleRequest(rc)
+					So(rec.Finish(), ShouldBeNil)
+					So(rec.Profile().NumRPCs, ShouldEqual, 4)
+				})
+
+				Convey("serves the profiles", func() {
+					h := Handler(store, func(*http.Request) context.Context { return c })
+
+					rsp := httptest.NewRecorder()
+					h.ServeHTTP(rsp, mustRequest("/"))
+					So(rsp.Code, ShouldEqual, http.StatusOK)
+					So(rsp.Body.String(), ShouldContainSubstring, "http://example.com/things")
+
+					sums, err := store.List(c)
+					So(err, ShouldBeNil)
+					rsp = httptest.NewRecorder()
+					h.ServeHTTP(rsp, mustRequest("/?id="+sums[0].ID))
+					So(rsp.Code, ShouldEqual, http.StatusOK)
+					So(rsp.Body.String(), ShouldContainSubstring, "datastore.GetMulti")
+					So(rsp.Body.String(), ShouldContainSubstring, "appstats.handleRequest")
+
+					rsp = httptest.NewRecorder()
+					h.ServeHTTP(rsp, mustRequest("/?id=nope"))
+					So(rsp.Code, ShouldEqual, http.StatusNotFound)
+				})
+			})
+		}
+
+		Convey("MemoryStore evicts old profiles", func() {
+			store := &MemoryStore{Size: 2}
+			for i := 0; i < 3; i++ {
+				_, rec := Start(c, req, store)
+				So(rec.Finish(), ShouldBeNil)
+			}
+			sums, err := store.List(c)
+			So(err, ShouldBeNil)
+			So(len(sums), ShouldEqual, 2)
+		})
+	})
+}
+
+func typeName(v interface{}) string {
+	return fmt.Sprintf("%T", v)
+}
+
+func mustRequest(url string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
@@ -0,0 +1,24 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package appstats is a request profiler in the spirit of the classic App
+// Engine appstats tool.
+//
+// It records every RPC (datastore, memcache, taskqueue, mail and urlfetch
+// call) made while handling a request, along with the call stack which issued
+// it, and saves the resulting Profile to a Store. Handler serves a small web
+// UI which lists recent profiles and drills down into their RPCs. The "calls
+// by site" table of a profile makes N+1 query patterns stand out: they show up
+// as a single call site issuing the same RPC many times.
+//
+// Typical usage in an HTTP handler:
+//   func handler(w http.ResponseWriter, r *http.Request) {
+//     c, rec := appstats.Start(prod.UseRequest(r), r, store)
+//     defer rec.Finish()
+//     ...
+//   }
+//
+// The RPCs are intercepted using the filters in the
+// "github.com/luci/gae/filter/trace" package.
+package appstats
@@ -0,0 +1,115 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package appstats
+
+import (
+	"html/template"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// Handler returns an http.Handler which serves the profiles in store.
+//
+// Without parameters it lists the recent profiles. With an "id" query
+// parameter it shows the RPCs of that profile.
+//
+// getContext returns the context to access store with for a given request
+// (e.g. prod.UseRequest).
+func Handler(store Store, getContext func(*http.Request) context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := getContext(r)
+
+		if id := r.FormValue("id"); id != "" {
+			p, err := store.Get(c, id)
+			if err == ErrNoSuchProfile {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			render(w, profileTemplate, p)
+			return
+		}
+
+		summaries, err := store.List(c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		render(w, listTemplate, summaries)
+	})
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+const style = `<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+.err { color: #c00; }
+.stack { font-family: monospace; white-space: pre; }
+</style>`
+
+var listTemplate = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html><head><title>appstats</title>` + style + `</head><body>
+<h1>Recent requests</h1>
+<table>
+<tr><th>Start</th><th>Request</th><th>Duration</th><th>RPCs</th></tr>
+{{range .}}<tr>
+<td>{{.Start.Format "2006-01-02 15:04:05.000"}}</td>
+<td><a href="?id={{.ID}}">{{.Method}} {{.URL}}</a></td>
+<td>{{.Duration}}</td>
+<td>{{.NumRPCs}}</td>
+</tr>{{else}}<tr><td colspan="4">No profiles recorded.</td></tr>{{end}}
+</table>
+</body></html>`))
+
+var profileTemplate = template.Must(template.New("profile").Parse(`<!DOCTYPE html>
+<html><head><title>appstats: {{.Method}} {{.URL}}</title>` + style + `</head><body>
+<p><a href="?">&laquo; all requests</a></p>
+<h1>{{.Method}} {{.URL}}</h1>
+<p>Started {{.Start.Format "2006-01-02 15:04:05.000"}}, took {{.Duration}}, {{.NumRPCs}} RPCs.</p>
+
+<h2>RPCs by name</h2>
+<table>
+<tr><th>RPC</th><th>Count</th><th>Total time</th></tr>
+{{range .ByName}}<tr><td>{{.Name}}</td><td>{{.Count}}</td><td>{{.Total}}</td></tr>{{end}}
+</table>
+
+<h2>RPCs by call site</h2>
+<table>
+<tr><th>RPC</th><th>Site</th><th>Count</th><th>Total time</th></tr>
+{{range .BySite}}<tr><td>{{.Name}}</td><td>{{.Site}}</td><td>{{.Count}}</td><td>{{.Total}}</td></tr>{{end}}
+</table>
+
+<h2>Timeline</h2>
+<table>
+<tr><th>#</th><th>Parent</th><th>Start</th><th>Duration</th><th>RPC</th><th>Details</th><th>Stack</th></tr>
+{{range .RPCs}}<tr>
+<td>{{.ID}}</td>
+<td>{{if .ParentID}}{{.ParentID}}{{end}}</td>
+<td>+{{.Offset}}</td>
+<td>{{.Duration}}</td>
+<td>{{.Name}}</td>
+<td>
+size={{.Size}} bytes={{.Bytes}}
+{{if .Kinds}}<br>kinds: {{range .Kinds}}{{.}} {{end}}{{end}}
+{{if .Query}}<br>query: {{.Query}}{{end}}
+{{if .Keys}}<br>keys: {{range .Keys}}{{.}} {{end}}{{end}}
+{{if .Error}}<br><span class="err">{{.Error}}</span>{{end}}
+</td>
+<td class="stack">{{range .Stack}}{{.}}
+{{end}}</td>
+</tr>{{end}}
+</table>
+</body></html>`))
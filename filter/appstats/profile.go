@@ -0,0 +1,158 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package appstats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/luci/gae/filter/trace"
+)
+
+// Frame is a single frame of an RPC's call stack.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s (%s:%d)", f.Function, f.File, f.Line)
+}
+
+// RPC is a single recorded service call.
+type RPC struct {
+	// ID and ParentID are the span IDs of the RPC (see trace.Span).
+	ID       int
+	ParentID int
+
+	Service string
+	Method  string
+
+	// Offset is the time at which the RPC started, relative to the start of
+	// the request.
+	Offset   time.Duration
+	Duration time.Duration
+
+	Size  int
+	Bytes int64
+	Keys  []string
+	Kinds []string
+	Query string
+
+	// Error is the error string returned by the RPC, if any.
+	Error string
+
+	// Stack is the call stack which issued the RPC, innermost frame first. It
+	// omits the frames belonging to this library.
+	Stack []Frame
+}
+
+// Name returns "Service.Method".
+func (r *RPC) Name() string {
+	return r.Service + "." + r.Method
+}
+
+// Site returns the innermost frame of the RPC's stack, or the zero Frame if
+// the stack is empty.
+func (r *RPC) Site() Frame {
+	if len(r.Stack) == 0 {
+		return Frame{}
+	}
+	return r.Stack[0]
+}
+
+func newRPC(s *trace.Span, reqStart time.Time, stack []Frame) *RPC {
+	ret := &RPC{
+		ID:       s.ID,
+		ParentID: s.ParentID,
+		Service:  s.Service,
+		Method:   s.Method,
+		Offset:   s.Start.Sub(reqStart),
+		Duration: s.Duration(),
+		Size:     s.Size,
+		Bytes:    s.Bytes,
+		Keys:     s.Keys,
+		Kinds:    s.Kinds,
+		Query:    s.Query,
+		Stack:    stack,
+	}
+	if s.Err != nil {
+		ret.Error = s.Err.Error()
+	}
+	return ret
+}
+
+// Summary is the overview of a Profile, as shown in the profile list.
+type Summary struct {
+	ID     string
+	Method string
+	URL    string
+
+	Start    time.Time
+	Duration time.Duration
+
+	NumRPCs int
+}
+
+// Profile is the RPC timeline of a single request.
+type Profile struct {
+	Summary
+
+	// RPCs are the recorded RPCs, ordered by start time.
+	RPCs []*RPC
+}
+
+// Stat is an aggregate of a group of RPCs.
+type Stat struct {
+	Name  string
+	Site  Frame
+	Count int
+	Total time.Duration
+}
+
+// ByName aggregates the profile's RPCs by their Name, most frequent first.
+func (p *Profile) ByName() []*Stat {
+	return p.aggregate(false)
+}
+
+// BySite aggregates the profile's RPCs by their Name and call Site, most
+// frequent first. A high count for a single site usually indicates an N+1
+// access pattern (e.g. a Get in a loop over query results).
+func (p *Profile) BySite() []*Stat {
+	return p.aggregate(true)
+}
+
+func (p *Profile) aggregate(bySite bool) []*Stat {
+	type groupKey struct {
+		name string
+		site Frame
+	}
+	groups := map[groupKey]*Stat{}
+	ret := []*Stat(nil)
+	for _, r := range p.RPCs {
+		k := groupKey{name: r.Name()}
+		if bySite {
+			k.site = r.Site()
+		}
+		st := groups[k]
+		if st == nil {
+			st = &Stat{Name: k.name, Site: k.site}
+			groups[k] = st
+			ret = append(ret, st)
+		}
+		st.Count++
+		st.Total += r.Duration
+	}
+	sort.Stable(statsByCount(ret))
+	return ret
+}
+
+type statsByCount []*Stat
+
+func (s statsByCount) Len() int           { return len(s) }
+func (s statsByCount) Less(i, j int) bool { return s[i].Count > s[j].Count }
+func (s statsByCount) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,155 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package appstats
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/luci/gae/filter/trace"
+	"github.com/luci/luci-go/common/clock"
+	"github.com/luci/luci-go/common/mathrand"
+	"golang.org/x/net/context"
+)
+
+// maxStackDepth is the maximum number of frames captured for each RPC.
+const maxStackDepth = 32
+
+// Recorder accumulates the Profile of a single request. It's created by Start.
+type Recorder struct {
+	sync.Mutex
+
+	// c is the context without the tracing filters, used to save the profile
+	// without recording the save itself.
+	c     context.Context
+	store Store
+
+	prof     Profile
+	finished bool
+}
+
+var _ trace.Exporter = (*Recorder)(nil)
+
+// Start begins recording the profile of the request req. It installs the
+// tracing filters in c, and returns the new context, which should be used for
+// all service calls made while handling req.
+//
+// Call Recorder.Finish once the request is handled to save the profile to
+// store.
+func Start(c context.Context, req *http.Request, store Store) (context.Context, *Recorder) {
+	now := clock.Now(c)
+	r := &Recorder{
+		c:     c,
+		store: store,
+		prof: Profile{Summary: Summary{
+			ID:    fmt.Sprintf("%d-%08x", now.UnixNano(), mathrand.Get(c).Uint32()),
+			Start: now,
+		}},
+	}
+	if req != nil {
+		r.prof.Method = req.Method
+		r.prof.URL = req.URL.String()
+	}
+	c, _ = trace.Use(c, r)
+	return c, r
+}
+
+// Export implements trace.Exporter. It's called synchronously at the end of
+// every RPC, so the current stack is the stack which issued the RPC.
+func (r *Recorder) Export(s *trace.Span) {
+	rpc := newRPC(s, r.prof.Start, captureStack())
+
+	r.Lock()
+	defer r.Unlock()
+	if !r.finished {
+		r.prof.RPCs = append(r.prof.RPCs, rpc)
+	}
+}
+
+// Profile returns a copy of the profile recorded so far.
+func (r *Recorder) Profile() *Profile {
+	r.Lock()
+	defer r.Unlock()
+	return r.profileLocked()
+}
+
+func (r *Recorder) profileLocked() *Profile {
+	ret := r.prof
+	ret.RPCs = make([]*RPC, len(r.prof.RPCs))
+	copy(ret.RPCs, r.prof.RPCs)
+	sort.Stable(rpcsByStart(ret.RPCs))
+	ret.NumRPCs = len(ret.RPCs)
+	return &ret
+}
+
+// Finish stops recording and saves the profile to the Store passed to Start.
+// RPCs which finish after Finish is called are not recorded. Calling Finish
+// more than once is a no-op.
+func (r *Recorder) Finish() error {
+	r.Lock()
+	if r.finished {
+		r.Unlock()
+		return nil
+	}
+	r.finished = true
+	r.prof.Duration = clock.Now(r.c).Sub(r.prof.Start)
+	prof := r.profileLocked()
+	r.Unlock()
+
+	if r.store == nil {
+		return nil
+	}
+	return r.store.Save(r.c, prof)
+}
+
+type rpcsByStart []*RPC
+
+func (s rpcsByStart) Len() int      { return len(s) }
+func (s rpcsByStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s rpcsByStart) Less(i, j int) bool {
+	if s[i].Offset != s[j].Offset {
+		return s[i].Offset < s[j].Offset
+	}
+	return s[i].ID < s[j].ID
+}
+
+// captureStack returns the current call stack, omitting the frames of the Go
+// runtime, reflection, and this library (other than its tests).
+func captureStack() []Frame {
+	pcs := make([]uintptr, 128)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+
+	ret := make([]Frame, 0, maxStackDepth)
+	for _, pc := range pcs {
+		if len(ret) == maxStackDepth {
+			break
+		}
+		fn := runtime.FuncForPC(pc - 1)
+		if fn == nil {
+			continue
+		}
+		name := fn.Name()
+		file, line := fn.FileLine(pc - 1)
+		if isInternalFrame(name, file) {
+			continue
+		}
+		ret = append(ret, Frame{name, file, line})
+	}
+	return ret
+}
+
+func isInternalFrame(function, file string) bool {
+	switch {
+	case strings.HasPrefix(function, "runtime."), strings.HasPrefix(function, "reflect."):
+		return true
+	case strings.HasPrefix(function, "github.com/luci/gae/"):
+		return !strings.HasSuffix(file, "_test.go")
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package appstats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	mc "github.com/luci/gae/service/memcache"
+	"golang.org/x/net/context"
+)
+
+// DefaultSize is the default number of profiles retained by a Store.
+const DefaultSize = 100
+
+// ErrNoSuchProfile is returned by Store.Get when the profile doesn't exist
+// (or has been evicted).
+var ErrNoSuchProfile = errors.New("appstats: no such profile")
+
+// Store saves recent profiles.
+type Store interface {
+	// Save stores p, possibly evicting older profiles.
+	Save(c context.Context, p *Profile) error
+
+	// List returns the summaries of the stored profiles, most recent first.
+	List(c context.Context) ([]*Summary, error)
+
+	// Get returns the profile with the given ID, or ErrNoSuchProfile.
+	Get(c context.Context, id string) (*Profile, error)
+}
+
+// MemoryStore is a Store which retains the most recent profiles in memory.
+//
+// The zero value is ready to use, and retains DefaultSize profiles.
+type MemoryStore struct {
+	sync.Mutex
+
+	// Size is the number of profiles to retain. If it's <= 0, DefaultSize is
+	// used.
+	Size int
+
+	// profiles is ordered oldest first.
+	profiles []*Profile
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// Save implements Store.
+func (m *MemoryStore) Save(_ context.Context, p *Profile) error {
+	m.Lock()
+	defer m.Unlock()
+	m.profiles = append(m.profiles, p)
+	size := m.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if extra := len(m.profiles) - size; extra > 0 {
+		m.profiles = append([]*Profile(nil), m.profiles[extra:]...)
+	}
+	return nil
+}
+
+// List implements Store.
+func (m *MemoryStore) List(context.Context) ([]*Summary, error) {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]*Summary, len(m.profiles))
+	for i, p := range m.profiles {
+		s := p.Summary
+		ret[len(ret)-1-i] = &s
+	}
+	return ret, nil
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, id string) (*Profile, error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, p := range m.profiles {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return nil, ErrNoSuchProfile
+}
+
+// MemcacheStore is a Store which keeps profiles in memcache, so that they're
+// visible to all instances of the application.
+//
+// The zero value is ready to use.
+type MemcacheStore struct {
+	// Prefix is prepended to all memcache keys used by the store. If it's
+	// empty, "appstats:" is used.
+	Prefix string
+
+	// Size is the number of profiles to list. If it's <= 0, DefaultSize is
+	// used.
+	Size int
+
+	// Expiration is the memcache expiration of the saved profiles. If it's 0,
+	// profiles don't expire (but are still subject to memcache eviction).
+	Expiration time.Duration
+}
+
+var _ Store = (*MemcacheStore)(nil)
+
+// maxIndexUpdateAttempts is the number of times MemcacheStore tries to update
+// its index in the face of concurrent updates.
+const maxIndexUpdateAttempts = 5
+
+func (m *MemcacheStore) prefix() string {
+	if m.Prefix == "" {
+		return "appstats:"
+	}
+	return m.Prefix
+}
+
+func (m *MemcacheStore) indexKey() string {
+	return m.prefix() + "index"
+}
+
+func (m *MemcacheStore) profileKey(id string) string {
+	return m.prefix() + "profile:" + id
+}
+
+// Save implements Store.
+func (m *MemcacheStore) Save(c context.Context, p *Profile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	mci := mc.Get(c)
+	itm := mci.NewItem(m.profileKey(p.ID)).SetValue(data).SetExpiration(m.Expiration)
+	if err := mci.Set(itm); err != nil {
+		return err
+	}
+
+	size := m.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	for i := 0; i < maxIndexUpdateAttempts; i++ {
+		idx, err := mci.Get(m.indexKey())
+		summaries := []*Summary(nil)
+		switch err {
+		case nil:
+			if err := json.Unmarshal(idx.Value(), &summaries); err != nil {
+				return fmt.Errorf("appstats: corrupt index: %s", err)
+			}
+		case mc.ErrCacheMiss:
+			idx = nil
+		default:
+			return err
+		}
+
+		summaries = append([]*Summary{&p.Summary}, summaries...)
+		if len(summaries) > size {
+			summaries = summaries[:size]
+		}
+		data, err := json.Marshal(summaries)
+		if err != nil {
+			return err
+		}
+
+		if idx == nil {
+			err = mci.Add(mci.NewItem(m.indexKey()).SetValue(data))
+		} else {
+			err = mci.CompareAndSwap(idx.SetValue(data))
+		}
+		switch err {
+		case nil:
+			return nil
+		case mc.ErrCASConflict, mc.ErrNotStored:
+			continue
+		default:
+			return err
+		}
+	}
+	return errors.New("appstats: too much contention updating the index")
+}
+
+// List implements Store.
+func (m *MemcacheStore) List(c context.Context) ([]*Summary, error) {
+	idx, err := mc.Get(c).Get(m.indexKey())
+	switch err {
+	case nil:
+	case mc.ErrCacheMiss:
+		return nil, nil
+	default:
+		return nil, err
+	}
+	ret := []*Summary(nil)
+	if err := json.Unmarshal(idx.Value(), &ret); err != nil {
+		return nil, fmt.Errorf("appstats: corrupt index: %s", err)
+	}
+	return ret, nil
+}
+
+// Get implements Store.
+func (m *MemcacheStore) Get(c context.Context, id string) (*Profile, error) {
+	itm, err := mc.Get(c).Get(m.profileKey(id))
+	switch err {
+	case nil:
+	case mc.ErrCacheMiss:
+		return nil, ErrNoSuchProfile
+	default:
+		return nil, err
+	}
+	ret := &Profile{}
+	if err := json.Unmarshal(itm.Value(), ret); err != nil {
+		return nil, fmt.Errorf("appstats: corrupt profile %q: %s", id, err)
+	}
+	return ret, nil
+}
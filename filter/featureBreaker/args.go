@@ -0,0 +1,42 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package featureBreaker
+
+import (
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/mail"
+	mc "github.com/luci/gae/service/memcache"
+	tq "github.com/luci/gae/service/taskqueue"
+)
+
+// Args holds the decoded arguments of a call, as seen by a BreakPredicate.
+//
+// Only the fields which are relevant to the called feature are populated.
+// The predicate must not modify any of them.
+type Args struct {
+	// Keys holds the datastore keys of GetMulti, PutMulti and DeleteMulti. For
+	// AllocateIDs it holds the single incomplete key.
+	Keys []*ds.Key
+	// Values holds the datastore values of PutMulti.
+	Values []ds.PropertyMap
+	// Query holds the datastore query of Run and Count.
+	Query *ds.FinalizedQuery
+
+	// MCKeys holds the memcache keys of GetMulti and DeleteMulti. For Increment
+	// it holds the single incremented key.
+	MCKeys []string
+	// MCItems holds the memcache items of AddMulti, SetMulti and
+	// CompareAndSwapMulti.
+	MCItems []mc.Item
+
+	// Tasks holds the tasks of taskqueue's AddMulti and DeleteMulti.
+	Tasks []*tq.Task
+	// QueueNames holds the queue names of taskqueue's Stats. For AddMulti,
+	// DeleteMulti and Purge it holds the single target queue.
+	QueueNames []string
+
+	// Message holds the message of mail's Send and SendToAdmins.
+	Message *mail.Message
+}
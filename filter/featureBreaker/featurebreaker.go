@@ -5,11 +5,12 @@
 package featureBreaker
 
 import (
-	"errors"
 	"fmt"
 	"runtime"
 	"strings"
 	"sync"
+
+	"github.com/luci/luci-go/common/errors"
 )
 
 // FeatureBreaker is the state-access interface for all Filter* functions in
@@ -29,12 +30,38 @@ import (
 // You may also pass nil as the error for BreakFeatures, and the fake will
 // provide the DefaultError which you passed to the Filter function.
 //
+// For finer control, BreakFeaturesWithPredicate lets you decide at call time
+// (based on the call's arguments) whether a feature should fail, and for batch
+// methods (e.g. PutMulti), which elements of the batch should fail:
+//   fb.BreakFeaturesWithPredicate(func(feature string, args *Args) error {
+//     me := make(errors.MultiError, len(args.Keys))
+//     for i, k := range args.Keys {
+//       if k.Kind() == "Order" {
+//         me[i] = datastore.ErrInvalidKey
+//       }
+//     }
+//     return me
+//   }, "PutMulti")
+//
 // This interface can only break features which return errors.
 type FeatureBreaker interface {
 	BreakFeatures(err error, feature ...string)
+	BreakFeaturesWithPredicate(pred BreakPredicate, feature ...string)
 	UnbreakFeatures(feature ...string)
 }
 
+// BreakPredicate decides if a call to a feature should be broken. It's given
+// the name of the feature and the decoded arguments of the call.
+//
+// Returning nil lets the call proceed normally. Returning an
+// errors.MultiError whose length matches the number of elements in a batch
+// call (e.g. the keys of GetMulti, the items of memcache's SetMulti or the
+// tasks of AddMulti) breaks only the elements with a non-nil error: the
+// remaining elements are passed through to the underlying service, and the
+// callback sees the per-element errors in the original order. Any other
+// error fails the entire call.
+type BreakPredicate func(feature string, args *Args) error
+
 // ErrBrokenFeaturesBroken is returned from RunIfNotBroken when BrokenFeatures
 // itself isn't working correctly.
 var ErrBrokenFeaturesBroken = errors.New("featureBreaker: Unable to retrieve caller information")
@@ -42,7 +69,8 @@ var ErrBrokenFeaturesBroken = errors.New("featureBreaker: Unable to retrieve cal
 type state struct {
 	sync.Mutex
 
-	broken map[string]error
+	broken     map[string]error
+	predicates map[string]BreakPredicate
 
 	// defaultError is the default error to return when you call
 	// BreakFeatures(nil, ...). If this is unset and the user calls BreakFeatures
@@ -53,6 +81,7 @@ type state struct {
 func newState(dflt error) *state {
 	return &state{
 		broken:       map[string]error{},
+		predicates:   map[string]BreakPredicate{},
 		defaultError: dflt,
 	}
 }
@@ -69,6 +98,19 @@ func (s *state) BreakFeatures(err error, feature ...string) {
 	defer s.Unlock()
 	for _, f := range feature {
 		s.broken[f] = err
+		delete(s.predicates, f)
+	}
+}
+
+// BreakFeaturesWithPredicate causes the named features to consult pred on
+// every call. See BreakPredicate for how its return value is interpreted. This
+// replaces any previous BreakFeatures setting for the named features.
+func (s *state) BreakFeaturesWithPredicate(pred BreakPredicate, feature ...string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, f := range feature {
+		s.predicates[f] = pred
+		delete(s.broken, f)
 	}
 }
 
@@ -79,6 +121,7 @@ func (s *state) UnbreakFeatures(feature ...string) {
 	defer s.Unlock()
 	for _, f := range feature {
 		delete(s.broken, f)
+		delete(s.predicates, f)
 	}
 }
 
@@ -86,32 +129,144 @@ func (s *state) run(f func() error) error {
 	if s.noBrokenFeatures() {
 		return f()
 	}
+	if _, err := s.check(callerName(1), nil, 0); err != nil {
+		return err
+	}
+	return f()
+}
+
+// runArgs is like run, but makes args available to the predicate of the
+// calling feature, if there is one.
+func (s *state) runArgs(args *Args, f func() error) error {
+	if s.noBrokenFeatures() {
+		return f()
+	}
+	if _, err := s.check(callerName(1), args, 0); err != nil {
+		return err
+	}
+	return f()
+}
 
-	pc, _, _, _ := runtime.Caller(1)
-	fullName := runtime.FuncForPC(pc).Name()
-	fullNameParts := strings.Split(fullName, ".")
-	name := fullNameParts[len(fullNameParts)-1]
+// checkMulti determines the breakage of a batch call with n elements made by
+// the calling feature.
+//
+// If the entire call should fail, it returns a non-nil error. Otherwise, if
+// some of the elements should fail, it returns a MultiError of length n with
+// the errors for those elements. If nothing is broken, both are nil.
+func (s *state) checkMulti(args *Args, n int) (errors.MultiError, error) {
+	if s.noBrokenFeatures() {
+		return nil, nil
+	}
+	return s.check(callerName(1), args, n)
+}
 
+func (s *state) check(name string, args *Args, n int) (errors.MultiError, error) {
 	s.Lock()
 	err, ok := s.broken[name]
+	pred := s.predicates[name]
 	dflt := s.defaultError
 	s.Unlock()
 
 	if ok {
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if dflt != nil {
-			return dflt
+			return nil, dflt
 		}
-		return fmt.Errorf("feature %q is broken", name)
+		return nil, fmt.Errorf("feature %q is broken", name)
 	}
 
-	return f()
+	if pred == nil {
+		return nil, nil
+	}
+	if args == nil {
+		args = &Args{}
+	}
+	err = pred(name, args)
+	if err == nil {
+		return nil, nil
+	}
+	if me, ok := err.(errors.MultiError); ok && n > 0 && len(me) == n {
+		for _, e := range me {
+			if e != nil {
+				return me, nil
+			}
+		}
+		return nil, nil
+	}
+	return nil, err
+}
+
+// liveIndexes returns the indexes of the elements in broken which should be
+// passed through to the underlying service.
+func liveIndexes(broken errors.MultiError) []int {
+	ret := make([]int, 0, len(broken))
+	for i, e := range broken {
+		if e == nil {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// runLive runs a batch call which checkMulti has partially broken. call is
+// invoked with the indexes of the elements which aren't broken (if there are
+// any), and should pass those elements through to the underlying service.
+// Then emit is invoked once per element in the original order, with that
+// element's broken error, or nil if the element was live.
+func runLive(broken errors.MultiError, call func(live []int) error, emit func(i int, err error) error) error {
+	if live := liveIndexes(broken); len(live) > 0 {
+		if err := call(live); err != nil {
+			return err
+		}
+	}
+	for i, err := range broken {
+		if err := emit(i, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLiveErrs is runLive for batch calls whose callback only receives an
+// error. call must invoke its callback once per live element, in order, and cb
+// is invoked once per element in the original order.
+func runLiveErrs(broken errors.MultiError, cb func(error) error, call func(live []int, cb func(error)) error) error {
+	errs := make([]error, len(broken))
+	return runLive(broken, func(live []int) error {
+		j := 0
+		return call(live, func(err error) {
+			errs[live[j]] = err
+			j++
+		})
+	}, func(i int, err error) error {
+		if err == nil {
+			err = errs[i]
+		}
+		return cb(err)
+	})
+}
+
+// ignoreErr adapts a callback which can't stop iteration for runLiveErrs.
+func ignoreErr(cb func(error)) func(error) error {
+	return func(err error) error {
+		cb(err)
+		return nil
+	}
+}
+
+// callerName returns the name of the method which is skip frames above the
+// caller of callerName.
+func callerName(skip int) string {
+	pc, _, _, _ := runtime.Caller(skip + 1)
+	fullName := runtime.FuncForPC(pc).Name()
+	fullNameParts := strings.Split(fullName, ".")
+	return fullNameParts[len(fullNameParts)-1]
 }
 
 func (s *state) noBrokenFeatures() bool {
 	s.Lock()
 	defer s.Unlock()
-	return len(s.broken) == 0
+	return len(s.broken) == 0 && len(s.predicates) == 0
 }
@@ -5,10 +5,13 @@
 package featureBreaker
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/luci/gae/impl/memory"
 	"github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/memcache"
+	"github.com/luci/gae/service/taskqueue"
 	"github.com/luci/luci-go/common/errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/context"
@@ -61,6 +64,93 @@ func TestBrokenFeatures(t *testing.T) {
 				bf.BreakFeatures(nil, "GetMulti")
 				So(ds.GetMulti(vals), ShouldEqual, e)
 			})
+
+			Convey("with a predicate", func() {
+				c, bf := FilterRDS(c, nil)
+				ds := datastore.Get(c)
+				vals := []datastore.PropertyMap{
+					{"$key": {datastore.MkPropertyNI(ds.NewKey("Order", "", 1, nil))}},
+					{"$key": {datastore.MkPropertyNI(ds.NewKey("Item", "", 1, nil))}},
+					{"$key": {datastore.MkPropertyNI(ds.NewKey("Order", "", 2, nil))}},
+				}
+
+				bf.BreakFeaturesWithPredicate(func(feature string, args *Args) error {
+					So(feature, ShouldEqual, "PutMulti")
+					me := make(errors.MultiError, len(args.Keys))
+					for i, k := range args.Keys {
+						if k.Kind() == "Order" {
+							me[i] = e
+						}
+					}
+					return me
+				}, "PutMulti")
+
+				Convey("can break some elements of a batch", func() {
+					So(ds.PutMulti(vals), ShouldResemble, errors.MultiError{e, nil, e})
+
+					exists, err := ds.ExistsMulti([]*datastore.Key{
+						ds.NewKey("Order", "", 1, nil),
+						ds.NewKey("Item", "", 1, nil),
+					})
+					So(err, ShouldBeNil)
+					So(exists, ShouldResemble, []bool{false, true})
+				})
+
+				Convey("passes everything through if nothing matches", func() {
+					So(ds.PutMulti(vals[1:2]), ShouldBeNil)
+				})
+
+				Convey("can break the entire call", func() {
+					bf.BreakFeaturesWithPredicate(func(string, *Args) error { return e }, "PutMulti")
+					So(ds.PutMulti(vals), ShouldEqual, e)
+				})
+
+				Convey("is replaced by UnbreakFeatures", func() {
+					bf.UnbreakFeatures("PutMulti")
+					So(ds.PutMulti(vals), ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("Can break mc by key prefix", func() {
+			c, bf := FilterMC(c, nil)
+			mc := memcache.Get(c)
+
+			bf.BreakFeaturesWithPredicate(func(_ string, args *Args) error {
+				me := make(errors.MultiError, len(args.MCItems))
+				for i, itm := range args.MCItems {
+					if strings.HasPrefix(itm.Key(), "sess:") {
+						me[i] = memcache.ErrServerError
+					}
+				}
+				return me
+			}, "SetMulti")
+
+			So(mc.SetMulti([]memcache.Item{
+				mc.NewItem("sess:1").SetValue([]byte("hi")),
+				mc.NewItem("other").SetValue([]byte("there")),
+			}), ShouldResemble, errors.MultiError{memcache.ErrServerError, nil})
+
+			_, err := mc.Get("sess:1")
+			So(err, ShouldEqual, memcache.ErrCacheMiss)
+			itm, err := mc.Get("other")
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldResemble, []byte("there"))
+		})
+
+		Convey("Can break tq by queue name", func() {
+			c, bf := FilterTQ(c, nil)
+			tq := taskqueue.Get(c)
+
+			bf.BreakFeaturesWithPredicate(func(_ string, args *Args) error {
+				if args.QueueNames[0] == "emails" {
+					return e
+				}
+				return nil
+			}, "AddMulti")
+
+			So(tq.Add(&taskqueue.Task{Path: "/hi"}, "emails"), ShouldEqual, e)
+			So(tq.Add(&taskqueue.Task{Path: "/hi"}, ""), ShouldBeNil)
 		})
 	})
 }
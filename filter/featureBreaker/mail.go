@@ -18,11 +18,11 @@ type mailState struct {
 var _ mail.Interface = (*mailState)(nil)
 
 func (m *mailState) Send(msg *mail.Message) error {
-	return m.run(func() error { return m.Interface.Send(msg) })
+	return m.runArgs(&Args{Message: msg}, func() error { return m.Interface.Send(msg) })
 }
 
 func (m *mailState) SendToAdmins(msg *mail.Message) error {
-	return m.run(func() error { return m.Interface.SendToAdmins(msg) })
+	return m.runArgs(&Args{Message: msg}, func() error { return m.Interface.SendToAdmins(msg) })
 }
 
 // FilterMail installs a counter mail filter in the context.
@@ -17,23 +17,87 @@ type mcState struct {
 }
 
 func (m *mcState) GetMulti(keys []string, cb mc.RawItemCB) error {
-	return m.run(func() error { return m.RawInterface.GetMulti(keys, cb) })
+	broken, err := m.checkMulti(&Args{MCKeys: keys}, len(keys))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
+		return m.RawInterface.GetMulti(keys, cb)
+	}
+
+	type result struct {
+		itm mc.Item
+		err error
+	}
+	results := make([]result, len(keys))
+	return runLive(broken, func(live []int) error {
+		liveKeys := make([]string, len(live))
+		for j, i := range live {
+			liveKeys[j] = keys[i]
+		}
+		j := 0
+		return m.RawInterface.GetMulti(liveKeys, func(itm mc.Item, err error) {
+			results[live[j]] = result{itm, err}
+			j++
+		})
+	}, func(i int, err error) error {
+		if err != nil {
+			cb(nil, err)
+		} else {
+			cb(results[i].itm, results[i].err)
+		}
+		return nil
+	})
 }
 
 func (m *mcState) AddMulti(items []mc.Item, cb mc.RawCB) error {
-	return m.run(func() error { return m.RawInterface.AddMulti(items, cb) })
+	broken, err := m.checkMulti(&Args{MCItems: items}, len(items))
+	if err != nil {
+		return err
+	}
+	return m.runItems(items, broken, cb, m.RawInterface.AddMulti)
 }
 
 func (m *mcState) SetMulti(items []mc.Item, cb mc.RawCB) error {
-	return m.run(func() error { return m.RawInterface.SetMulti(items, cb) })
+	broken, err := m.checkMulti(&Args{MCItems: items}, len(items))
+	if err != nil {
+		return err
+	}
+	return m.runItems(items, broken, cb, m.RawInterface.SetMulti)
 }
 
 func (m *mcState) DeleteMulti(keys []string, cb mc.RawCB) error {
-	return m.run(func() error { return m.RawInterface.DeleteMulti(keys, cb) })
+	broken, err := m.checkMulti(&Args{MCKeys: keys}, len(keys))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
+		return m.RawInterface.DeleteMulti(keys, cb)
+	}
+
+	return runLiveErrs(broken, ignoreErr(cb), func(live []int, cb func(error)) error {
+		liveKeys := make([]string, len(live))
+		for j, i := range live {
+			liveKeys[j] = keys[i]
+		}
+		return m.RawInterface.DeleteMulti(liveKeys, cb)
+	})
 }
 
 func (m *mcState) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
-	return m.run(func() error { return m.RawInterface.CompareAndSwapMulti(items, cb) })
+	broken, err := m.checkMulti(&Args{MCItems: items}, len(items))
+	if err != nil {
+		return err
+	}
+	return m.runItems(items, broken, cb, m.RawInterface.CompareAndSwapMulti)
+}
+
+func (m *mcState) Increment(key string, delta int64, initialValue *uint64) (ret uint64, err error) {
+	err = m.runArgs(&Args{MCKeys: []string{key}}, func() (err error) {
+		ret, err = m.RawInterface.Increment(key, delta, initialValue)
+		return
+	})
+	return
 }
 
 func (m *mcState) Flush() error {
@@ -48,6 +112,21 @@ func (m *mcState) Stats() (ret *mc.Statistics, err error) {
 	return
 }
 
+// runItems invokes f with the items which aren't broken, and calls cb once
+// per item in the original order.
+func (m *mcState) runItems(items []mc.Item, broken []error, cb mc.RawCB, f func([]mc.Item, mc.RawCB) error) error {
+	if broken == nil {
+		return f(items, cb)
+	}
+	return runLiveErrs(broken, ignoreErr(cb), func(live []int, cb func(error)) error {
+		liveItems := make([]mc.Item, len(live))
+		for j, i := range live {
+			liveItems[j] = items[i]
+		}
+		return f(liveItems, cb)
+	})
+}
+
 // FilterMC installs a counter mc filter in the context.
 func FilterMC(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
@@ -18,7 +18,7 @@ type dsState struct {
 
 func (r *dsState) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
 	start := int64(0)
-	err := r.run(func() (err error) {
+	err := r.runArgs(&Args{Keys: []*ds.Key{incomplete}}, func() (err error) {
 		start, err = r.rds.AllocateIDs(incomplete, n)
 		return
 	})
@@ -35,14 +35,14 @@ func (r *dsState) DecodeCursor(s string) (ds.Cursor, error) {
 }
 
 func (r *dsState) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
-	return r.run(func() error {
+	return r.runArgs(&Args{Query: q}, func() error {
 		return r.rds.Run(q, cb)
 	})
 }
 
 func (r *dsState) Count(q *ds.FinalizedQuery) (int64, error) {
 	count := int64(0)
-	err := r.run(func() (err error) {
+	err := r.runArgs(&Args{Query: q}, func() (err error) {
 		count, err = r.rds.Count(q)
 		return
 	})
@@ -55,24 +55,94 @@ func (r *dsState) RunInTransaction(f func(c context.Context) error, opts *ds.Tra
 	})
 }
 
-// TODO(riannucci): Allow the user to specify a multierror which will propagate
-// to the callback correctly.
-
 func (r *dsState) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
-	return r.run(func() error {
+	broken, err := r.checkMulti(&Args{Keys: keys}, len(keys))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
 		return r.rds.DeleteMulti(keys, cb)
+	}
+
+	return runLiveErrs(broken, cb, func(live []int, cb func(error)) error {
+		liveKeys := make([]*ds.Key, len(live))
+		for j, i := range live {
+			liveKeys[j] = keys[i]
+		}
+		return r.rds.DeleteMulti(liveKeys, func(err error) error {
+			cb(err)
+			return nil
+		})
 	})
 }
 
 func (r *dsState) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
-	return r.run(func() error {
+	broken, err := r.checkMulti(&Args{Keys: keys}, len(keys))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
 		return r.rds.GetMulti(keys, meta, cb)
+	}
+
+	type result struct {
+		pm  ds.PropertyMap
+		err error
+	}
+	results := make([]result, len(keys))
+	return runLive(broken, func(live []int) error {
+		liveKeys := make([]*ds.Key, len(live))
+		liveMeta := make(ds.MultiMetaGetter, len(live))
+		for j, i := range live {
+			liveKeys[j] = keys[i]
+			liveMeta[j] = meta.GetSingle(i)
+		}
+		j := 0
+		return r.rds.GetMulti(liveKeys, liveMeta, func(pm ds.PropertyMap, err error) error {
+			results[live[j]] = result{pm, err}
+			j++
+			return nil
+		})
+	}, func(i int, err error) error {
+		if err != nil {
+			return cb(nil, err)
+		}
+		return cb(results[i].pm, results[i].err)
 	})
 }
 
 func (r *dsState) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
-	return r.run(func() (err error) {
+	broken, err := r.checkMulti(&Args{Keys: keys, Values: vals}, len(keys))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
 		return r.rds.PutMulti(keys, vals, cb)
+	}
+
+	type result struct {
+		key *ds.Key
+		err error
+	}
+	results := make([]result, len(keys))
+	return runLive(broken, func(live []int) error {
+		liveKeys := make([]*ds.Key, len(live))
+		liveVals := make([]ds.PropertyMap, len(live))
+		for j, i := range live {
+			liveKeys[j] = keys[i]
+			liveVals[j] = vals[i]
+		}
+		j := 0
+		return r.rds.PutMulti(liveKeys, liveVals, func(key *ds.Key, err error) error {
+			results[live[j]] = result{key, err}
+			j++
+			return nil
+		})
+	}, func(i int, err error) error {
+		if err != nil {
+			return cb(nil, err)
+		}
+		return cb(results[i].key, results[i].err)
 	})
 }
 
@@ -19,19 +19,63 @@ type tqState struct {
 var _ tq.RawInterface = (*tqState)(nil)
 
 func (t *tqState) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
-	return t.run(func() (err error) { return t.tq.AddMulti(tasks, queueName, cb) })
+	broken, err := t.checkMulti(&Args{Tasks: tasks, QueueNames: []string{queueName}}, len(tasks))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
+		return t.tq.AddMulti(tasks, queueName, cb)
+	}
+
+	type result struct {
+		task *tq.Task
+		err  error
+	}
+	results := make([]result, len(tasks))
+	return runLive(broken, func(live []int) error {
+		liveTasks := make([]*tq.Task, len(live))
+		for j, i := range live {
+			liveTasks[j] = tasks[i]
+		}
+		j := 0
+		return t.tq.AddMulti(liveTasks, queueName, func(task *tq.Task, err error) {
+			results[live[j]] = result{task, err}
+			j++
+		})
+	}, func(i int, err error) error {
+		if err != nil {
+			cb(nil, err)
+		} else {
+			cb(results[i].task, results[i].err)
+		}
+		return nil
+	})
 }
 
 func (t *tqState) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
-	return t.run(func() error { return t.tq.DeleteMulti(tasks, queueName, cb) })
+	broken, err := t.checkMulti(&Args{Tasks: tasks, QueueNames: []string{queueName}}, len(tasks))
+	if err != nil {
+		return err
+	}
+	if broken == nil {
+		return t.tq.DeleteMulti(tasks, queueName, cb)
+	}
+
+	return runLiveErrs(broken, ignoreErr(cb), func(live []int, cb func(error)) error {
+		liveTasks := make([]*tq.Task, len(live))
+		for j, i := range live {
+			liveTasks[j] = tasks[i]
+		}
+		return t.tq.DeleteMulti(liveTasks, queueName, cb)
+	})
 }
 
 func (t *tqState) Purge(queueName string) error {
-	return t.run(func() error { return t.tq.Purge(queueName) })
+	return t.runArgs(&Args{QueueNames: []string{queueName}}, func() error { return t.tq.Purge(queueName) })
 }
 
 func (t *tqState) Stats(queueNames []string, cb tq.RawStatsCB) error {
-	return t.run(func() error { return t.tq.Stats(queueNames, cb) })
+	return t.runArgs(&Args{QueueNames: queueNames}, func() error { return t.tq.Stats(queueNames, cb) })
 }
 
 func (t *tqState) Testable() tq.Testable {
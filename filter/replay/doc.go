@@ -0,0 +1,36 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package replay contains filters which record every call made to the
+// datastore, memcache, taskqueue and mail services into a Log, and which
+// replay a Log, serving responses from it instead of calling the underlying
+// services.
+//
+// This makes it possible to write golden tests for handlers: run the handler
+// once against a real (or memory) implementation with Record, save the Log,
+// and then verify that the handler still makes exactly the same calls by
+// running it with Replay:
+//   // record
+//   c, l := replay.Record(memory.Use(context.Background()))
+//   handler(c)
+//   l.Write(goldenFile)
+//
+//   // replay
+//   l, err := replay.ReadLog(goldenFile)
+//   c, r := replay.Replay(memory.Use(context.Background()), l)
+//   handler(c)
+//   if err := r.Done(); err != nil {
+//     t.Fatal(err)
+//   }
+//
+// Datastore keys and PropertyMaps are encoded in the Log using the
+// "github.com/luci/gae/service/datastore/serialize" package.
+//
+// During Replay, the underlying services are never called, though they must
+// still be installed in the context (e.g. with memory.Use). The one exception
+// is RunInTransaction, which runs every recorded attempt in an (empty)
+// transaction of the underlying datastore, so that the function sees a
+// transactional context. Any call which doesn't match the next entry in the
+// Log fails with a *DivergenceError.
+package replay
@@ -0,0 +1,238 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+
+	ds "github.com/luci/gae/service/datastore"
+)
+
+type dsReplay struct {
+	s *state
+
+	ds ds.RawInterface
+}
+
+var _ ds.RawInterface = (*dsReplay)(nil)
+
+func (r *dsReplay) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
+	args := Args{Keys: encodeKeys([]*ds.Key{incomplete}), N: int64(n)}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "AllocateIDs", args)
+		if err != nil {
+			return 0, err
+		}
+		return e.Value, decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "AllocateIDs", args)
+	start, err := r.ds.AllocateIDs(incomplete, n)
+	e.Value, e.Err = start, encodeErr(err)
+	return start, err
+}
+
+func (r *dsReplay) DecodeCursor(s string) (ds.Cursor, error) {
+	args := Args{Cursor: s}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "DecodeCursor", args)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeErr(e.Err); err != nil {
+			return nil, err
+		}
+		return cursor(s), nil
+	}
+
+	e := r.s.record("datastore", "DecodeCursor", args)
+	curs, err := r.ds.DecodeCursor(s)
+	e.Err = encodeErr(err)
+	return curs, err
+}
+
+func (r *dsReplay) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	args := Args{Query: q.GQL(), Cursors: encodeCursors(q)}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "Run", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			res := res
+			k, err := res.Key.decode()
+			if err != nil {
+				return err
+			}
+			pm, err := res.Value.decode()
+			if err != nil {
+				return err
+			}
+			err = cb(k, pm, func() (ds.Cursor, error) {
+				if res.Cursor == "" {
+					return nil, errors.New("replay: this cursor was not requested while recording")
+				}
+				return cursor(res.Cursor), nil
+			})
+			if err != nil {
+				if err == ds.Stop {
+					return nil
+				}
+				return err
+			}
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "Run", args)
+	err := r.ds.Run(q, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		res := &Result{Key: encodeKey(k), Value: encodePM(pm)}
+		e.Results = append(e.Results, res)
+		return cb(k, pm, func() (ds.Cursor, error) {
+			curs, err := gc()
+			if err == nil {
+				res.Cursor = curs.String()
+			}
+			return curs, err
+		})
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (r *dsReplay) Count(q *ds.FinalizedQuery) (int64, error) {
+	args := Args{Query: q.GQL(), Cursors: encodeCursors(q)}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "Count", args)
+		if err != nil {
+			return 0, err
+		}
+		return e.Value, decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "Count", args)
+	count, err := r.ds.Count(q)
+	e.Value, e.Err = count, encodeErr(err)
+	return count, err
+}
+
+func (r *dsReplay) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	args := Args{Txn: opts}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "RunInTransaction", args)
+		if err != nil {
+			return err
+		}
+		// Every recorded attempt runs in its own transaction of the underlying
+		// datastore, so that f sees a transactional context. The calls f makes
+		// are served from the log, so these transactions are always empty.
+		for i := 0; i < e.Attempts; i++ {
+			if err := r.ds.RunInTransaction(f, opts); err != nil {
+				return err
+			}
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "RunInTransaction", args)
+	err := r.ds.RunInTransaction(func(c context.Context) error {
+		e.Attempts++
+		return f(c)
+	}, opts)
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (r *dsReplay) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	args := Args{Keys: encodeKeys(keys)}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "DeleteMulti", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			if err := cb(decodeErr(res.Err)); err != nil {
+				return err
+			}
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "DeleteMulti", args)
+	err := r.ds.DeleteMulti(keys, func(err error) error {
+		e.Results = append(e.Results, &Result{Err: encodeErr(err)})
+		return cb(err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (r *dsReplay) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	args := Args{Keys: encodeKeys(keys)}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "GetMulti", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			pm, err := res.Value.decode()
+			if err != nil {
+				return err
+			}
+			if err := cb(pm, decodeErr(res.Err)); err != nil {
+				return err
+			}
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "GetMulti", args)
+	err := r.ds.GetMulti(keys, meta, func(pm ds.PropertyMap, err error) error {
+		e.Results = append(e.Results, &Result{Value: encodePM(pm), Err: encodeErr(err)})
+		return cb(pm, err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (r *dsReplay) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	args := Args{Keys: encodeKeys(keys), Values: encodePMs(vals)}
+	if r.s.replay {
+		e, err := r.s.next("datastore", "PutMulti", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			k, err := res.Key.decode()
+			if err != nil {
+				return err
+			}
+			if err := cb(k, decodeErr(res.Err)); err != nil {
+				return err
+			}
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := r.s.record("datastore", "PutMulti", args)
+	err := r.ds.PutMulti(keys, vals, func(k *ds.Key, err error) error {
+		e.Results = append(e.Results, &Result{Key: encodeKey(k), Err: encodeErr(err)})
+		return cb(k, err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (r *dsReplay) Testable() ds.Testable {
+	return r.ds.Testable()
+}
+
+func filterRDS(c context.Context, s *state) context.Context {
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		return &dsReplay{s, rds}
+	})
+}
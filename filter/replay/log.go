@@ -0,0 +1,301 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/datastore/serialize"
+	"github.com/luci/gae/service/mail"
+	mc "github.com/luci/gae/service/memcache"
+	tq "github.com/luci/gae/service/taskqueue"
+	"github.com/luci/luci-go/common/errors"
+)
+
+// Log is a recorded sequence of service calls.
+type Log struct {
+	Entries []*Entry
+}
+
+// ReadLog reads a Log previously written with Log.Write.
+func ReadLog(r io.Reader) (*Log, error) {
+	ret := &Log{}
+	if err := json.NewDecoder(r).Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Write writes the Log to w, in an indented JSON format.
+func (l *Log) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Entry is a single recorded call.
+type Entry struct {
+	// Service is the name of the called service (e.g. "datastore").
+	Service string
+	// Method is the name of the called method (e.g. "GetMulti").
+	Method string
+	// Args holds the arguments of the call.
+	Args Args
+
+	// Results holds the per-element results of the call, in callback order.
+	Results []*Result `json:",omitempty"`
+	// Value is the scalar result of AllocateIDs, Count and Increment.
+	Value int64 `json:",omitempty"`
+	// Attempts is the number of times the function passed to RunInTransaction
+	// was invoked.
+	Attempts int `json:",omitempty"`
+	// MCStats is the result of memcache's Stats.
+	MCStats *mc.Statistics `json:",omitempty"`
+	// Err is the overall error returned by the call.
+	Err *Error `json:",omitempty"`
+}
+
+func (e *Entry) String() string {
+	args, err := json.Marshal(&e.Args)
+	if err != nil {
+		args = []byte(err.Error())
+	}
+	return fmt.Sprintf("%s.%s(%s)", e.Service, e.Method, args)
+}
+
+// Args holds the encoded arguments of a call. Only the fields which are
+// relevant to the called method are populated.
+type Args struct {
+	Keys    []Key                  `json:",omitempty"`
+	Values  []PropertyMap          `json:",omitempty"`
+	Query   string                 `json:",omitempty"`
+	Cursors []string               `json:",omitempty"`
+	Cursor  string                 `json:",omitempty"`
+	N       int64                  `json:",omitempty"`
+	Initial *uint64                `json:",omitempty"`
+	Txn     *ds.TransactionOptions `json:",omitempty"`
+
+	MCKeys  []string `json:",omitempty"`
+	MCItems []*Item  `json:",omitempty"`
+
+	Queues []string   `json:",omitempty"`
+	Tasks  []*tq.Task `json:",omitempty"`
+
+	Message *mail.Message `json:",omitempty"`
+}
+
+func (a *Args) equal(o *Args) bool {
+	aData, aErr := json.Marshal(a)
+	oData, oErr := json.Marshal(o)
+	return aErr == nil && oErr == nil && bytes.Equal(aData, oData)
+}
+
+// Result is the result of a single element of a call.
+type Result struct {
+	Key     Key            `json:",omitempty"`
+	Value   *PropertyMap   `json:",omitempty"`
+	Cursor  string         `json:",omitempty"`
+	Item    *Item          `json:",omitempty"`
+	Task    *tq.Task       `json:",omitempty"`
+	TQStats *tq.Statistics `json:",omitempty"`
+	Err     *Error         `json:",omitempty"`
+}
+
+// Key is a datastore Key, serialized with its context.
+type Key []byte
+
+func encodeKey(k *ds.Key) Key {
+	if k == nil {
+		return nil
+	}
+	buf := bytes.Buffer{}
+	if err := serialize.WriteKey(&buf, serialize.WithContext, k); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeKeys(keys []*ds.Key) []Key {
+	ret := make([]Key, len(keys))
+	for i, k := range keys {
+		ret[i] = encodeKey(k)
+	}
+	return ret
+}
+
+func (k Key) decode() (*ds.Key, error) {
+	if k == nil {
+		return nil, nil
+	}
+	return serialize.ReadKey(bytes.NewBuffer(k), serialize.WithContext, "", "")
+}
+
+// PropertyMap is a datastore PropertyMap, with every property serialized
+// separately (so that the encoding is deterministic). Metadata is omitted.
+type PropertyMap map[string][][]byte
+
+func encodePM(pm ds.PropertyMap) *PropertyMap {
+	if pm == nil {
+		return nil
+	}
+	pm, _ = pm.Save(false)
+	ret := make(PropertyMap, len(pm))
+	buf := bytes.Buffer{}
+	for name, vals := range pm {
+		encVals := make([][]byte, len(vals))
+		for i, v := range vals {
+			buf.Reset()
+			if err := serialize.WriteProperty(&buf, serialize.WithContext, v); err != nil {
+				panic(err)
+			}
+			encVals[i] = append([]byte(nil), buf.Bytes()...)
+		}
+		ret[name] = encVals
+	}
+	return &ret
+}
+
+func encodePMs(pms []ds.PropertyMap) []PropertyMap {
+	ret := make([]PropertyMap, len(pms))
+	for i, pm := range pms {
+		if enc := encodePM(pm); enc != nil {
+			ret[i] = *enc
+		}
+	}
+	return ret
+}
+
+func (p *PropertyMap) decode() (ds.PropertyMap, error) {
+	if p == nil {
+		return nil, nil
+	}
+	ret := make(ds.PropertyMap, len(*p))
+	for name, encVals := range *p {
+		vals := make([]ds.Property, len(encVals))
+		for i, v := range encVals {
+			prop, err := serialize.ReadProperty(bytes.NewBuffer(v), serialize.WithContext, "", "")
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = prop
+		}
+		ret[name] = vals
+	}
+	return ret, nil
+}
+
+// Item is a memcache Item. The CAS ID of items isn't recorded.
+type Item struct {
+	Key        string
+	Value      []byte        `json:",omitempty"`
+	Flags      uint32        `json:",omitempty"`
+	Expiration time.Duration `json:",omitempty"`
+}
+
+func encodeItem(itm mc.Item) *Item {
+	if itm == nil {
+		return nil
+	}
+	return &Item{itm.Key(), itm.Value(), itm.Flags(), itm.Expiration()}
+}
+
+func encodeItems(items []mc.Item) []*Item {
+	ret := make([]*Item, len(items))
+	for i, itm := range items {
+		ret[i] = encodeItem(itm)
+	}
+	return ret
+}
+
+func (i *Item) decode(raw mc.RawInterface) mc.Item {
+	if i == nil {
+		return nil
+	}
+	return raw.NewItem(i.Key).SetValue(i.Value).SetFlags(i.Flags).SetExpiration(i.Expiration)
+}
+
+// knownErrors are the errors which are restored to their original values
+// (rather than to an equivalent errors.New value) when replaying.
+var knownErrors = []error{
+	ds.ErrInvalidKey,
+	ds.ErrNoSuchEntity,
+	ds.ErrConcurrentTransaction,
+	ds.Stop,
+	mc.ErrCacheMiss,
+	mc.ErrCASConflict,
+	mc.ErrNoStats,
+	mc.ErrNotStored,
+	mc.ErrServerError,
+	tq.ErrTaskAlreadyAdded,
+}
+
+// Error is an encoded error. errors.MultiErrors are encoded element by
+// element, so that the errors of the individual elements (e.g.
+// ErrNoSuchEntity) are restored when replaying.
+type Error struct {
+	Msg   string   `json:",omitempty"`
+	Multi []*Error `json:",omitempty"`
+}
+
+func encodeErr(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	ret := &Error{Msg: err.Error()}
+	if me, ok := err.(errors.MultiError); ok {
+		ret.Multi = make([]*Error, len(me))
+		for i, err := range me {
+			ret.Multi[i] = encodeErr(err)
+		}
+	}
+	return ret
+}
+
+func decodeErr(e *Error) error {
+	if e == nil {
+		return nil
+	}
+	if len(e.Multi) > 0 {
+		me := make(errors.MultiError, len(e.Multi))
+		for i, err := range e.Multi {
+			me[i] = decodeErr(err)
+		}
+		return me
+	}
+	for _, err := range knownErrors {
+		if err.Error() == e.Msg {
+			return err
+		}
+	}
+	return errors.New(e.Msg)
+}
+
+// cursor is the Cursor implementation returned during Replay.
+type cursor string
+
+func (c cursor) String() string { return string(c) }
+
+func encodeCursors(q *ds.FinalizedQuery) []string {
+	start, end := q.Bounds()
+	if start == nil && end == nil {
+		return nil
+	}
+	ret := []string{"", ""}
+	if start != nil {
+		ret[0] = start.String()
+	}
+	if end != nil {
+		ret[1] = end.String()
+	}
+	return ret
+}
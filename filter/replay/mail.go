@@ -0,0 +1,53 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"github.com/luci/gae/service/mail"
+	"golang.org/x/net/context"
+)
+
+type mailReplay struct {
+	s *state
+
+	m mail.Interface
+}
+
+var _ mail.Interface = (*mailReplay)(nil)
+
+func (m *mailReplay) send(method string, msg *mail.Message, f func(*mail.Message) error) error {
+	cpy := *msg
+	args := Args{Message: &cpy}
+	if m.s.replay {
+		e, err := m.s.next("mail", method, args)
+		if err != nil {
+			return err
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := m.s.record("mail", method, args)
+	err := f(msg)
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (m *mailReplay) Send(msg *mail.Message) error {
+	return m.send("Send", msg, m.m.Send)
+}
+
+func (m *mailReplay) SendToAdmins(msg *mail.Message) error {
+	return m.send("SendToAdmins", msg, m.m.SendToAdmins)
+}
+
+func (m *mailReplay) Testable() mail.Testable {
+	return m.m.Testable()
+}
+
+func filterMail(c context.Context, s *state) context.Context {
+	return mail.AddFilters(c, func(ic context.Context, m mail.Interface) mail.Interface {
+		return &mailReplay{s, m}
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"golang.org/x/net/context"
+
+	mc "github.com/luci/gae/service/memcache"
+)
+
+type mcReplay struct {
+	s *state
+
+	mc mc.RawInterface
+}
+
+var _ mc.RawInterface = (*mcReplay)(nil)
+
+func (m *mcReplay) NewItem(key string) mc.Item {
+	return m.mc.NewItem(key)
+}
+
+// multi implements the methods which take items or keys and return an error
+// per element.
+func (m *mcReplay) multi(method string, args Args, cb mc.RawCB, f func(mc.RawCB) error) error {
+	if m.s.replay {
+		e, err := m.s.next("memcache", method, args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			cb(decodeErr(res.Err))
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := m.s.record("memcache", method, args)
+	err := f(func(err error) {
+		e.Results = append(e.Results, &Result{Err: encodeErr(err)})
+		cb(err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (m *mcReplay) GetMulti(keys []string, cb mc.RawItemCB) error {
+	args := Args{MCKeys: keys}
+	if m.s.replay {
+		e, err := m.s.next("memcache", "GetMulti", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			cb(res.Item.decode(m.mc), decodeErr(res.Err))
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := m.s.record("memcache", "GetMulti", args)
+	err := m.mc.GetMulti(keys, func(itm mc.Item, err error) {
+		e.Results = append(e.Results, &Result{Item: encodeItem(itm), Err: encodeErr(err)})
+		cb(itm, err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (m *mcReplay) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	return m.multi("AddMulti", Args{MCItems: encodeItems(items)}, cb, func(cb mc.RawCB) error {
+		return m.mc.AddMulti(items, cb)
+	})
+}
+
+func (m *mcReplay) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	return m.multi("SetMulti", Args{MCItems: encodeItems(items)}, cb, func(cb mc.RawCB) error {
+		return m.mc.SetMulti(items, cb)
+	})
+}
+
+func (m *mcReplay) DeleteMulti(keys []string, cb mc.RawCB) error {
+	return m.multi("DeleteMulti", Args{MCKeys: keys}, cb, func(cb mc.RawCB) error {
+		return m.mc.DeleteMulti(keys, cb)
+	})
+}
+
+func (m *mcReplay) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	return m.multi("CompareAndSwapMulti", Args{MCItems: encodeItems(items)}, cb, func(cb mc.RawCB) error {
+		return m.mc.CompareAndSwapMulti(items, cb)
+	})
+}
+
+func (m *mcReplay) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	args := Args{MCKeys: []string{key}, N: delta, Initial: initialValue}
+	if m.s.replay {
+		e, err := m.s.next("memcache", "Increment", args)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(e.Value), decodeErr(e.Err)
+	}
+
+	e := m.s.record("memcache", "Increment", args)
+	ret, err := m.mc.Increment(key, delta, initialValue)
+	e.Value, e.Err = int64(ret), encodeErr(err)
+	return ret, err
+}
+
+func (m *mcReplay) Flush() error {
+	if m.s.replay {
+		e, err := m.s.next("memcache", "Flush", Args{})
+		if err != nil {
+			return err
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := m.s.record("memcache", "Flush", Args{})
+	err := m.mc.Flush()
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (m *mcReplay) Stats() (*mc.Statistics, error) {
+	if m.s.replay {
+		e, err := m.s.next("memcache", "Stats", Args{})
+		if err != nil {
+			return nil, err
+		}
+		return e.MCStats, decodeErr(e.Err)
+	}
+
+	e := m.s.record("memcache", "Stats", Args{})
+	ret, err := m.mc.Stats()
+	e.MCStats, e.Err = ret, encodeErr(err)
+	return ret, err
+}
+
+func filterMC(c context.Context, s *state) context.Context {
+	return mc.AddRawFilters(c, func(ic context.Context, rmc mc.RawInterface) mc.RawInterface {
+		return &mcReplay{s, rmc}
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// DivergenceError is returned from calls made during Replay which don't match
+// the recorded Log.
+type DivergenceError struct {
+	// Index is the index of the Log entry which was expected.
+	Index int
+	// Expected is the recorded call, or nil if the Log was exhausted.
+	Expected *Entry
+	// Actual is the call which was made.
+	Actual *Entry
+}
+
+func (e *DivergenceError) Error() string {
+	if e.Expected == nil {
+		return fmt.Sprintf("replay: unexpected call #%d (log exhausted): %s", e.Index, e.Actual)
+	}
+	return fmt.Sprintf("replay: call #%d diverged:\n  expected: %s\n  actual:   %s",
+		e.Index, e.Expected, e.Actual)
+}
+
+type state struct {
+	sync.Mutex
+
+	log    *Log
+	replay bool
+
+	// pos is the index of the next entry to replay.
+	pos int
+	// err is the first DivergenceError encountered while replaying.
+	err error
+}
+
+// record appends a new entry for the call to the log, and returns it so that
+// the caller can fill in the results.
+func (s *state) record(service, method string, args Args) *Entry {
+	e := &Entry{Service: service, Method: method, Args: args}
+	s.Lock()
+	defer s.Unlock()
+	s.log.Entries = append(s.log.Entries, e)
+	return e
+}
+
+// next returns the next recorded entry if it matches the call, or
+// a *DivergenceError if it doesn't.
+func (s *state) next(service, method string, args Args) (*Entry, error) {
+	actual := &Entry{Service: service, Method: method, Args: args}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.pos >= len(s.log.Entries) {
+		s.err = &DivergenceError{s.pos, nil, actual}
+		return nil, s.err
+	}
+	e := s.log.Entries[s.pos]
+	if e.Service != service || e.Method != method || !e.Args.equal(&args) {
+		s.err = &DivergenceError{s.pos, e, actual}
+		return nil, s.err
+	}
+	s.pos++
+	return e, nil
+}
+
+// Record installs filters which record every call made to the datastore,
+// memcache, taskqueue and mail services into the returned Log.
+//
+// The Log must not be written while calls are in progress.
+func Record(c context.Context) (context.Context, *Log) {
+	l := &Log{}
+	return install(c, &state{log: l}), l
+}
+
+// Replayer is the state of a Replay.
+type Replayer struct {
+	s *state
+}
+
+// Replay installs filters which serve every call made to the datastore,
+// memcache, taskqueue and mail services from l.
+//
+// Once a call diverges from the Log, it and all subsequent calls fail with
+// the same *DivergenceError.
+func Replay(c context.Context, l *Log) (context.Context, *Replayer) {
+	s := &state{log: l, replay: true}
+	return install(c, s), &Replayer{s}
+}
+
+// Err returns the first *DivergenceError encountered, if any.
+func (r *Replayer) Err() error {
+	r.s.Lock()
+	defer r.s.Unlock()
+	return r.s.err
+}
+
+// Remaining returns the number of entries of the Log which haven't been
+// replayed yet.
+func (r *Replayer) Remaining() int {
+	r.s.Lock()
+	defer r.s.Unlock()
+	return len(r.s.log.Entries) - r.s.pos
+}
+
+// Done returns an error if a call diverged from the Log, or if some of the
+// Log wasn't replayed.
+func (r *Replayer) Done() error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if n := r.Remaining(); n > 0 {
+		r.s.Lock()
+		defer r.s.Unlock()
+		return fmt.Errorf("replay: %d recorded calls were not made, starting with #%d: %s",
+			n, r.s.pos, r.s.log.Entries[r.s.pos])
+	}
+	return nil
+}
+
+func install(c context.Context, s *state) context.Context {
+	c = filterRDS(c, s)
+	c = filterMC(c, s)
+	c = filterTQ(c, s)
+	return filterMail(c, s)
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/luci/gae/impl/memory"
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/mail"
+	mc "github.com/luci/gae/service/memcache"
+	tq "github.com/luci/gae/service/taskqueue"
+	"github.com/luci/luci-go/common/errors"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type Foo struct {
+	ID    int64 `gae:"$id"`
+	Value string
+}
+
+// handler does a bit of everything, and returns what it observed.
+func handler(c context.Context, name string) (ret []string) {
+	d := ds.Get(c)
+	must := func(err error) {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	foo := &Foo{Value: name}
+	must(d.Put(foo))
+
+	err := d.RunInTransaction(func(c context.Context) error {
+		foo := &Foo{ID: foo.ID}
+		if err := ds.Get(c).Get(foo); err != nil {
+			return err
+		}
+		ret = append(ret, foo.Value)
+		foo.Value += "!"
+		return ds.Get(c).Put(foo)
+	}, nil)
+	must(err)
+
+	d.Testable().CatchupIndexes()
+	foos := []*Foo(nil)
+	must(d.GetAll(ds.NewQuery("Foo"), &foos))
+	for _, f := range foos {
+		ret = append(ret, f.Value)
+	}
+	if err := d.Get(&Foo{ID: 1000}); err != ds.ErrNoSuchEntity {
+		panic(err)
+	}
+
+	m := mc.Get(c)
+	if _, err := m.Get("counter"); err != mc.ErrCacheMiss {
+		panic(err)
+	}
+	must(m.Set(m.NewItem("counter").SetValue([]byte(name))))
+	itm, err := m.Get("counter")
+	must(err)
+	ret = append(ret, string(itm.Value()))
+
+	task := &tq.Task{Path: "/process"}
+	must(tq.Get(c).Add(task, ""))
+	ret = append(ret, task.Name)
+
+	must(mail.Get(c).Send(&mail.Message{
+		Sender: "admin@example.com",
+		To:     []string{"user@example.com"},
+		Body:   name,
+	}))
+	return
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test record/replay", t, func() {
+		c, l := Record(memory.Use(context.Background()))
+		recorded := handler(c, "hello")
+		So(recorded, ShouldResemble, []string{"hello", "hello!", "hello", recorded[3]})
+		So(recorded[3], ShouldNotEqual, "")
+		So(len(l.Entries), ShouldBeGreaterThan, 0)
+
+		buf := &bytes.Buffer{}
+		So(l.Write(buf), ShouldBeNil)
+		l, err := ReadLog(buf)
+		So(err, ShouldBeNil)
+
+		Convey("can replay the same calls", func() {
+			c, r := Replay(memory.Use(context.Background()), l)
+			So(handler(c, "hello"), ShouldResemble, recorded)
+			So(r.Done(), ShouldBeNil)
+			So(r.Remaining(), ShouldEqual, 0)
+		})
+
+		Convey("detects divergent calls", func() {
+			c, r := Replay(memory.Use(context.Background()), l)
+			So(func() { handler(c, "goodbye") }, ShouldPanic)
+
+			err := r.Err()
+			So(err, ShouldHaveSameTypeAs, &DivergenceError{})
+			de := err.(*DivergenceError)
+			So(de.Index, ShouldEqual, 0)
+			So(de.Expected.Method, ShouldEqual, "PutMulti")
+			So(r.Done(), ShouldEqual, err)
+		})
+
+		Convey("detects missing calls", func() {
+			c, r := Replay(memory.Use(context.Background()), l)
+			So(ds.Get(c).Put(&Foo{Value: "hello"}), ShouldBeNil)
+			So(r.Done(), ShouldErrLike, "were not made")
+		})
+	})
+
+	Convey("Test replay fidelity", t, func() {
+		roundTrip := func(l *Log) *Log {
+			buf := &bytes.Buffer{}
+			So(l.Write(buf), ShouldBeNil)
+			l, err := ReadLog(buf)
+			So(err, ShouldBeNil)
+			return l
+		}
+
+		Convey("restores per-element errors", func() {
+			handler := func(c context.Context) error {
+				d := ds.Get(c)
+				if err := d.Put(&Foo{ID: 1, Value: "hi"}); err != nil {
+					panic(err)
+				}
+				return d.GetMulti([]*Foo{{ID: 1}, {ID: 2}})
+			}
+
+			c, l := Record(memory.Use(context.Background()))
+			err := handler(c)
+			So(err, ShouldResemble, errors.MultiError{nil, ds.ErrNoSuchEntity})
+
+			c, r := Replay(memory.Use(context.Background()), roundTrip(l))
+			So(handler(c), ShouldResemble, err)
+			So(r.Done(), ShouldBeNil)
+		})
+
+		Convey("runs transactions with a transactional context", func() {
+			// Testable isn't available inside of a transaction.
+			handler := func(c context.Context) (inTxn bool) {
+				err := ds.Get(c).RunInTransaction(func(c context.Context) error {
+					inTxn = ds.Get(c).Testable() == nil
+					return ds.Get(c).Put(&Foo{ID: 1})
+				}, nil)
+				if err != nil {
+					panic(err)
+				}
+				return
+			}
+
+			c, l := Record(memory.Use(context.Background()))
+			So(handler(c), ShouldBeTrue)
+
+			c, r := Replay(memory.Use(context.Background()), roundTrip(l))
+			So(handler(c), ShouldBeTrue)
+			So(r.Done(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replay
+
+import (
+	"golang.org/x/net/context"
+
+	tq "github.com/luci/gae/service/taskqueue"
+)
+
+type tqReplay struct {
+	s *state
+
+	tq tq.RawInterface
+}
+
+var _ tq.RawInterface = (*tqReplay)(nil)
+
+func (t *tqReplay) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	args := Args{Tasks: dupTasks(tasks), Queues: []string{queueName}}
+	if t.s.replay {
+		e, err := t.s.next("taskqueue", "AddMulti", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			task := res.Task
+			if task != nil {
+				task = task.Duplicate()
+			}
+			cb(task, decodeErr(res.Err))
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := t.s.record("taskqueue", "AddMulti", args)
+	err := t.tq.AddMulti(tasks, queueName, func(task *tq.Task, err error) {
+		res := &Result{Err: encodeErr(err)}
+		if task != nil {
+			res.Task = task.Duplicate()
+		}
+		e.Results = append(e.Results, res)
+		cb(task, err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (t *tqReplay) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
+	args := Args{Tasks: dupTasks(tasks), Queues: []string{queueName}}
+	if t.s.replay {
+		e, err := t.s.next("taskqueue", "DeleteMulti", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			cb(decodeErr(res.Err))
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := t.s.record("taskqueue", "DeleteMulti", args)
+	err := t.tq.DeleteMulti(tasks, queueName, func(err error) {
+		e.Results = append(e.Results, &Result{Err: encodeErr(err)})
+		cb(err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (t *tqReplay) Purge(queueName string) error {
+	args := Args{Queues: []string{queueName}}
+	if t.s.replay {
+		e, err := t.s.next("taskqueue", "Purge", args)
+		if err != nil {
+			return err
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := t.s.record("taskqueue", "Purge", args)
+	err := t.tq.Purge(queueName)
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (t *tqReplay) Stats(queueNames []string, cb tq.RawStatsCB) error {
+	args := Args{Queues: queueNames}
+	if t.s.replay {
+		e, err := t.s.next("taskqueue", "Stats", args)
+		if err != nil {
+			return err
+		}
+		for _, res := range e.Results {
+			cb(res.TQStats, decodeErr(res.Err))
+		}
+		return decodeErr(e.Err)
+	}
+
+	e := t.s.record("taskqueue", "Stats", args)
+	err := t.tq.Stats(queueNames, func(s *tq.Statistics, err error) {
+		e.Results = append(e.Results, &Result{TQStats: s, Err: encodeErr(err)})
+		cb(s, err)
+	})
+	e.Err = encodeErr(err)
+	return err
+}
+
+func (t *tqReplay) Testable() tq.Testable {
+	return t.tq.Testable()
+}
+
+// dupTasks copies tasks, since the user-facing taskqueue methods overwrite the
+// tasks passed to them after the call.
+func dupTasks(tasks []*tq.Task) []*tq.Task {
+	ret := make([]*tq.Task, len(tasks))
+	for i, t := range tasks {
+		ret[i] = t.Duplicate()
+	}
+	return ret
+}
+
+func filterTQ(c context.Context, s *state) context.Context {
+	return tq.AddRawFilters(c, func(ic context.Context, rtq tq.RawInterface) tq.RawInterface {
+		return &tqReplay{s, rtq}
+	})
+}
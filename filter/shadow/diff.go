@@ -0,0 +1,75 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package shadow
+
+import (
+	"fmt"
+	"sort"
+
+	ds "github.com/luci/gae/service/datastore"
+)
+
+// diffErrors returns a description of the difference between the primary
+// error a and the secondary error b, or "" if they're equivalent. Errors are
+// equivalent if they have the same message, since different implementations
+// don't necessarily share error instances.
+func diffErrors(a, b error) string {
+	switch {
+	case a == b:
+		return ""
+	case a == nil:
+		return fmt.Sprintf("error: primary succeeded, secondary failed with %q", b)
+	case b == nil:
+		return fmt.Sprintf("error: primary failed with %q, secondary succeeded", a)
+	case a.Error() != b.Error():
+		return fmt.Sprintf("error: primary failed with %q, secondary with %q", a, b)
+	}
+	return ""
+}
+
+// diffPropertyMaps returns a description of the differences between the
+// primary's a and the secondary's b, or "" if they're equivalent. Metadata is
+// ignored.
+func diffPropertyMaps(a, b ds.PropertyMap) string {
+	if (a == nil) != (b == nil) {
+		return fmt.Sprintf("value: primary has %s, secondary has %s", nilness(a), nilness(b))
+	}
+
+	names := map[string]struct{}{}
+	for name := range a {
+		names[name] = struct{}{}
+	}
+	for name := range b {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if name != "" && name[0] != '$' {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		av, bv := a[name], b[name]
+		if len(av) != len(bv) {
+			return fmt.Sprintf("property %q: primary has %d values, secondary has %d", name, len(av), len(bv))
+		}
+		for i := range av {
+			if av[i].Type() != bv[i].Type() || !av[i].Equal(&bv[i]) {
+				return fmt.Sprintf("property %q[%d]: primary has %s, secondary has %s",
+					name, i, av[i].GQL(), bv[i].GQL())
+			}
+		}
+	}
+	return ""
+}
+
+func nilness(pm ds.PropertyMap) string {
+	if pm == nil {
+		return "no entity"
+	}
+	return "an entity"
+}
@@ -0,0 +1,24 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package shadow contains a datastore filter which mirrors calls against
+// a secondary datastore implementation and reports any differences between
+// the results of the two.
+//
+// This is useful to gain confidence in a new datastore backend before
+// migrating to it: install the filter with the current backend as the primary
+// and the new one as the secondary, and watch the reported divergences. The
+// filter always returns the primary's answers, so the secondary can't affect
+// the application's behavior (other than by adding latency).
+//
+// Reads (GetMulti, Run and Count) are always mirrored. Writes (PutMulti and
+// DeleteMulti) are mirrored only if Options.MirrorWrites is set, in which
+// case entities which the primary assigns IDs to are written to the secondary
+// with the same, complete, keys.
+//
+// Transactions are mirrored into a transaction of the secondary, which gets
+// a single attempt per attempt of the primary's transaction, and calls made
+// inside of them are mirrored into it like any other. AllocateIDs and
+// DecodeCursor are not mirrored.
+package shadow
@@ -0,0 +1,334 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package shadow
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	ds "github.com/luci/gae/service/datastore"
+)
+
+type key int
+
+var txnKey key
+
+// txnState is stored in the context of transactions.
+type txnState struct {
+	// secondary is the secondary's transaction, or nil if it couldn't be
+	// started (in which case the transaction isn't shadowed).
+	secondary ds.RawInterface
+}
+
+// Divergence describes a difference between the results of the primary and
+// the secondary datastore.
+type Divergence struct {
+	// Method is the name of the RawInterface method (e.g. "GetMulti").
+	Method string
+	// Key is the key of the differing entity, if applicable.
+	Key *ds.Key
+	// Query is the query of Run and Count.
+	Query *ds.FinalizedQuery
+	// Index is the index of the differing element (i.e. the key of a batch
+	// call, or the result of a query), or -1 if the difference concerns the
+	// call as a whole.
+	Index int
+	// Description is a human-readable description of the difference.
+	Description string
+}
+
+func (d *Divergence) String() string {
+	ret := "shadow: " + d.Method
+	if d.Query != nil {
+		ret += fmt.Sprintf(" %q", d.Query.GQL())
+	}
+	if d.Index >= 0 {
+		ret += fmt.Sprintf(" [%d]", d.Index)
+	}
+	if d.Key != nil {
+		ret += " " + d.Key.String()
+	}
+	return ret + ": " + d.Description
+}
+
+// Options are the options for FilterRDS.
+type Options struct {
+	// Secondary returns the RawInterface to compare the primary against. It's
+	// called with the context of the filtered datastore, and should return
+	// a datastore in the same namespace.
+	//
+	// Inside of transactions, the secondary is instead the datastore installed
+	// in the context which the secondary's RunInTransaction passes to its
+	// function.
+	Secondary func(c context.Context) ds.RawInterface
+
+	// OnDivergence is called with every difference found. It may be called
+	// concurrently.
+	OnDivergence func(c context.Context, d *Divergence)
+
+	// MirrorWrites makes PutMulti and DeleteMulti apply to the secondary as
+	// well.
+	MirrorWrites bool
+}
+
+// FilterRDS installs a shadow datastore filter in the context.
+//
+// The datastore installed in c is the primary. The filter panics if either
+// opts.Secondary or opts.OnDivergence is nil.
+func FilterRDS(c context.Context, opts Options) context.Context {
+	if opts.Secondary == nil || opts.OnDivergence == nil {
+		panic("shadow: Secondary and OnDivergence must be set")
+	}
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		if ts, _ := ic.Value(txnKey).(*txnState); ts != nil {
+			if ts.secondary == nil {
+				return rds
+			}
+			return &dsShadow{ic, &opts, ts.secondary, rds}
+		}
+		return &dsShadow{ic, &opts, nil, rds}
+	})
+}
+
+type dsShadow struct {
+	c    context.Context
+	opts *Options
+
+	// sTxn is the secondary's transaction, if this is inside of a transaction.
+	sTxn ds.RawInterface
+
+	ds.RawInterface
+}
+
+var _ ds.RawInterface = (*dsShadow)(nil)
+
+func (s *dsShadow) report(d *Divergence) {
+	s.opts.OnDivergence(s.c, d)
+}
+
+func (s *dsShadow) secondary() ds.RawInterface {
+	if s.sTxn != nil {
+		return s.sTxn
+	}
+	return s.opts.Secondary(s.c)
+}
+
+// RunInTransaction runs every attempt of the primary's transaction inside of
+// a transaction of the secondary. The secondary's transaction only gets
+// a single attempt, so that it can't make f run more often than the primary
+// does; if it fails to commit, that's reported as a divergence.
+func (s *dsShadow) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	if s.sTxn != nil {
+		// Let the primary reject the nested transaction.
+		return s.RawInterface.RunInTransaction(f, opts)
+	}
+
+	sOpts := &ds.TransactionOptions{}
+	if opts != nil {
+		*sOpts = *opts
+	}
+	sOpts.Attempts = 1
+
+	return s.RawInterface.RunInTransaction(func(c context.Context) error {
+		ran := false
+		err := error(nil)
+		sErr := s.opts.Secondary(s.c).RunInTransaction(func(sc context.Context) error {
+			ran = true
+			err = f(context.WithValue(c, txnKey, &txnState{ds.GetRaw(sc)}))
+			return err
+		}, sOpts)
+		if !ran {
+			s.report(&Divergence{Method: "RunInTransaction", Index: -1, Description: diffErrors(nil, sErr)})
+			return f(context.WithValue(c, txnKey, &txnState{}))
+		}
+		if desc := diffErrors(err, sErr); desc != "" {
+			s.report(&Divergence{Method: "RunInTransaction", Index: -1, Description: desc})
+		}
+		return err
+	}, opts)
+}
+
+func (s *dsShadow) Count(q *ds.FinalizedQuery) (int64, error) {
+	count, err := s.RawInterface.Count(q)
+
+	sCount, sErr := s.secondary().Count(q)
+	if desc := diffErrors(err, sErr); desc != "" {
+		s.report(&Divergence{Method: "Count", Query: q, Index: -1, Description: desc})
+	} else if err == nil && count != sCount {
+		s.report(&Divergence{Method: "Count", Query: q, Index: -1,
+			Description: fmt.Sprintf("count: primary %d, secondary %d", count, sCount)})
+	}
+	return count, err
+}
+
+type entity struct {
+	key *ds.Key
+	pm  ds.PropertyMap
+}
+
+func (s *dsShadow) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	results := []entity(nil)
+	stopped := false
+	err := s.RawInterface.Run(q, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		results = append(results, entity{k, pm})
+		err := cb(k, pm, gc)
+		stopped = err != nil
+		return err
+	})
+
+	// The user may have stopped the query early, so only fetch as many results
+	// as the user saw from the secondary (plus one, to detect a premature end
+	// of the primary's results when the query ran to completion).
+	sResults := []entity(nil)
+	sErr := s.secondary().Run(q, func(k *ds.Key, pm ds.PropertyMap, _ ds.CursorCB) error {
+		sResults = append(sResults, entity{k, pm})
+		if len(sResults) > len(results) {
+			return ds.Stop
+		}
+		return nil
+	})
+	if desc := diffErrors(err, sErr); desc != "" {
+		s.report(&Divergence{Method: "Run", Query: q, Index: -1, Description: desc})
+		return err
+	}
+
+	for i, res := range results {
+		if i >= len(sResults) {
+			s.report(&Divergence{Method: "Run", Query: q, Index: -1, Description: fmt.Sprintf(
+				"result count: primary returned at least %d, secondary %d", len(results), len(sResults))})
+			break
+		}
+		sRes := sResults[i]
+		if !res.key.Equal(sRes.key) {
+			s.report(&Divergence{Method: "Run", Query: q, Index: i, Key: res.key,
+				Description: fmt.Sprintf("key: secondary returned %s", sRes.key)})
+			continue
+		}
+		if desc := diffPropertyMaps(res.pm, sRes.pm); desc != "" {
+			s.report(&Divergence{Method: "Run", Query: q, Index: i, Key: res.key, Description: desc})
+		}
+	}
+	if err == nil && !stopped && len(sResults) > len(results) && !limitReached(q, len(results)) {
+		s.report(&Divergence{Method: "Run", Query: q, Index: -1, Description: fmt.Sprintf(
+			"result count: primary returned %d, secondary returned more", len(results))})
+	}
+	return err
+}
+
+// limitReached returns true if n results mean that q was cut short by its
+// limit.
+func limitReached(q *ds.FinalizedQuery, n int) bool {
+	limit, ok := q.Limit()
+	return ok && int(limit) <= n
+}
+
+func (s *dsShadow) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	type result struct {
+		pm  ds.PropertyMap
+		err error
+	}
+	results := make([]result, 0, len(keys))
+	err := s.RawInterface.GetMulti(keys, meta, func(pm ds.PropertyMap, err error) error {
+		results = append(results, result{pm, err})
+		return cb(pm, err)
+	})
+	if err != nil || len(results) != len(keys) {
+		// Either a server error, or the user's callback stopped the iteration.
+		return err
+	}
+
+	i := 0
+	sErr := s.secondary().GetMulti(keys, meta, func(pm ds.PropertyMap, sErr error) error {
+		res := results[i]
+		if desc := diffErrors(res.err, sErr); desc != "" {
+			s.report(&Divergence{Method: "GetMulti", Key: keys[i], Index: i, Description: desc})
+		} else if res.err == nil {
+			if desc := diffPropertyMaps(res.pm, pm); desc != "" {
+				s.report(&Divergence{Method: "GetMulti", Key: keys[i], Index: i, Description: desc})
+			}
+		}
+		i++
+		return nil
+	})
+	if sErr != nil {
+		s.report(&Divergence{Method: "GetMulti", Index: -1, Description: diffErrors(nil, sErr)})
+	}
+	return err
+}
+
+func (s *dsShadow) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	if !s.opts.MirrorWrites {
+		return s.RawInterface.PutMulti(keys, vals, cb)
+	}
+
+	newKeys := make([]*ds.Key, 0, len(keys))
+	errs := make([]error, 0, len(keys))
+	err := s.RawInterface.PutMulti(keys, vals, func(k *ds.Key, err error) error {
+		newKeys = append(newKeys, k)
+		errs = append(errs, err)
+		return cb(k, err)
+	})
+	if err != nil || len(newKeys) != len(keys) {
+		return err
+	}
+
+	// Only mirror the entities which the primary wrote, using the keys which
+	// the primary assigned to them.
+	idxs := make([]int, 0, len(keys))
+	sKeys := make([]*ds.Key, 0, len(keys))
+	sVals := make([]ds.PropertyMap, 0, len(keys))
+	for i, k := range newKeys {
+		if errs[i] == nil {
+			idxs = append(idxs, i)
+			sKeys = append(sKeys, k)
+			sVals = append(sVals, vals[i])
+		}
+	}
+	if len(sKeys) == 0 {
+		return err
+	}
+	j := 0
+	sErr := s.secondary().PutMulti(sKeys, sVals, func(_ *ds.Key, sErr error) error {
+		if sErr != nil {
+			i := idxs[j]
+			s.report(&Divergence{Method: "PutMulti", Key: newKeys[i], Index: i, Description: diffErrors(nil, sErr)})
+		}
+		j++
+		return nil
+	})
+	if sErr != nil {
+		s.report(&Divergence{Method: "PutMulti", Index: -1, Description: diffErrors(nil, sErr)})
+	}
+	return err
+}
+
+func (s *dsShadow) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	if !s.opts.MirrorWrites {
+		return s.RawInterface.DeleteMulti(keys, cb)
+	}
+
+	errs := make([]error, 0, len(keys))
+	err := s.RawInterface.DeleteMulti(keys, func(err error) error {
+		errs = append(errs, err)
+		return cb(err)
+	})
+	if err != nil || len(errs) != len(keys) {
+		return err
+	}
+
+	i := 0
+	sErr := s.secondary().DeleteMulti(keys, func(sErr error) error {
+		if desc := diffErrors(errs[i], sErr); desc != "" {
+			s.report(&Divergence{Method: "DeleteMulti", Key: keys[i], Index: i, Description: desc})
+		}
+		i++
+		return nil
+	})
+	if sErr != nil {
+		s.report(&Divergence{Method: "DeleteMulti", Index: -1, Description: diffErrors(nil, sErr)})
+	}
+	return err
+}
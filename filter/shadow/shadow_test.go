@@ -0,0 +1,168 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package shadow
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/luci/gae/impl/memory"
+	ds "github.com/luci/gae/service/datastore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type Foo struct {
+	ID  int64 `gae:"$id"`
+	Val string
+}
+
+type collector struct {
+	sync.Mutex
+	divs []*Divergence
+}
+
+func (c *collector) add(_ context.Context, d *Divergence) {
+	c.Lock()
+	defer c.Unlock()
+	c.divs = append(c.divs, d)
+}
+
+func TestShadow(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test shadow filter", t, func() {
+		primary := memory.Use(context.Background())
+		secondary := memory.Use(context.Background())
+		col := &collector{}
+		opts := Options{
+			Secondary:    func(context.Context) ds.RawInterface { return ds.GetRaw(secondary) },
+			OnDivergence: col.add,
+		}
+
+		Convey("without mirrored writes", func() {
+			c := FilterRDS(primary, opts)
+			d := ds.Get(c)
+
+			So(d.Put(&Foo{ID: 1, Val: "hi"}), ShouldBeNil)
+			So(col.divs, ShouldBeEmpty)
+
+			Convey("reports missing entities", func() {
+				foo := &Foo{ID: 1}
+				So(d.Get(foo), ShouldBeNil)
+				So(foo.Val, ShouldEqual, "hi")
+
+				So(len(col.divs), ShouldEqual, 1)
+				So(col.divs[0].Method, ShouldEqual, "GetMulti")
+				So(col.divs[0].Key, ShouldResemble, d.NewKey("Foo", "", 1, nil))
+				So(col.divs[0].Description, ShouldContainSubstring, "secondary failed")
+			})
+
+			Convey("reports differences inside of transactions", func() {
+				err := d.RunInTransaction(func(c context.Context) error {
+					return ds.Get(c).Get(&Foo{ID: 1})
+				}, nil)
+				So(err, ShouldBeNil)
+
+				So(len(col.divs), ShouldEqual, 1)
+				So(col.divs[0].Method, ShouldEqual, "GetMulti")
+				So(col.divs[0].Description, ShouldContainSubstring, "secondary failed")
+			})
+
+			Convey("reports different entities", func() {
+				So(ds.Get(secondary).Put(&Foo{ID: 1, Val: "there"}), ShouldBeNil)
+				So(d.Get(&Foo{ID: 1}), ShouldBeNil)
+
+				So(len(col.divs), ShouldEqual, 1)
+				So(col.divs[0].Description, ShouldContainSubstring, `property "Val"[0]`)
+			})
+
+			Convey("reports different query results", func() {
+				So(ds.Get(secondary).PutMulti([]*Foo{{ID: 2}, {ID: 3}}), ShouldBeNil)
+				d.Testable().CatchupIndexes()
+				ds.Get(secondary).Testable().CatchupIndexes()
+
+				foos := []*Foo(nil)
+				So(d.GetAll(ds.NewQuery("Foo"), &foos), ShouldBeNil)
+				So(len(foos), ShouldEqual, 1)
+
+				So(len(col.divs), ShouldEqual, 2)
+				So(col.divs[0].Method, ShouldEqual, "Run")
+				So(col.divs[0].Index, ShouldEqual, 0)
+				So(col.divs[0].Description, ShouldContainSubstring, "key: secondary returned")
+				So(col.divs[1].Index, ShouldEqual, -1)
+				So(col.divs[1].Description, ShouldContainSubstring, "secondary returned more")
+
+				col.divs = nil
+				count, err := d.Count(ds.NewQuery("Foo"))
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+				So(len(col.divs), ShouldEqual, 1)
+				So(col.divs[0].Description, ShouldEqual, "count: primary 1, secondary 2")
+			})
+		})
+
+		Convey("with mirrored writes", func() {
+			opts.MirrorWrites = true
+			c := FilterRDS(primary, opts)
+			d := ds.Get(c)
+
+			foo := &Foo{Val: "hi"}
+			So(d.Put(foo), ShouldBeNil)
+			So(foo.ID, ShouldNotEqual, 0)
+
+			Convey("the secondary gets the primary's keys", func() {
+				sFoo := &Foo{ID: foo.ID}
+				So(ds.Get(secondary).Get(sFoo), ShouldBeNil)
+				So(sFoo.Val, ShouldEqual, "hi")
+
+				So(d.Get(&Foo{ID: foo.ID}), ShouldBeNil)
+
+				d.Testable().CatchupIndexes()
+				ds.Get(secondary).Testable().CatchupIndexes()
+				foos := []*Foo(nil)
+				So(d.GetAll(ds.NewQuery("Foo"), &foos), ShouldBeNil)
+				So(col.divs, ShouldBeEmpty)
+			})
+
+			Convey("deletes are mirrored", func() {
+				So(d.Delete(d.KeyForObj(foo)), ShouldBeNil)
+				So(ds.Get(secondary).Get(&Foo{ID: foo.ID}), ShouldEqual, ds.ErrNoSuchEntity)
+				So(col.divs, ShouldBeEmpty)
+			})
+
+			Convey("transactions are mirrored", func() {
+				err := d.RunInTransaction(func(c context.Context) error {
+					foo := &Foo{ID: foo.ID}
+					if err := ds.Get(c).Get(foo); err != nil {
+						return err
+					}
+					foo.Val += "!"
+					return ds.Get(c).Put(foo)
+				}, nil)
+				So(err, ShouldBeNil)
+				So(col.divs, ShouldBeEmpty)
+
+				sFoo := &Foo{ID: foo.ID}
+				So(ds.Get(secondary).Get(sFoo), ShouldBeNil)
+				So(sFoo.Val, ShouldEqual, "hi!")
+			})
+
+			Convey("failed transactions aren't applied to the secondary", func() {
+				err := d.RunInTransaction(func(c context.Context) error {
+					if err := ds.Get(c).Put(&Foo{ID: 100}); err != nil {
+						return err
+					}
+					return errors.New("nope")
+				}, nil)
+				So(err, ShouldErrLike, "nope")
+				So(col.divs, ShouldBeEmpty)
+				So(ds.Get(secondary).Get(&Foo{ID: 100}), ShouldEqual, ds.ErrNoSuchEntity)
+			})
+		})
+	})
+}
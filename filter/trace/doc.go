@@ -0,0 +1,22 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package trace contains filters which record a Span for every call made to
+// the datastore, memcache, taskqueue, mail and urlfetch services.
+//
+// Spans are collected into a request-scoped Trace, which is installed in the
+// context with Use:
+//   c, t := trace.Use(c, exporter)
+//   ...
+//   for _, s := range t.Spans() {
+//     fmt.Println(s.Service, s.Method, s.Duration())
+//   }
+//
+// Start and end times are taken from the context's clock, so tests using
+// a testclock get deterministic timings. Calls made inside of
+// a RunInTransaction are recorded as children of the RunInTransaction span.
+//
+// Every finished span is also handed to the Exporter passed to Use (if any).
+// MemoryExporter is a simple Exporter which is useful for tests.
+package trace
@@ -0,0 +1,54 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"github.com/luci/gae/service/mail"
+	"golang.org/x/net/context"
+)
+
+type mailTracer struct {
+	t *Trace
+	c context.Context
+
+	m mail.Interface
+}
+
+var _ mail.Interface = (*mailTracer)(nil)
+
+func (m *mailTracer) start(method string, msg *mail.Message) *Span {
+	s := m.t.start(m.c, "mail", method)
+	if msg != nil {
+		s.Keys = append(s.Keys, msg.To...)
+		s.Keys = append(s.Keys, msg.Cc...)
+		s.Keys = append(s.Keys, msg.Bcc...)
+		s.Size = len(s.Keys)
+		s.Bytes = int64(len(msg.Body) + len(msg.HTMLBody))
+		for _, a := range msg.Attachments {
+			s.Bytes += int64(len(a.Data))
+		}
+	}
+	return s
+}
+
+func (m *mailTracer) Send(msg *mail.Message) error {
+	s := m.start("Send", msg)
+	return m.t.finish(m.c, s, m.m.Send(msg))
+}
+
+func (m *mailTracer) SendToAdmins(msg *mail.Message) error {
+	s := m.start("SendToAdmins", msg)
+	return m.t.finish(m.c, s, m.m.SendToAdmins(msg))
+}
+
+func (m *mailTracer) Testable() mail.Testable {
+	return m.m.Testable()
+}
+
+func filterMail(c context.Context, t *Trace) context.Context {
+	return mail.AddFilters(c, func(ic context.Context, m mail.Interface) mail.Interface {
+		return &mailTracer{t, ic, m}
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"golang.org/x/net/context"
+
+	mc "github.com/luci/gae/service/memcache"
+)
+
+type mcTracer struct {
+	t *Trace
+	c context.Context
+
+	mc mc.RawInterface
+}
+
+var _ mc.RawInterface = (*mcTracer)(nil)
+
+func (m *mcTracer) start(method string, keys []string) *Span {
+	s := m.t.start(m.c, "memcache", method)
+	s.Size = len(keys)
+	s.Keys = keys
+	return s
+}
+
+func (m *mcTracer) startItems(method string, items []mc.Item) *Span {
+	keys := make([]string, len(items))
+	s := m.start(method, keys)
+	for i, itm := range items {
+		keys[i] = itm.Key()
+		s.Bytes += int64(len(itm.Value()))
+	}
+	return s
+}
+
+func (m *mcTracer) NewItem(key string) mc.Item {
+	return m.mc.NewItem(key)
+}
+
+func (m *mcTracer) GetMulti(keys []string, cb mc.RawItemCB) error {
+	s := m.start("GetMulti", keys)
+	return m.t.finish(m.c, s, m.mc.GetMulti(keys, func(itm mc.Item, err error) {
+		if itm != nil {
+			s.Bytes += int64(len(itm.Value()))
+		}
+		cb(itm, err)
+	}))
+}
+
+func (m *mcTracer) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	s := m.startItems("AddMulti", items)
+	return m.t.finish(m.c, s, m.mc.AddMulti(items, cb))
+}
+
+func (m *mcTracer) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	s := m.startItems("SetMulti", items)
+	return m.t.finish(m.c, s, m.mc.SetMulti(items, cb))
+}
+
+func (m *mcTracer) DeleteMulti(keys []string, cb mc.RawCB) error {
+	s := m.start("DeleteMulti", keys)
+	return m.t.finish(m.c, s, m.mc.DeleteMulti(keys, cb))
+}
+
+func (m *mcTracer) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	s := m.startItems("CompareAndSwapMulti", items)
+	return m.t.finish(m.c, s, m.mc.CompareAndSwapMulti(items, cb))
+}
+
+func (m *mcTracer) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	s := m.start("Increment", []string{key})
+	ret, err := m.mc.Increment(key, delta, initialValue)
+	return ret, m.t.finish(m.c, s, err)
+}
+
+func (m *mcTracer) Flush() error {
+	s := m.start("Flush", nil)
+	return m.t.finish(m.c, s, m.mc.Flush())
+}
+
+func (m *mcTracer) Stats() (*mc.Statistics, error) {
+	s := m.start("Stats", nil)
+	ret, err := m.mc.Stats()
+	return ret, m.t.finish(m.c, s, err)
+}
+
+func filterMC(c context.Context, t *Trace) context.Context {
+	return mc.AddRawFilters(c, func(ic context.Context, rmc mc.RawInterface) mc.RawInterface {
+		return &mcTracer{t, ic, rmc}
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"golang.org/x/net/context"
+
+	ds "github.com/luci/gae/service/datastore"
+)
+
+type dsTracer struct {
+	t *Trace
+	c context.Context
+
+	ds ds.RawInterface
+}
+
+var _ ds.RawInterface = (*dsTracer)(nil)
+
+func (r *dsTracer) start(method string, keys []*ds.Key) *Span {
+	s := r.t.start(r.c, "datastore", method)
+	s.Size = len(keys)
+	if len(keys) > 0 {
+		s.Keys = make([]string, len(keys))
+		for i, k := range keys {
+			s.Keys[i] = k.String()
+			s.addKind(k.Kind())
+		}
+	}
+	return s
+}
+
+func (r *dsTracer) startQuery(method string, q *ds.FinalizedQuery) *Span {
+	s := r.t.start(r.c, "datastore", method)
+	s.Query = q.GQL()
+	if k := q.Kind(); k != "" {
+		s.addKind(k)
+	}
+	return s
+}
+
+func (r *dsTracer) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
+	s := r.start("AllocateIDs", []*ds.Key{incomplete})
+	s.Size = n
+	start, err := r.ds.AllocateIDs(incomplete, n)
+	return start, r.t.finish(r.c, s, err)
+}
+
+func (r *dsTracer) DecodeCursor(str string) (ds.Cursor, error) {
+	s := r.start("DecodeCursor", nil)
+	cursor, err := r.ds.DecodeCursor(str)
+	return cursor, r.t.finish(r.c, s, err)
+}
+
+func (r *dsTracer) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	s := r.startQuery("Run", q)
+	return r.t.finish(r.c, s, r.ds.Run(q, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		s.Size++
+		if pm != nil {
+			s.Bytes += pm.EstimateSize()
+		}
+		return cb(k, pm, gc)
+	}))
+}
+
+func (r *dsTracer) Count(q *ds.FinalizedQuery) (int64, error) {
+	s := r.startQuery("Count", q)
+	count, err := r.ds.Count(q)
+	s.Size = int(count)
+	return count, r.t.finish(r.c, s, err)
+}
+
+func (r *dsTracer) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	s := r.start("RunInTransaction", nil)
+	return r.t.finish(r.c, s, r.ds.RunInTransaction(func(c context.Context) error {
+		return f(withParent(c, s))
+	}, opts))
+}
+
+func (r *dsTracer) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	s := r.start("DeleteMulti", keys)
+	return r.t.finish(r.c, s, r.ds.DeleteMulti(keys, cb))
+}
+
+func (r *dsTracer) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	s := r.start("GetMulti", keys)
+	return r.t.finish(r.c, s, r.ds.GetMulti(keys, meta, func(pm ds.PropertyMap, err error) error {
+		if pm != nil {
+			s.Bytes += pm.EstimateSize()
+		}
+		return cb(pm, err)
+	}))
+}
+
+func (r *dsTracer) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	s := r.start("PutMulti", keys)
+	for _, pm := range vals {
+		s.Bytes += pm.EstimateSize()
+	}
+	return r.t.finish(r.c, s, r.ds.PutMulti(keys, vals, cb))
+}
+
+func (r *dsTracer) Testable() ds.Testable {
+	return r.ds.Testable()
+}
+
+func filterRDS(c context.Context, t *Trace) context.Context {
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		return &dsTracer{t, ic, rds}
+	})
+}
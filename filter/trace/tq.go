@@ -0,0 +1,72 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"golang.org/x/net/context"
+
+	tq "github.com/luci/gae/service/taskqueue"
+)
+
+type tqTracer struct {
+	t *Trace
+	c context.Context
+
+	tq tq.RawInterface
+}
+
+var _ tq.RawInterface = (*tqTracer)(nil)
+
+func (t *tqTracer) start(method string, tasks []*tq.Task) *Span {
+	s := t.t.start(t.c, "taskqueue", method)
+	s.Size = len(tasks)
+	if len(tasks) > 0 {
+		s.Keys = make([]string, len(tasks))
+		for i, task := range tasks {
+			s.Keys[i] = task.Name
+			s.Bytes += int64(len(task.Payload))
+		}
+	}
+	return s
+}
+
+func (t *tqTracer) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	s := t.start("AddMulti", tasks)
+	i := 0
+	return t.t.finish(t.c, s, t.tq.AddMulti(tasks, queueName, func(task *tq.Task, err error) {
+		// Anonymous tasks get their name assigned by the service.
+		if task != nil {
+			s.Keys[i] = task.Name
+		}
+		i++
+		cb(task, err)
+	}))
+}
+
+func (t *tqTracer) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
+	s := t.start("DeleteMulti", tasks)
+	return t.t.finish(t.c, s, t.tq.DeleteMulti(tasks, queueName, cb))
+}
+
+func (t *tqTracer) Purge(queueName string) error {
+	s := t.start("Purge", nil)
+	return t.t.finish(t.c, s, t.tq.Purge(queueName))
+}
+
+func (t *tqTracer) Stats(queueNames []string, cb tq.RawStatsCB) error {
+	s := t.start("Stats", nil)
+	s.Size = len(queueNames)
+	return t.t.finish(t.c, s, t.tq.Stats(queueNames, cb))
+}
+
+func (t *tqTracer) Testable() tq.Testable {
+	return t.tq.Testable()
+}
+
+func filterTQ(c context.Context, t *Trace) context.Context {
+	return tq.AddRawFilters(c, func(ic context.Context, rtq tq.RawInterface) tq.RawInterface {
+		return &tqTracer{t, ic, rtq}
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+)
+
+type key int
+
+var parentSpanKey key
+
+// Span records a single call to a service method.
+type Span struct {
+	// ID is the identifier of this span, unique within its Trace. IDs start at
+	// 1.
+	ID int
+	// ParentID is the ID of the span which encloses this one (e.g. the
+	// RunInTransaction span for calls made within a transaction), or 0 if this
+	// is a top-level span.
+	ParentID int
+
+	// Service is the name of the called service (e.g. "datastore").
+	Service string
+	// Method is the name of the called method (e.g. "GetMulti").
+	Method string
+
+	// Size is the number of elements (keys, items, tasks, ...) in the call.
+	Size int
+	// Bytes is the (estimated) number of payload bytes sent or received by the
+	// call, where that makes sense for the method.
+	Bytes int64
+	// Keys contains the string form of the keys involved in the call. These are
+	// datastore keys, memcache keys, task names, recipient addresses or URLs,
+	// depending on the service.
+	Keys []string
+	// Kinds contains the distinct datastore kinds involved in the call, in order
+	// of first appearance.
+	Kinds []string
+	// Query is the GQL form of the query of datastore Run and Count calls.
+	Query string
+
+	// Err is the error returned by the call, if any.
+	Err error
+
+	// Start and End are the times (according to the context clock) when the
+	// call started and ended.
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the amount of time the call took.
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+func (s *Span) String() string {
+	ret := fmt.Sprintf("%d: %s.%s(size=%d) %s", s.ID, s.Service, s.Method, s.Size, s.Duration())
+	if s.Err != nil {
+		ret += fmt.Sprintf(" err=%q", s.Err)
+	}
+	return ret
+}
+
+func (s *Span) addKind(kind string) {
+	for _, k := range s.Kinds {
+		if k == kind {
+			return
+		}
+	}
+	s.Kinds = append(s.Kinds, kind)
+}
+
+// Exporter receives finished spans.
+//
+// Export is called synchronously from the service call which the span
+// describes, so it should be fast. It may be called concurrently from
+// multiple goroutines.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// Trace is a collection of the spans recorded within a single request.
+type Trace struct {
+	sync.Mutex
+
+	exporter Exporter
+	nextID   int
+	spans    []*Span
+}
+
+// Spans returns all of the spans finished so far, in the order in which they
+// finished.
+func (t *Trace) Spans() []*Span {
+	t.Lock()
+	defer t.Unlock()
+	ret := make([]*Span, len(t.spans))
+	copy(ret, t.spans)
+	return ret
+}
+
+// Children returns the finished spans whose ParentID is parentID. Pass 0 to
+// get the top-level spans.
+func (t *Trace) Children(parentID int) []*Span {
+	t.Lock()
+	defer t.Unlock()
+	ret := []*Span(nil)
+	for _, s := range t.spans {
+		if s.ParentID == parentID {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// start begins a new span. The span's parent is taken from c.
+func (t *Trace) start(c context.Context, service, method string) *Span {
+	t.Lock()
+	t.nextID++
+	id := t.nextID
+	t.Unlock()
+
+	parent, _ := c.Value(parentSpanKey).(*Span)
+	s := &Span{
+		ID:      id,
+		Service: service,
+		Method:  method,
+		Start:   clock.Now(c),
+	}
+	if parent != nil {
+		s.ParentID = parent.ID
+	}
+	return s
+}
+
+// finish ends s, recording err, and returns err.
+func (t *Trace) finish(c context.Context, s *Span, err error) error {
+	s.End = clock.Now(c)
+	s.Err = err
+
+	t.Lock()
+	t.spans = append(t.spans, s)
+	t.Unlock()
+
+	if t.exporter != nil {
+		t.exporter.Export(s)
+	}
+	return err
+}
+
+// withParent returns a context in which newly started spans will be children
+// of s.
+func withParent(c context.Context, s *Span) context.Context {
+	return context.WithValue(c, parentSpanKey, s)
+}
+
+// Use installs tracing filters for the datastore, memcache, taskqueue, mail
+// and urlfetch services in the context, and returns the new context along
+// with the Trace which will accumulate the spans.
+//
+// exporter may be nil.
+//
+// The urlfetch service must already be installed in c if it's going to be
+// used.
+func Use(c context.Context, exporter Exporter) (context.Context, *Trace) {
+	t := &Trace{exporter: exporter}
+	c = filterRDS(c, t)
+	c = filterMC(c, t)
+	c = filterTQ(c, t)
+	c = filterMail(c, t)
+	c = filterURLFetch(c, t)
+	return c, t
+}
+
+// MemoryExporter is an Exporter which keeps all spans in memory.
+type MemoryExporter struct {
+	sync.Mutex
+
+	spans []*Span
+}
+
+var _ Exporter = (*MemoryExporter)(nil)
+
+// Export implements Exporter.
+func (m *MemoryExporter) Export(s *Span) {
+	m.Lock()
+	defer m.Unlock()
+	m.spans = append(m.spans, s)
+}
+
+// Spans returns all of the spans exported so far.
+func (m *MemoryExporter) Spans() []*Span {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]*Span, len(m.spans))
+	copy(ret, m.spans)
+	return ret
+}
+
+// Reset discards all of the spans exported so far.
+func (m *MemoryExporter) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.spans = nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/luci/gae/filter/featureBreaker"
+	"github.com/luci/gae/impl/memory"
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/mail"
+	mc "github.com/luci/gae/service/memcache"
+	tq "github.com/luci/gae/service/taskqueue"
+	"github.com/luci/gae/service/urlfetch"
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type Foo struct {
+	ID  int64 `gae:"$id"`
+	Val string
+}
+
+type rtFunc func(*http.Request) (*http.Response, error)
+
+func (f rtFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test trace filter", t, func() {
+		now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		c, _ := testclock.UseTime(context.Background(), now)
+		c = memory.Use(c)
+		exp := &MemoryExporter{}
+		c, tr := Use(c, exp)
+
+		Convey("records datastore calls", func() {
+			d := ds.Get(c)
+			So(d.PutMulti([]*Foo{{ID: 1, Val: "a"}, {ID: 2, Val: "b"}}), ShouldBeNil)
+			So(d.Get(&Foo{ID: 1}), ShouldBeNil)
+
+			spans := tr.Spans()
+			So(len(spans), ShouldEqual, 2)
+
+			So(spans[0].ID, ShouldEqual, 1)
+			So(spans[0].Service, ShouldEqual, "datastore")
+			So(spans[0].Method, ShouldEqual, "PutMulti")
+			So(spans[0].Size, ShouldEqual, 2)
+			So(spans[0].Kinds, ShouldResemble, []string{"Foo"})
+			So(spans[0].Keys, ShouldResemble, []string{"dev~app::/Foo,1", "dev~app::/Foo,2"})
+			So(spans[0].Bytes, ShouldBeGreaterThan, 0)
+			So(spans[0].Start, ShouldResemble, now)
+
+			So(spans[1].Method, ShouldEqual, "GetMulti")
+			So(spans[1].Size, ShouldEqual, 1)
+			So(spans[1].Err, ShouldBeNil)
+
+			So(exp.Spans(), ShouldResemble, spans)
+
+			Convey("including queries", func() {
+				exp.Reset()
+				d.Testable().CatchupIndexes()
+				vals := []*Foo(nil)
+				So(d.GetAll(ds.NewQuery("Foo"), &vals), ShouldBeNil)
+				spans := exp.Spans()
+				So(len(spans), ShouldEqual, 1)
+				So(spans[0].Method, ShouldEqual, "Run")
+				So(spans[0].Size, ShouldEqual, 2)
+				So(spans[0].Query, ShouldContainSubstring, "FROM `Foo`")
+			})
+		})
+
+		Convey("records errors", func() {
+			c, fb := featureBreaker.FilterRDS(c, nil)
+			fb.BreakFeatures(errors.New("broken"), "GetMulti")
+			So(ds.Get(c).Get(&Foo{ID: 1}), ShouldErrLike, "broken")
+
+			spans := tr.Spans()
+			So(len(spans), ShouldEqual, 1)
+			So(spans[0].Err, ShouldErrLike, "broken")
+		})
+
+		Convey("nests spans within transactions", func() {
+			err := ds.Get(c).RunInTransaction(func(c context.Context) error {
+				So(ds.Get(c).Put(&Foo{ID: 1}), ShouldBeNil)
+				So(tq.Get(c).Add(&tq.Task{Path: "/hi"}, ""), ShouldBeNil)
+				return nil
+			}, nil)
+			So(err, ShouldBeNil)
+			So(mc.Get(c).Set(mc.Get(c).NewItem("key").SetValue([]byte("hi"))), ShouldBeNil)
+
+			top := tr.Children(0)
+			So(len(top), ShouldEqual, 2)
+			So(top[0].Method, ShouldEqual, "RunInTransaction")
+			So(top[1].Service, ShouldEqual, "memcache")
+			So(top[1].Keys, ShouldResemble, []string{"key"})
+			So(top[1].Bytes, ShouldEqual, 2)
+
+			inner := tr.Children(top[0].ID)
+			So(len(inner), ShouldEqual, 2)
+			So(inner[0].Method, ShouldEqual, "PutMulti")
+			So(inner[1].Service, ShouldEqual, "taskqueue")
+			So(inner[1].Method, ShouldEqual, "AddMulti")
+		})
+
+		Convey("records mail", func() {
+			So(mail.Get(c).Send(&mail.Message{
+				Sender: "admin@example.com",
+				To:     []string{"user@example.com"},
+				Body:   "hello",
+			}), ShouldBeNil)
+
+			spans := tr.Spans()
+			So(len(spans), ShouldEqual, 1)
+			So(spans[0].Service, ShouldEqual, "mail")
+			So(spans[0].Keys, ShouldResemble, []string{"user@example.com"})
+			So(spans[0].Bytes, ShouldEqual, 5)
+		})
+
+		Convey("records urlfetch", func() {
+			c := urlfetch.Set(c, rtFunc(func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}))
+			c, tr := Use(c, nil)
+
+			req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+			So(err, ShouldBeNil)
+			_, err = urlfetch.Get(c).RoundTrip(req)
+			So(err, ShouldBeNil)
+
+			spans := tr.Spans()
+			So(len(spans), ShouldEqual, 1)
+			So(spans[0].Service, ShouldEqual, "urlfetch")
+			So(spans[0].Method, ShouldEqual, "GET")
+			So(spans[0].Keys, ShouldResemble, []string{"http://example.com/foo"})
+		})
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package trace
+
+import (
+	"net/http"
+
+	"github.com/luci/gae/service/urlfetch"
+	"golang.org/x/net/context"
+)
+
+type urlfetchTracer struct {
+	t *Trace
+	c context.Context
+
+	rt http.RoundTripper
+}
+
+var _ http.RoundTripper = (*urlfetchTracer)(nil)
+
+func (u *urlfetchTracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	s := u.t.start(u.c, "urlfetch", req.Method)
+	s.Size = 1
+	s.Keys = []string{req.URL.String()}
+	if req.ContentLength > 0 {
+		s.Bytes = req.ContentLength
+	}
+	rsp, err := u.rt.RoundTrip(req)
+	if rsp != nil && rsp.ContentLength > 0 {
+		s.Bytes += rsp.ContentLength
+	}
+	return rsp, u.t.finish(u.c, s, err)
+}
+
+// filterURLFetch wraps the urlfetch service installed in c. urlfetch has no
+// filter mechanism, so this replaces the factory with one which wraps the
+// original RoundTripper (which is only constructed when needed, so it's fine
+// if there is no urlfetch service at all).
+func filterURLFetch(c context.Context, t *Trace) context.Context {
+	orig := c
+	return urlfetch.SetFactory(c, func(ic context.Context) http.RoundTripper {
+		return &urlfetchTracer{t, ic, urlfetch.Get(orig)}
+	})
+}
@@ -182,6 +182,10 @@ func (d *dsImpl) RunInTransaction(f func(context.Context) error, o *ds.Transacti
 		txnMC.Lock()
 		defer txnMC.Unlock()
 
+		txnDS := txnMC.(*memContext).Get(memContextDSIdx).(*txnDataStoreData)
+		if err := txnDS.checkCommitLocked(); err != nil {
+			return err
+		}
 		if applyForReal && curMC.canApplyTxn(txnMC) {
 			curMC.applyTxn(d.c, txnMC)
 		} else {
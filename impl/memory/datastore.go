@@ -7,11 +7,13 @@ package memory
 import (
 	"errors"
 	"fmt"
+	"os"
 
 	"golang.org/x/net/context"
 
 	ds "github.com/luci/gae/service/datastore"
 	"github.com/luci/gae/service/info"
+	"github.com/luci/luci-go/common/clock"
 )
 
 //////////////////////////////////// public ////////////////////////////////////
@@ -39,7 +41,7 @@ func useRDS(c context.Context) context.Context {
 			}
 			return &dsImpl{x, ns, ic}
 		}
-		return &txnDsImpl{dsd.(*txnDataStoreData), ns}
+		return &txnDsImpl{dsd.(*txnDataStoreData), ns, ic}
 	})
 }
 
@@ -78,20 +80,31 @@ type dsImpl struct {
 var _ ds.RawInterface = (*dsImpl)(nil)
 
 func (d *dsImpl) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
+	d.data.chargeCosts(d.c, ds.OpCosts{SmallOps: 1})
 	return d.data.allocateIDs(incomplete, n)
 }
 
 func (d *dsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
-	d.data.putMulti(keys, vals, cb)
+	if err := d.data.checkPutLimits(keys, vals); err != nil {
+		return err
+	}
+	d.data.putMulti(d.c, keys, vals, cb)
 	return nil
 }
 
 func (d *dsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if err := d.data.checkGetLimits(keys); err != nil {
+		return err
+	}
+	d.data.chargeCosts(d.c, ds.OpCosts{EntityReads: int64(len(keys))})
 	return d.data.getMulti(keys, cb)
 }
 
 func (d *dsImpl) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
-	d.data.delMulti(keys, cb)
+	if err := d.data.checkDeleteLimits(keys); err != nil {
+		return err
+	}
+	d.data.delMulti(d.c, keys, cb)
 	return nil
 }
 
@@ -100,16 +113,31 @@ func (d *dsImpl) DecodeCursor(s string) (ds.Cursor, error) {
 }
 
 func (d *dsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	n := int64(0)
+	countingCB := func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		n++
+		return cb(k, pm, gc)
+	}
+	defer func() { d.data.chargeCosts(d.c, queryCosts(fq, n)) }()
+	if fq.EventuallyConsistent() {
+		defer d.data.countSimQuery()
+	}
+
 	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
-	err := executeQuery(fq, d.data.aid, d.ns, false, idx, head, cb)
+	err := executeQuery(fq, d.data.aid, d.ns, false, idx, head, countingCB, nil)
 	if d.data.maybeAutoIndex(err) {
 		idx, head = d.data.getQuerySnaps(!fq.EventuallyConsistent())
-		err = executeQuery(fq, d.data.aid, d.ns, false, idx, head, cb)
+		err = executeQuery(fq, d.data.aid, d.ns, false, idx, head, countingCB, nil)
 	}
 	return err
 }
 
 func (d *dsImpl) Count(fq *ds.FinalizedQuery) (ret int64, err error) {
+	defer func() { d.data.chargeCosts(d.c, ds.OpCosts{EntityReads: 1, SmallOps: ret}) }()
+	if fq.EventuallyConsistent() {
+		defer d.data.countSimQuery()
+	}
+
 	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
 	ret, err = countQuery(fq, d.data.aid, d.ns, false, idx, head)
 	if d.data.maybeAutoIndex(err) {
@@ -133,6 +161,42 @@ func (d *dsImpl) AddIndexes(idxs ...*ds.IndexDefinition) {
 	d.data.addIndexes(d.ns, idxs)
 }
 
+func (d *dsImpl) AddIndexesFromYAML(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idxs, err := ds.ParseIndexYAML(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	d.AddIndexes(idxs...)
+	return nil
+}
+
+func (d *dsImpl) Explain(q *ds.Query) (*ds.QueryPlan, error) {
+	fq, err := q.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	nop := func(*ds.Key, ds.PropertyMap, ds.CursorCB) error { return nil }
+
+	plan := &ds.QueryPlan{}
+	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
+	err = executeQuery(fq, d.data.aid, d.ns, false, idx, head, nop, plan)
+	if d.data.maybeAutoIndex(err) {
+		plan = &ds.QueryPlan{}
+		idx, head = d.data.getQuerySnaps(!fq.EventuallyConsistent())
+		err = executeQuery(fq, d.data.aid, d.ns, false, idx, head, nop, plan)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
 func (d *dsImpl) TakeIndexSnapshot() ds.TestingSnapshot {
 	return d.data.takeSnapshot()
 }
@@ -153,14 +217,38 @@ func (d *dsImpl) Consistent(always bool) {
 	d.data.setConsistent(always)
 }
 
+func (d *dsImpl) SetConsistencyPolicy(policy *ds.ConsistencyPolicy) {
+	d.data.setConsistencyPolicy(policy, clock.Get(d.c))
+}
+
 func (d *dsImpl) AutoIndex(enable bool) {
 	d.data.setAutoIndex(enable)
 }
 
+func (d *dsImpl) AutoIndexed() []*ds.IndexDefinition {
+	return d.data.getAutoIndexed()
+}
+
 func (d *dsImpl) DisableSpecialEntities(enabled bool) {
 	d.data.setDisableSpecialEntities(enabled)
 }
 
+func (d *dsImpl) StrictLimits(enable bool) {
+	d.data.setStrictLimits(enable)
+}
+
+func (d *dsImpl) UpdateStats() {
+	d.data.updateStats(clock.Now(d.c).UTC())
+}
+
+func (d *dsImpl) OpCosts() ds.OpCosts {
+	return d.data.getCosts()
+}
+
+func (d *dsImpl) TrackOpCosts(c context.Context, tally *ds.OpCosts) context.Context {
+	return withCostTally(c, tally)
+}
+
 func (d *dsImpl) Testable() ds.Testable {
 	return d
 }
@@ -170,16 +258,21 @@ func (d *dsImpl) Testable() ds.Testable {
 type txnDsImpl struct {
 	data *txnDataStoreData
 	ns   string
+	c    context.Context
 }
 
 var _ ds.RawInterface = (*txnDsImpl)(nil)
 
 func (d *txnDsImpl) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
+	d.data.parent.chargeCosts(d.c, ds.OpCosts{SmallOps: 1})
 	return d.data.parent.allocateIDs(incomplete, n)
 }
 
 func (d *txnDsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
 	return d.data.run(func() error {
+		if err := d.data.parent.checkPutLimits(keys, vals); err != nil {
+			return err
+		}
 		d.data.putMulti(keys, vals, cb)
 		return nil
 	})
@@ -187,12 +280,19 @@ func (d *txnDsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMul
 
 func (d *txnDsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
 	return d.data.run(func() error {
+		if err := d.data.parent.checkGetLimits(keys); err != nil {
+			return err
+		}
+		d.data.parent.chargeCosts(d.c, ds.OpCosts{EntityReads: int64(len(keys))})
 		return d.data.getMulti(keys, cb)
 	})
 }
 
 func (d *txnDsImpl) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 	return d.data.run(func() error {
+		if err := d.data.parent.checkDeleteLimits(keys); err != nil {
+			return err
+		}
 		return d.data.delMulti(keys, cb)
 	})
 }
@@ -211,10 +311,17 @@ func (d *txnDsImpl) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
 	// It's possible that if you have full-consistency and also auto index enabled
 	// that this would make sense... but at that point you should probably just
 	// add the index up front.
-	return executeQuery(q, d.data.parent.aid, d.ns, true, d.data.snap, d.data.snap, cb)
+	n := int64(0)
+	defer func() { d.data.parent.chargeCosts(d.c, queryCosts(q, n)) }()
+	return executeQuery(q, d.data.parent.aid, d.ns, true, d.data.snap, d.data.snap,
+		func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+			n++
+			return cb(k, pm, gc)
+		}, nil)
 }
 
 func (d *txnDsImpl) Count(fq *ds.FinalizedQuery) (ret int64, err error) {
+	defer func() { d.data.parent.chargeCosts(d.c, ds.OpCosts{EntityReads: 1, SmallOps: ret}) }()
 	return countQuery(fq, d.data.parent.aid, d.ns, true, d.data.snap, d.data.snap)
 }
 
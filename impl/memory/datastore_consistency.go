@@ -0,0 +1,147 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"math/rand"
+	"time"
+
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/datastore/serialize"
+	"github.com/luci/luci-go/common/clock"
+)
+
+// pendingWrite is a write to head which isn't yet visible to eventually
+// consistent queries.
+type pendingWrite struct {
+	key *ds.Key
+	// data is the new value of the entity, or nil for a deletion.
+	data ds.PropertyMap
+	// special holds the values of key's special entities (e.g.
+	// __entity_group__) in head at the time of the write, keyed by their
+	// encoded keys. nil values weren't present.
+	special map[string][]byte
+
+	queriesLeft int
+	due         time.Time
+}
+
+// consistencySim implements ds.ConsistencyPolicy.
+//
+// It maintains applied, a writable copy of head which only has the writes
+// which have become visible. Index scans for eventually consistent queries use
+// a snapshot of applied instead of head.
+type consistencySim struct {
+	policy ds.ConsistencyPolicy
+	rng    *rand.Rand
+	clk    clock.Clock
+
+	applied *memStore
+	// pending is keyed by the encoded root key of each entity group, and holds
+	// that group's pending writes in the order that they were made.
+	pending map[string][]*pendingWrite
+}
+
+func newConsistencySim(policy ds.ConsistencyPolicy, clk clock.Clock, head *memStore) *consistencySim {
+	return &consistencySim{
+		policy:  policy,
+		rng:     rand.New(rand.NewSource(policy.Seed)),
+		clk:     clk,
+		applied: head.Copy(),
+		pending: map[string][]*pendingWrite{},
+	}
+}
+
+// recordLocked adds a pending write of data (nil for a deletion) to key. ents
+// is head's entity collection for key's namespace, after the write.
+func (s *consistencySim) recordLocked(ents *memCollection, key *ds.Key, data ds.PropertyMap) {
+	w := &pendingWrite{
+		key:         key,
+		data:        data,
+		special:     map[string][]byte{},
+		queriesLeft: s.rng.Intn(s.policy.MaxQueries + 1),
+		due:         s.clk.Now(),
+	}
+	if s.policy.MaxDelay > 0 {
+		w.due = w.due.Add(time.Duration(s.rng.Int63n(int64(s.policy.MaxDelay) + 1)))
+	}
+	for _, sk := range [][]byte{groupMetaKey(key), groupIDsKey(key), rootIDsKey(key.Root().Kind())} {
+		w.special[string(sk)] = ents.Get(sk)
+	}
+
+	rk := string(keyBytes(key.Root()))
+	s.pending[rk] = append(s.pending[rk], w)
+}
+
+// snapshotLocked applies all of the writes which are now visible, and returns
+// the index snapshot that an eventually consistent query should use. It
+// doesn't count as a query; see countQueryLocked.
+func (s *consistencySim) snapshotLocked() *memStore {
+	now := s.clk.Now()
+	for rk, ws := range s.pending {
+		for len(ws) > 0 && ws[0].queriesLeft == 0 && !now.Before(ws[0].due) {
+			s.applyLocked(ws[0])
+			ws = ws[1:]
+		}
+		if len(ws) == 0 {
+			delete(s.pending, rk)
+		} else {
+			s.pending[rk] = ws
+		}
+	}
+	return s.applied.Snapshot()
+}
+
+// countQueryLocked is called once for every eventually consistent query, after
+// it has taken its snapshot. It counts the query against every pending write.
+func (s *consistencySim) countQueryLocked() {
+	for _, ws := range s.pending {
+		for _, w := range ws {
+			if w.queriesLeft > 0 {
+				w.queriesLeft--
+			}
+		}
+	}
+}
+
+// flushLocked applies all pending writes.
+func (s *consistencySim) flushLocked() {
+	for _, ws := range s.pending {
+		for _, w := range ws {
+			s.applyLocked(w)
+		}
+	}
+	s.pending = map[string][]*pendingWrite{}
+}
+
+func (s *consistencySim) applyLocked(w *pendingWrite) {
+	coll := "ents:" + w.key.Namespace()
+	ents := s.applied.GetCollection(coll)
+	if ents == nil {
+		ents = s.applied.SetCollection(coll, nil)
+	}
+
+	kb := keyBytes(w.key)
+	oldPM := ds.PropertyMap(nil)
+	if old := ents.Get(kb); old != nil {
+		var err error
+		oldPM, err = rpm(old)
+		memoryCorruption(err)
+	}
+	if w.data == nil {
+		ents.Delete(kb)
+	} else {
+		ents.Set(kb, serialize.ToBytes(w.data))
+	}
+	updateIndexes(s.applied, w.key, oldPM, w.data)
+
+	for sk, v := range w.special {
+		if v == nil {
+			ents.Delete([]byte(sk))
+		} else {
+			ents.Set([]byte(sk), v)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	dsS "github.com/luci/gae/service/datastore"
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestConsistencyPolicy(t *testing.T) {
+	t.Parallel()
+
+	Convey("Testable.SetConsistencyPolicy", t, func() {
+		now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+		c, tc := testclock.UseTime(context.Background(), now)
+		c = Use(c)
+		ds := dsS.Get(c)
+
+		putFoos := func(n int) {
+			for i := 0; i < n; i++ {
+				So(ds.Put(&Foo{ID: int64(i + 1), Val: i + 1}), ShouldBeNil)
+			}
+		}
+		count := func(q *dsS.Query) int64 {
+			ret, err := ds.Count(q)
+			So(err, ShouldBeNil)
+			return ret
+		}
+		q := dsS.NewQuery("Foo").Gt("Val", 0)
+
+		Convey("writes become visible after MaxQueries queries", func() {
+			ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{Seed: 1, MaxQueries: 3})
+			putFoos(10)
+
+			counts := []int64{}
+			for i := 0; i < 4; i++ {
+				counts = append(counts, count(q))
+			}
+			So(counts[0], ShouldBeLessThan, 10)
+			So(counts[3], ShouldEqual, 10)
+			for i := 1; i < len(counts); i++ {
+				So(counts[i], ShouldBeGreaterThanOrEqualTo, counts[i-1])
+			}
+
+			Convey("deterministically", func() {
+				ds = dsS.Get(Use(context.Background()))
+				ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{Seed: 1, MaxQueries: 3})
+				putFoos(10)
+				for _, expect := range counts {
+					So(count(q), ShouldEqual, expect)
+				}
+			})
+
+			Convey("counting each query exactly once", func() {
+				ds = dsS.Get(Use(context.Background()))
+				ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{Seed: 1, MaxQueries: 3})
+				ds.Testable().AutoIndex(true)
+				putFoos(10)
+
+				// Explain never counts, and a query which is retried after adding
+				// its index counts once.
+				_, err := ds.Testable().Explain(q)
+				So(err, ShouldBeNil)
+				count(dsS.NewQuery("Foo").Gt("Val", 0).Order("Val", "Other"))
+				So(ds.Testable().AutoIndexed(), ShouldNotBeEmpty)
+				for _, expect := range counts[1:] {
+					_, err := ds.Testable().Explain(q)
+					So(err, ShouldBeNil)
+					So(count(q), ShouldEqual, expect)
+				}
+			})
+		})
+
+		Convey("writes become visible after MaxDelay", func() {
+			ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{Seed: 2, MaxDelay: time.Minute})
+			putFoos(10)
+
+			So(count(q), ShouldBeLessThan, 10)
+			tc.Add(time.Minute)
+			So(count(q), ShouldEqual, 10)
+		})
+
+		Convey("ancestor queries are consistent", func() {
+			ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{MaxQueries: 100})
+			root := ds.MakeKey("Root", 1)
+			for i := 0; i < 10; i++ {
+				So(ds.Put(&Foo{ID: int64(i + 1), Parent: root, Val: i + 1}), ShouldBeNil)
+			}
+			So(count(dsS.NewQuery("Foo").Ancestor(root)), ShouldEqual, 10)
+		})
+
+		Convey("writes to an entity group are applied in order", func() {
+			ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{Seed: 3, MaxQueries: 5})
+			for i := 1; i <= 10; i++ {
+				So(ds.Put(&Foo{ID: 1, Val: i}), ShouldBeNil)
+			}
+
+			last := int64(0)
+			pq := dsS.NewQuery("Foo").Project("Val")
+			for i := 0; i < 20; i++ {
+				pms := []dsS.PropertyMap{}
+				So(ds.GetAll(pq, &pms), ShouldBeNil)
+				So(len(pms), ShouldBeLessThanOrEqualTo, 1)
+				if len(pms) == 1 {
+					v := pms[0]["Val"][0].Value().(int64)
+					So(v, ShouldBeGreaterThanOrEqualTo, last)
+					last = v
+				}
+			}
+			So(last, ShouldEqual, 10)
+		})
+
+		Convey("CatchupIndexes applies everything", func() {
+			ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{MaxQueries: 100, MaxDelay: time.Hour})
+			putFoos(10)
+			So(ds.Delete(ds.MakeKey("Foo", 1)), ShouldBeNil)
+
+			ds.Testable().CatchupIndexes()
+			So(count(q), ShouldEqual, 9)
+		})
+
+		Convey("Consistent ends the simulation", func() {
+			ds.Testable().SetConsistencyPolicy(&dsS.ConsistencyPolicy{MaxQueries: 100})
+			putFoos(10)
+			ds.Testable().Consistent(true)
+			So(count(q), ShouldEqual, 10)
+		})
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"sync/atomic"
+
+	ds "github.com/luci/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+type costTalliesKeyType int
+
+var costTalliesKey costTalliesKeyType
+
+// getCostTallies returns every tally added to c with TrackOpCosts.
+func getCostTallies(c context.Context) []*ds.OpCosts {
+	if c == nil {
+		return nil
+	}
+	ret, _ := c.Value(costTalliesKey).([]*ds.OpCosts)
+	return ret
+}
+
+func withCostTally(c context.Context, tally *ds.OpCosts) context.Context {
+	tallies := getCostTallies(c)
+	// copy, so that sibling contexts don't share a backing array.
+	tallies = append(tallies[:len(tallies):len(tallies)], tally)
+	return context.WithValue(c, costTalliesKey, tallies)
+}
+
+func addCosts(dst *ds.OpCosts, cost ds.OpCosts) {
+	atomic.AddInt64(&dst.EntityReads, cost.EntityReads)
+	atomic.AddInt64(&dst.EntityWrites, cost.EntityWrites)
+	atomic.AddInt64(&dst.IndexWrites, cost.IndexWrites)
+	atomic.AddInt64(&dst.SmallOps, cost.SmallOps)
+}
+
+// queryCosts returns the costs of a query which returned n results.
+func queryCosts(fq *ds.FinalizedQuery, n int64) ds.OpCosts {
+	ret := ds.OpCosts{EntityReads: 1}
+	if fq.KeysOnly() || len(fq.Project()) > 0 {
+		ret.SmallOps = n
+	} else {
+		ret.EntityReads += n
+	}
+	return ret
+}
+
+// chargeCosts adds cost to the datastore's totals, and to every tally in c.
+func (d *dataStoreData) chargeCosts(c context.Context, cost ds.OpCosts) {
+	addCosts(&d.costs, cost)
+	for _, t := range getCostTallies(c) {
+		addCosts(t, cost)
+	}
+}
+
+func (d *dataStoreData) getCosts() ds.OpCosts {
+	return ds.OpCosts{
+		EntityReads:  atomic.LoadInt64(&d.costs.EntityReads),
+		EntityWrites: atomic.LoadInt64(&d.costs.EntityWrites),
+		IndexWrites:  atomic.LoadInt64(&d.costs.IndexWrites),
+		SmallOps:     atomic.LoadInt64(&d.costs.SmallOps),
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"errors"
+	"testing"
+
+	dsS "github.com/luci/gae/service/datastore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestOpCosts(t *testing.T) {
+	t.Parallel()
+
+	Convey("Testable.OpCosts", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		// costOf returns the costs of calling f.
+		costOf := func(f func()) dsS.OpCosts {
+			before := ds.Testable().OpCosts()
+			f()
+			return ds.Testable().OpCosts().Sub(before)
+		}
+
+		Convey("writes", func() {
+			So(costOf(func() {
+				So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityWrites: 1, IndexWrites: 3})
+
+			So(costOf(func() {
+				So(ds.Put(&Foo{ID: 1, Val: 2}), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityWrites: 1, IndexWrites: 4})
+
+			So(costOf(func() {
+				So(ds.Put(&Foo{ID: 1, Val: 2}), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityWrites: 1})
+
+			So(costOf(func() {
+				So(ds.Delete(ds.MakeKey("Foo", 1)), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityWrites: 1, IndexWrites: 3})
+
+			So(costOf(func() {
+				So(ds.Delete(ds.MakeKey("Foo", 1)), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityWrites: 1})
+		})
+
+		Convey("composite index writes", func() {
+			type Model struct {
+				ID   int64 `gae:"$id"`
+				A, B []int64
+			}
+			ds.Testable().AddIndexes(&dsS.IndexDefinition{Kind: "Model", SortBy: []dsS.IndexColumn{
+				{Property: "A"}, {Property: "B"},
+			}})
+
+			// kind + 2*2 A + 2*1 B + 2*1 composite
+			So(costOf(func() {
+				So(ds.Put(&Model{ID: 1, A: []int64{1, 2}, B: []int64{3}}), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityWrites: 1, IndexWrites: 9})
+		})
+
+		Convey("reads", func() {
+			for i := 1; i <= 5; i++ {
+				So(ds.Put(&Foo{ID: int64(i), Val: i}), ShouldBeNil)
+			}
+
+			So(costOf(func() {
+				So(ds.GetMulti([]*Foo{{ID: 1}, {ID: 2}}), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityReads: 2})
+
+			q := dsS.NewQuery("Foo").Gt("Val", 2)
+			So(costOf(func() {
+				foos := []*Foo{}
+				So(ds.GetAll(q, &foos), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityReads: 4})
+
+			So(costOf(func() {
+				keys := []*dsS.Key{}
+				So(ds.GetAll(q.KeysOnly(true), &keys), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityReads: 1, SmallOps: 3})
+
+			So(costOf(func() {
+				pms := []dsS.PropertyMap{}
+				So(ds.GetAll(q.Project("Val"), &pms), ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{EntityReads: 1, SmallOps: 3})
+
+			So(costOf(func() {
+				n, err := ds.Count(q)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 3)
+			}), ShouldResemble, dsS.OpCosts{EntityReads: 1, SmallOps: 3})
+
+			So(costOf(func() {
+				_, err := ds.AllocateIDs(ds.NewKey("Foo", "", 0, nil), 10)
+				So(err, ShouldBeNil)
+			}), ShouldResemble, dsS.OpCosts{SmallOps: 1})
+		})
+
+		Convey("TrackOpCosts", func() {
+			tally := &dsS.OpCosts{}
+			tc := ds.Testable().TrackOpCosts(c, tally)
+
+			So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil) // not tracked
+
+			inner := &dsS.OpCosts{}
+			So(dsS.Get(tc).RunInTransaction(func(c context.Context) error {
+				ds := dsS.Get(ds.Testable().TrackOpCosts(c, inner))
+				So(ds.Get(&Foo{ID: 1}), ShouldBeNil)
+				return ds.Put(&Foo{ID: 1, Val: 2})
+			}, nil), ShouldBeNil)
+
+			So(*inner, ShouldResemble, dsS.OpCosts{EntityReads: 1})
+			So(*tally, ShouldResemble, dsS.OpCosts{EntityReads: 1, EntityWrites: 1, IndexWrites: 4})
+
+			Convey("aborted transactions don't write", func() {
+				*tally = dsS.OpCosts{}
+				So(dsS.Get(tc).RunInTransaction(func(c context.Context) error {
+					So(dsS.Get(c).Put(&Foo{ID: 1, Val: 3}), ShouldBeNil)
+					return errors.New("nope")
+				}, nil), ShouldErrLike, "nope")
+				So(*tally, ShouldResemble, dsS.OpCosts{})
+			})
+		})
+	})
+}
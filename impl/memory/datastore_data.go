@@ -7,11 +7,13 @@ package memory
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 
 	ds "github.com/luci/gae/service/datastore"
 	"github.com/luci/gae/service/datastore/serialize"
+	"github.com/luci/luci-go/common/clock"
 	"github.com/luci/luci-go/common/errors"
 	"golang.org/x/net/context"
 )
@@ -19,6 +21,10 @@ import (
 //////////////////////////////// dataStoreData /////////////////////////////////
 
 type dataStoreData struct {
+	// costs is the total cost of all operations, see chargeCosts. It's first so
+	// that it's 64-bit aligned for atomic access.
+	costs ds.OpCosts
+
 	rwlock sync.RWMutex
 
 	// the 'appid' of this datastore
@@ -29,15 +35,24 @@ type dataStoreData struct {
 	// if snap is nil, that means that this is always-consistent, and
 	// getQuerySnaps will return (head, head)
 	snap *memStore
+	// if sim is non-nil, snap is nil, and eventually consistent queries use
+	// sim's simulated index state instead. See SetConsistencyPolicy.
+	sim *consistencySim
 	// For testing, see SetTransactionRetryCount.
 	txnFakeRetry int
 	// true means that queries with insufficent indexes will pause to add them
 	// and then continue instead of failing.
 	autoIndex bool
+	// autoIndexed is every index which was added because autoIndex was true,
+	// normalized and ordered by IndexDefinition.Less.
+	autoIndexed []*ds.IndexDefinition
 	// true means that all of the __...__ keys which are normally automatically
 	// maintained will be omitted. This also means that Put with an incomplete
 	// key will become an error.
 	disableSpecialEntities bool
+	// true means that the production datastore's entity and transaction size
+	// limits are enforced. See StrictLimits.
+	strictLimits bool
 }
 
 var (
@@ -72,6 +87,7 @@ func (d *dataStoreData) setConsistent(always bool) {
 	d.Lock()
 	defer d.Unlock()
 
+	d.sim = nil
 	if always {
 		d.snap = nil
 	} else {
@@ -79,10 +95,25 @@ func (d *dataStoreData) setConsistent(always bool) {
 	}
 }
 
+func (d *dataStoreData) setConsistencyPolicy(policy *ds.ConsistencyPolicy, clk clock.Clock) {
+	if policy == nil {
+		d.setConsistent(false)
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.snap = nil
+	d.sim = newConsistencySim(*policy, clk, d.head)
+}
+
 func (d *dataStoreData) addIndexes(ns string, idxs []*ds.IndexDefinition) {
 	d.Lock()
 	defer d.Unlock()
 	addIndexes(d.head, d.aid, ns, idxs)
+	if d.sim != nil {
+		addIndexes(d.sim.applied, d.aid, ns, idxs)
+	}
 }
 
 func (d *dataStoreData) setAutoIndex(enable bool) {
@@ -106,9 +137,36 @@ func (d *dataStoreData) maybeAutoIndex(err error) bool {
 	}
 
 	d.addIndexes(mi.ns, []*ds.IndexDefinition{mi.Missing})
+	d.recordAutoIndex(mi.Missing)
 	return true
 }
 
+// recordAutoIndex adds the normalized form of idx to autoIndexed, unless an
+// equivalent index is already there.
+func (d *dataStoreData) recordAutoIndex(idx *ds.IndexDefinition) {
+	d.Lock()
+	defer d.Unlock()
+
+	norm := idx.Normalize()
+	i := sort.Search(len(d.autoIndexed), func(i int) bool {
+		return !d.autoIndexed[i].Less(norm)
+	})
+	if i < len(d.autoIndexed) && d.autoIndexed[i].Equal(norm) {
+		return
+	}
+	d.autoIndexed = append(d.autoIndexed, nil)
+	copy(d.autoIndexed[i+1:], d.autoIndexed[i:])
+	d.autoIndexed[i] = norm
+}
+
+func (d *dataStoreData) getAutoIndexed() []*ds.IndexDefinition {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	ret := make([]*ds.IndexDefinition, len(d.autoIndexed))
+	copy(ret, d.autoIndexed)
+	return ret
+}
+
 func (d *dataStoreData) setDisableSpecialEntities(enabled bool) {
 	d.Lock()
 	defer d.Unlock()
@@ -121,7 +179,64 @@ func (d *dataStoreData) getDisableSpecialEntities() bool {
 	return d.disableSpecialEntities
 }
 
+func (d *dataStoreData) setStrictLimits(enable bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.strictLimits = enable
+}
+
+func (d *dataStoreData) getStrictLimits() bool {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	return d.strictLimits
+}
+
+// checkGetLimits returns the error that the production datastore would return
+// for getting keys, if strictLimits is enabled.
+func (d *dataStoreData) checkGetLimits(keys []*ds.Key) error {
+	if d.getStrictLimits() && len(keys) > ds.MaxGetBatchSize {
+		return &ds.ErrBadRequest{Detail: fmt.Sprintf(
+			"cannot get more than %d keys in a single call", ds.MaxGetBatchSize)}
+	}
+	return nil
+}
+
+// checkDeleteLimits returns the error that the production datastore would
+// return for deleting keys, if strictLimits is enabled.
+func (d *dataStoreData) checkDeleteLimits(keys []*ds.Key) error {
+	if d.getStrictLimits() && len(keys) > ds.MaxDeleteBatchSize {
+		return &ds.ErrBadRequest{Detail: fmt.Sprintf(
+			"cannot delete more than %d keys in a single call", ds.MaxDeleteBatchSize)}
+	}
+	return nil
+}
+
+// checkPutLimits returns the error that the production datastore would return
+// for putting vals at keys, if strictLimits is enabled. Like production, one
+// bad entity fails the whole call.
+func (d *dataStoreData) checkPutLimits(keys []*ds.Key, vals []ds.PropertyMap) error {
+	if !d.getStrictLimits() {
+		return nil
+	}
+	if len(keys) > ds.MaxPutBatchSize {
+		return &ds.ErrBadRequest{Detail: fmt.Sprintf(
+			"cannot write more than %d entities in a single call", ds.MaxPutBatchSize)}
+	}
+	for i, k := range keys {
+		if err := ds.CheckEntityLimits(k, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *dataStoreData) getQuerySnaps(consistent bool) (idx, head *memStore) {
+	if !consistent {
+		if idx, head = d.getSimQuerySnaps(); idx != nil {
+			return
+		}
+	}
+
 	d.rwlock.RLock()
 	defer d.rwlock.RUnlock()
 	if d.snap == nil {
@@ -139,6 +254,36 @@ func (d *dataStoreData) getQuerySnaps(consistent bool) (idx, head *memStore) {
 	return
 }
 
+// getSimQuerySnaps returns nil snapshots unless a consistency policy is being
+// simulated. It doesn't count as a query against the simulated policy; see
+// countSimQuery.
+func (d *dataStoreData) getSimQuerySnaps() (idx, head *memStore) {
+	d.rwlock.RLock()
+	sim := d.sim
+	d.rwlock.RUnlock()
+	if sim == nil {
+		return nil, nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if d.sim == nil {
+		return nil, nil
+	}
+	return d.sim.snapshotLocked(), d.head.Snapshot()
+}
+
+// countSimQuery counts an eventually consistent query against the simulated
+// consistency policy, if there is one. It should be called exactly once for
+// every query that a user runs, after all of its calls to getQuerySnaps.
+func (d *dataStoreData) countSimQuery() {
+	d.Lock()
+	defer d.Unlock()
+	if d.sim != nil {
+		d.sim.countQueryLocked()
+	}
+}
+
 func (d *dataStoreData) takeSnapshot() *memStore {
 	d.rwlock.RLock()
 	defer d.rwlock.RUnlock()
@@ -158,6 +303,10 @@ func (d *dataStoreData) setSnapshot(snap *memStore) {
 func (d *dataStoreData) catchupIndexes() {
 	d.rwlock.Lock()
 	defer d.rwlock.Unlock()
+	if d.sim != nil {
+		d.sim.flushLocked()
+		return
+	}
 	if d.snap == nil {
 		// we're 'always consistent'
 		return
@@ -249,13 +398,14 @@ func (d *dataStoreData) fixKeyLocked(ents *memCollection, key *ds.Key) (*ds.Key,
 	return key, nil
 }
 
-func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+func (d *dataStoreData) putMulti(c context.Context, keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
 	ns := keys[0].Namespace()
 
 	for i, k := range keys {
 		pmap, _ := vals[i].Save(false)
 		dataBytes := serialize.ToBytes(pmap)
 
+		cost := ds.OpCosts{}
 		k, err := func() (ret *ds.Key, err error) {
 			d.Lock()
 			defer d.Unlock()
@@ -278,9 +428,14 @@ func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.Pu
 				}
 			}
 			ents.Set(keyBytes(ret), dataBytes)
-			updateIndexes(d.head, ret, oldPM, pmap)
+			cost.EntityWrites = 1
+			cost.IndexWrites = updateIndexes(d.head, ret, oldPM, pmap)
+			if d.sim != nil {
+				d.sim.recordLocked(ents, ret, pmap)
+			}
 			return
 		}()
+		d.chargeCosts(c, cost)
 		if cb != nil {
 			if err := cb(k, err); err != nil {
 				if err == ds.Stop {
@@ -324,7 +479,7 @@ func (d *dataStoreData) getMulti(keys []*ds.Key, cb ds.GetMultiCB) error {
 	})
 }
 
-func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+func (d *dataStoreData) delMulti(c context.Context, keys []*ds.Key, cb ds.DeleteMultiCB) error {
 	ns := keys[0].Namespace()
 
 	hasEntsInNS := func() bool {
@@ -335,6 +490,7 @@ func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 
 	if hasEntsInNS {
 		for _, k := range keys {
+			cost := ds.OpCosts{EntityWrites: 1}
 			err := func() error {
 				kb := keyBytes(k)
 
@@ -352,10 +508,14 @@ func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 						return err
 					}
 					ents.Delete(kb)
-					updateIndexes(d.head, k, oldPM, nil)
+					cost.IndexWrites = updateIndexes(d.head, k, oldPM, nil)
+				}
+				if d.sim != nil {
+					d.sim.recordLocked(ents, k, nil)
 				}
 				return nil
 			}()
+			d.chargeCosts(c, cost)
 			if cb != nil {
 				if err := cb(err); err != nil {
 					if err == ds.Stop {
@@ -365,13 +525,16 @@ func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 				}
 			}
 		}
-	} else if cb != nil {
-		for range keys {
-			if err := cb(nil); err != nil {
-				if err == ds.Stop {
-					return nil
+	} else {
+		d.chargeCosts(c, ds.OpCosts{EntityWrites: int64(len(keys))})
+		if cb != nil {
+			for range keys {
+				if err := cb(nil); err != nil {
+					if err == ds.Stop {
+						return nil
+					}
+					return err
 				}
-				return err
 			}
 		}
 	}
@@ -414,10 +577,10 @@ func (d *dataStoreData) applyTxn(c context.Context, obj memContextObj) {
 		for _, m := range muts {
 			k := m.key
 			if m.data == nil {
-				impossible(d.delMulti([]*ds.Key{k},
+				impossible(d.delMulti(c, []*ds.Key{k},
 					func(e error) error { return e }))
 			} else {
-				impossible(d.putMulti([]*ds.Key{m.key}, []ds.PropertyMap{m.data},
+				impossible(d.putMulti(c, []*ds.Key{m.key}, []ds.PropertyMap{m.data},
 					func(_ *ds.Key, e error) error { return e }))
 			}
 		}
@@ -457,8 +620,9 @@ type txnDataStoreData struct {
 
 	// string is the raw-bytes encoding of the entity root incl. namespace
 	muts map[string][]txnMutation
-	// TODO(riannucci): account for 'transaction size' limit of 10MB by summing
-	// length of encoded keys + values.
+	// size is the estimated size of all of the keys and values in muts. See
+	// checkCommitLocked.
+	size int64
 }
 
 var _ memContextObj = (*txnDataStoreData)(nil)
@@ -521,12 +685,24 @@ func (td *txnDataStoreData) writeMutation(getOnly bool, key *ds.Key, data ds.Pro
 		td.muts[rk] = []txnMutation{}
 	}
 	if !getOnly {
+		td.size += key.EstimateSize() + data.EstimateSize()
 		td.muts[rk] = append(td.muts[rk], txnMutation{key, data})
 	}
 
 	return nil
 }
 
+// checkCommitLocked returns the error that the production datastore would
+// return when committing this transaction, if the parent has strictLimits.
+// Like production, the size limit applies to the whole commit, so a
+// transaction which exceeds it writes nothing.
+func (td *txnDataStoreData) checkCommitLocked() error {
+	if td.parent.getStrictLimits() && td.size > ds.MaxTransactionSize {
+		return &ds.ErrBadRequest{Detail: "datastore transaction or write too big."}
+	}
+	return nil
+}
+
 func (td *txnDataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) {
 	ns := keys[0].Namespace()
 
@@ -0,0 +1,35 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"io"
+
+	ds "github.com/luci/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+// DumpFixture writes every entity in the current namespace of c's in-memory
+// datastore to w, in the fixture format described in the datastore package
+// (see datastore.WriteFixture). The output is deterministic, so it's suitable
+// for comparing against a golden file, and it can be loaded again with
+// datastore.LoadFixture. Fixture keys don't record their namespace, so dump
+// each namespace separately to capture more than one.
+//
+// The dump always reflects the latest writes, regardless of Consistent, and
+// excludes the special entities which the datastore maintains for itself
+// (like __entity_group__ and statistics entities).
+func DumpFixture(c context.Context, w io.Writer) error {
+	d := mustCurNoTxn(c).Get(memContextDSIdx).(*dataStoreData)
+	head := d.takeSnapshot()
+
+	pms := []ds.PropertyMap{}
+	visitUserEntities(head, d.aid, curGID(c).namespace, func(k *ds.Key, pm ds.PropertyMap) bool {
+		pm.SetMeta("key", k)
+		pms = append(pms, pm)
+		return true
+	})
+	return ds.WriteFixture(w, pms)
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	dsS "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/info"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestDumpFixture(t *testing.T) {
+	t.Parallel()
+
+	Convey("DumpFixture", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+
+		fixture := `[
+  {
+    "$key": ["Foo", 1],
+    "Val": 1
+  },
+  {
+    "$key": ["Foo", 1, "Bar", "child"],
+    "Notes": {"noindex": "long"},
+    "Owner": {"key": ["Foo", 1]},
+    "Tags": ["a", "b"]
+  },
+  {
+    "$key": ["Foo", 2],
+    "Val": 2
+  }
+]
+`
+		So(dsS.LoadFixture(ds, strings.NewReader(fixture)), ShouldBeNil)
+
+		dump := func(c context.Context) string {
+			buf := &bytes.Buffer{}
+			So(DumpFixture(c, buf), ShouldBeNil)
+			return buf.String()
+		}
+
+		Convey("round trips", func() {
+			So(dump(c), ShouldEqual, fixture)
+
+			foo := &Foo{ID: 2}
+			So(ds.Get(foo), ShouldBeNil)
+			So(foo.Val, ShouldEqual, 2)
+		})
+
+		Convey("reflects the latest writes", func() {
+			So(ds.Delete(ds.MakeKey("Foo", 2)), ShouldBeNil)
+			So(ds.Put(&Foo{ID: 1, Val: 10}), ShouldBeNil)
+			ds.Testable().UpdateStats()
+
+			So(dump(c), ShouldEqual, `[
+  {
+    "$key": ["Foo", 1],
+    "Val": 10
+  },
+  {
+    "$key": ["Foo", 1, "Bar", "child"],
+    "Notes": {"noindex": "long"},
+    "Owner": {"key": ["Foo", 1]},
+    "Tags": ["a", "b"]
+  }
+]
+`)
+		})
+
+		Convey("is per-namespace", func() {
+			nsC, err := info.Get(c).Namespace("ns")
+			So(err, ShouldBeNil)
+			So(dump(nsC), ShouldEqual, "[]\n")
+
+			So(dsS.LoadFixture(dsS.Get(nsC), strings.NewReader(`[{"$key": ["Foo", 3], "Val": 3}]`)), ShouldBeNil)
+			So(dump(nsC), ShouldEqual, "[\n  {\n    \"$key\": [\"Foo\", 3],\n    \"Val\": 3\n  }\n]\n")
+		})
+	})
+}
@@ -182,7 +182,9 @@ func walkCompIdxs(store *memStore, endsWith *ds.IndexDefinition, cb func(*ds.Ind
 	}
 }
 
-func mergeIndexes(ns string, store, oldIdx, newIdx *memStore) {
+// mergeIndexes applies the difference between the index rows in oldIdx and
+// newIdx to store, and returns the number of rows which were added or removed.
+func mergeIndexes(ns string, store, oldIdx, newIdx *memStore) (changed int64) {
 	prefixBuf := []byte("idx:" + ns + ":")
 	origPrefixBufLen := len(prefixBuf)
 	gkvCollide(oldIdx.GetCollection("idx"), newIdx.GetCollection("idx"), func(k, ov, nv []byte) {
@@ -201,11 +203,13 @@ func mergeIndexes(ns string, store, oldIdx, newIdx *memStore) {
 		case ov == nil && nv != nil: // all additions
 			newColl.VisitItemsAscend(nil, false, func(i *gkvlite.Item) bool {
 				coll.Set(i.Key, []byte{})
+				changed++
 				return true
 			})
 		case ov != nil && nv == nil: // all deletions
 			oldColl.VisitItemsAscend(nil, false, func(i *gkvlite.Item) bool {
 				coll.Delete(i.Key)
+				changed++
 				return true
 			})
 		case ov != nil && nv != nil: // merge
@@ -215,6 +219,9 @@ func mergeIndexes(ns string, store, oldIdx, newIdx *memStore) {
 				} else {
 					coll.Set(k, []byte{})
 				}
+				if ov == nil || nv == nil {
+					changed++
+				}
 			})
 		default:
 			impossible(fmt.Errorf("both values from gkvCollide were nil?"))
@@ -222,6 +229,7 @@ func mergeIndexes(ns string, store, oldIdx, newIdx *memStore) {
 		// TODO(riannucci): remove entries from idxColl and remove index collections
 		// when there are no index entries for that index any more.
 	})
+	return
 }
 
 func addIndexes(store *memStore, aid, ns string, compIdx []*ds.IndexDefinition) {
@@ -252,7 +260,10 @@ func addIndexes(store *memStore, aid, ns string, compIdx []*ds.IndexDefinition)
 	}
 }
 
-func updateIndexes(store *memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap) {
+// updateIndexes updates the index rows in store for key, whose value changed
+// from oldEnt to newEnt (either of which may be nil), and returns the number
+// of index rows which were written.
+func updateIndexes(store *memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap) int64 {
 	// load all current complex query index definitions.
 	compIdx := []*ds.IndexDefinition{}
 	walkCompIdxs(store, nil, func(i *ds.IndexDefinition) bool {
@@ -260,7 +271,7 @@ func updateIndexes(store *memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap)
 		return true
 	})
 
-	mergeIndexes(key.Namespace(), store,
+	return mergeIndexes(key.Namespace(), store,
 		indexEntriesWithBuiltins(key, oldEnt, compIdx),
 		indexEntriesWithBuiltins(key, newEnt, compIdx))
 }
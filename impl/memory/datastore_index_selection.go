@@ -71,6 +71,9 @@ type indexDefinitionSortable struct {
 	// (tag=1, tag=2) is a perfectly valid query).
 	eqFilts []ds.IndexColumn
 	coll    *memCollection
+	// id is the definition of the index in coll, or nil if coll is the entity
+	// table.
+	id *ds.IndexDefinition
 }
 
 func (i *indexDefinitionSortable) hasAncestor() bool {
@@ -203,7 +206,7 @@ func (idxs *indexDefinitionSortableSlice) maybeAddDefinition(q *reducedQuery, s
 	//
 	// A perfect match contains ALL the equality filter columns (or more, since
 	// we can use residuals to fill in the extras).
-	toAdd := indexDefinitionSortable{coll: coll}
+	toAdd := indexDefinitionSortable{coll: coll, id: id}
 	toAdd.eqFilts = eqFilts
 	for _, sb := range toAdd.eqFilts {
 		missingTerms.Del(sb.Property)
@@ -331,6 +334,7 @@ func generate(q *reducedQuery, idx *indexDefinitionSortable, c *constraints) *it
 		c:     idx.coll,
 		start: q.start,
 		end:   q.end,
+		index: idx.id,
 	}
 	toJoin := make([][]byte, len(idx.eqFilts))
 	for _, sb := range idx.eqFilts {
@@ -0,0 +1,156 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/datastore/serialize"
+	"github.com/luci/gkvlite"
+)
+
+// propertyRepresentations maps the index type of a property (see
+// Property.IndexTypeAndValue) to the name which production uses for it in
+// __property__ entities.
+var propertyRepresentations = map[ds.PropertyType]string{
+	ds.PTNull:     "NULL",
+	ds.PTInt:      "INT64",
+	ds.PTBool:     "BOOLEAN",
+	ds.PTString:   "STRING",
+	ds.PTFloat:    "DOUBLE",
+	ds.PTGeoPoint: "POINT",
+	ds.PTKey:      "REFERENCE",
+}
+
+func isMetadataKind(kind string) bool {
+	switch kind {
+	case ds.NamespaceKind, ds.KindKind, ds.PropertyKind:
+		return true
+	}
+	return false
+}
+
+// isSpecialKind returns true for the kinds of the entities which this
+// implementation maintains for itself, like __entity_group__.
+func isSpecialKind(kind string) bool {
+	return strings.HasPrefix(kind, "__") && strings.HasSuffix(kind, "__")
+}
+
+// visitUserEntities calls cb for every entity in head's ns namespace, except
+// for special entities.
+func visitUserEntities(head *memStore, aid, ns string, cb func(*ds.Key, ds.PropertyMap) bool) {
+	ents := head.GetCollection("ents:" + ns)
+	if ents == nil {
+		return
+	}
+	ents.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+		prop, err := serialize.ReadProperty(bytes.NewBuffer(i.Key), serialize.WithoutContext, aid, ns)
+		memoryCorruption(err)
+		k := prop.Value().(*ds.Key)
+		if isSpecialKind(k.Kind()) {
+			return true
+		}
+		pm, err := rpm(i.Val)
+		memoryCorruption(err)
+		return cb(k, pm)
+	})
+}
+
+// metadataStore returns a new store which contains the metadata entities of
+// the given kind (one of the ds.*Kind metadata kinds) in the ns namespace,
+// computed from the entities in head. Metadata queries run against it like any
+// other query.
+func metadataStore(head *memStore, aid, ns, kind string) *memStore {
+	ret := newMemStore()
+	ents := ret.SetCollection("ents:"+ns, nil)
+	put := func(k *ds.Key, pm ds.PropertyMap) {
+		ents.Set(keyBytes(k), serialize.ToBytes(pm))
+		updateIndexes(ret, k, nil, pm)
+	}
+
+	switch kind {
+	case ds.NamespaceKind:
+		for _, name := range head.GetCollectionNames() {
+			if !strings.HasPrefix(name, "ents:") {
+				continue
+			}
+			entNS := name[len("ents:"):]
+			found := false
+			visitUserEntities(head, aid, entNS, func(*ds.Key, ds.PropertyMap) bool {
+				found = true
+				return false
+			})
+			if !found {
+				continue
+			}
+			if entNS == "" {
+				put(ds.NewKey(aid, ns, kind, "", 1, nil), ds.PropertyMap{})
+			} else {
+				put(ds.NewKey(aid, ns, kind, entNS, 0, nil), ds.PropertyMap{})
+			}
+		}
+
+	case ds.KindKind:
+		seen := map[string]struct{}{}
+		visitUserEntities(head, aid, ns, func(k *ds.Key, _ ds.PropertyMap) bool {
+			if _, ok := seen[k.Kind()]; !ok {
+				seen[k.Kind()] = struct{}{}
+				put(ds.NewKey(aid, ns, kind, k.Kind(), 0, nil), ds.PropertyMap{})
+			}
+			return true
+		})
+
+	case ds.PropertyKind:
+		// kind -> property -> representations
+		reps := map[string]map[string]map[string]struct{}{}
+		visitUserEntities(head, aid, ns, func(k *ds.Key, pm ds.PropertyMap) bool {
+			props := reps[k.Kind()]
+			if props == nil {
+				props = map[string]map[string]struct{}{}
+				reps[k.Kind()] = props
+			}
+			for name, vals := range pm {
+				for _, v := range vals {
+					if v.IndexSetting() == ds.NoIndex {
+						continue
+					}
+					t, _ := v.IndexTypeAndValue()
+					rep, ok := propertyRepresentations[t]
+					if !ok {
+						continue
+					}
+					if props[name] == nil {
+						props[name] = map[string]struct{}{}
+					}
+					props[name][rep] = struct{}{}
+				}
+			}
+			return true
+		})
+
+		for kindName, props := range reps {
+			kindKey := ds.NewKey(aid, ns, ds.KindKind, kindName, 0, nil)
+			for name, repSet := range props {
+				repNames := make([]string, 0, len(repSet))
+				for rep := range repSet {
+					repNames = append(repNames, rep)
+				}
+				sort.Strings(repNames)
+
+				vals := make(ds.PropertySlice, len(repNames))
+				for i, rep := range repNames {
+					vals[i] = ds.MkProperty(rep)
+				}
+				put(ds.NewKey(aid, ns, kind, name, 0, kindKey),
+					ds.PropertyMap{"property_representation": vals})
+			}
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	dsS "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/info"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestMetadataQueries(t *testing.T) {
+	t.Parallel()
+
+	Convey("metadata queries", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		So(ds.Put(dsS.PropertyMap{
+			"$key":    {dsS.MkPropertyNI(ds.MakeKey("Bar", 1))},
+			"Name":    {dsS.MkProperty("hi")},
+			"When":    {dsS.MkProperty(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))},
+			"Secret":  {dsS.MkPropertyNI("shh")},
+			"Numbers": {dsS.MkProperty(1), dsS.MkProperty(2.5)},
+		}), ShouldBeNil)
+		So(ds.Put(dsS.PropertyMap{
+			"$key": {dsS.MkPropertyNI(ds.MakeKey("Bar", 2))},
+			"Name": {dsS.MkProperty("there")},
+		}), ShouldBeNil)
+
+		nsC, err := info.Get(c).Namespace("other")
+		So(err, ShouldBeNil)
+		So(dsS.Get(nsC).Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+
+		emptyC, err := info.Get(c).Namespace("empty")
+		So(err, ShouldBeNil)
+		So(dsS.Get(emptyC).Put(&Foo{ID: 1}), ShouldBeNil)
+		So(dsS.Get(emptyC).Delete(dsS.Get(emptyC).MakeKey("Foo", 1)), ShouldBeNil)
+
+		Convey("__namespace__", func() {
+			keys := []*dsS.Key{}
+			So(ds.GetAll(dsS.NewQuery(dsS.NamespaceKind), &keys), ShouldBeNil)
+			So(keys, ShouldResemble, []*dsS.Key{
+				ds.MakeKey(dsS.NamespaceKind, 1),
+				ds.MakeKey(dsS.NamespaceKind, "other"),
+			})
+
+			names, err := dsS.Namespaces(ds)
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"", "other"})
+		})
+
+		Convey("__kind__", func() {
+			kinds, err := dsS.Kinds(ds)
+			So(err, ShouldBeNil)
+			So(kinds, ShouldResemble, []string{"Bar", "Foo"})
+
+			kinds, err = dsS.Kinds(dsS.Get(nsC))
+			So(err, ShouldBeNil)
+			So(kinds, ShouldResemble, []string{"Foo"})
+
+			Convey("with a key range", func() {
+				keys := []*dsS.Key{}
+				q := dsS.NewQuery(dsS.KindKind).Gt("__key__", ds.MakeKey(dsS.KindKind, "Bar"))
+				So(ds.GetAll(q, &keys), ShouldBeNil)
+				So(keys, ShouldResemble, []*dsS.Key{ds.MakeKey(dsS.KindKind, "Foo")})
+			})
+		})
+
+		Convey("__property__", func() {
+			props, err := dsS.KindProperties(ds, "Bar")
+			So(err, ShouldBeNil)
+			So(props, ShouldResemble, map[string][]string{
+				"Name":    {"STRING"},
+				"When":    {"INT64"},
+				"Numbers": {"DOUBLE", "INT64"},
+			})
+
+			Convey("across kinds", func() {
+				keys := []*dsS.Key{}
+				So(ds.GetAll(dsS.NewQuery(dsS.PropertyKind), &keys), ShouldBeNil)
+				barKey := ds.MakeKey(dsS.KindKind, "Bar")
+				fooKey := ds.MakeKey(dsS.KindKind, "Foo")
+				So(keys, ShouldResemble, []*dsS.Key{
+					ds.NewKey(dsS.PropertyKind, "Name", 0, barKey),
+					ds.NewKey(dsS.PropertyKind, "Numbers", 0, barKey),
+					ds.NewKey(dsS.PropertyKind, "When", 0, barKey),
+					ds.NewKey(dsS.PropertyKind, "Val", 0, fooKey),
+				})
+			})
+
+			Convey("of a missing kind", func() {
+				props, err := dsS.KindProperties(ds, "Nope")
+				So(err, ShouldBeNil)
+				So(props, ShouldBeEmpty)
+			})
+
+			Convey("excludes schema versions", func() {
+				type Versioned struct {
+					ID   int64 `gae:"$id"`
+					_    int64 `gae:"$schema,1"`
+					Name string
+				}
+				So(ds.Put(&Versioned{ID: 1, Name: "hi"}), ShouldBeNil)
+
+				props, err := dsS.KindProperties(ds, "Versioned")
+				So(err, ShouldBeNil)
+				So(props, ShouldResemble, map[string][]string{"Name": {"STRING"}})
+			})
+		})
+	})
+}
@@ -174,11 +174,19 @@ func countQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *me
 	err = executeQuery(fq, aid, ns, isTxn, idx, head, func(_ *ds.Key, _ ds.PropertyMap, _ ds.CursorCB) error {
 		ret++
 		return nil
-	})
+	}, nil)
 	return
 }
 
-func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *memStore, cb ds.RawRunCB) error {
+// executeQuery runs fq, calling cb for each result. If plan is not nil, it's
+// filled in with a description of how fq was executed.
+func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *memStore, cb ds.RawRunCB, plan *ds.QueryPlan) error {
+	if isMetadataKind(fq.Kind()) {
+		// metadata queries are always consistent.
+		head = metadataStore(head, aid, ns, fq.Kind())
+		idx = head
+	}
+
 	rq, err := reduce(fq, aid, ns, isTxn)
 	if err == ds.ErrNullQuery {
 		return nil
@@ -186,6 +194,9 @@ func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *
 	if err != nil {
 		return err
 	}
+	if plan != nil {
+		plan.LowerBound, plan.UpperBound = GetBinaryBounds(fq)
+	}
 
 	idxs, err := getIndexes(rq, idx)
 	if err == ds.ErrNullQuery {
@@ -194,6 +205,25 @@ func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *
 	if err != nil {
 		return err
 	}
+	if plan != nil {
+		defer func() {
+			for _, def := range idxs {
+				plan.Scans = append(plan.Scans, ds.IndexScan{
+					Index:       def.index,
+					Prefix:      def.prefix,
+					Start:       def.start,
+					End:         def.end,
+					RowsScanned: def.scanned,
+				})
+			}
+		}()
+
+		userCB := cb
+		cb = func(key *ds.Key, val ds.PropertyMap, getCursor ds.CursorCB) error {
+			plan.Returned++
+			return userCB(key, val, getCursor)
+		}
+	}
 
 	strategy := pickQueryStrategy(fq, rq, cb, head)
 	if strategy == nil {
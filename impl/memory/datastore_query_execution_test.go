@@ -597,4 +597,75 @@ func TestQueryExecution(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(count, ShouldEqual, 2)
 	})
+
+	Convey("Test Explain", t, func() {
+		c, err := info.Get(Use(context.Background())).Namespace("ns")
+		if err != nil {
+			panic(err)
+		}
+
+		data := ds.Get(c)
+		testing := data.Testable()
+		testing.Consistent(true)
+
+		So(data.Put(pmap("$key", key("Kind", 1), Next,
+			"Val", 1, 2, 3, Next,
+			"Extra", "hello",
+		)), ShouldBeNil)
+		So(data.Put(pmap("$key", key("Kind", 2), Next,
+			"Val", 2, 3, 9, Next,
+			"Extra", "ace", "hello", "there",
+		)), ShouldBeNil)
+		So(data.Put(pmap("$key", key("Other", 1), Next,
+			"Val", 2,
+		)), ShouldBeNil)
+
+		Convey("builtin index", func() {
+			plan, err := testing.Explain(nq("Kind").Gt("Val", 1).Lt("Val", 9))
+			So(err, ShouldBeNil)
+			So(len(plan.Scans), ShouldEqual, 1)
+			So(plan.Scans[0].Index, ShouldResemble, &ds.IndexDefinition{
+				Kind: "Kind", SortBy: []ds.IndexColumn{{Property: "Val"}}})
+			So(plan.LowerBound, ShouldNotBeNil)
+			So(plan.UpperBound, ShouldNotBeNil)
+			// 2, 3 from Kind,1 and 2, 3 from Kind,2 are in range.
+			So(plan.RowsScanned(), ShouldEqual, 4)
+			So(plan.Returned, ShouldEqual, 2)
+			So(plan.String(), ShouldContainSubstring, "rows scanned: 4, returned: 2")
+		})
+
+		Convey("merge join", func() {
+			plan, err := testing.Explain(nq("Kind").Eq("Val", 2).Eq("Extra", "hello"))
+			So(err, ShouldBeNil)
+			So(len(plan.Scans), ShouldEqual, 2)
+			So(plan.LowerBound, ShouldBeNil)
+			So(plan.Returned, ShouldEqual, 2)
+		})
+
+		Convey("kindless", func() {
+			plan, err := testing.Explain(nq(""))
+			So(err, ShouldBeNil)
+			So(len(plan.Scans), ShouldEqual, 1)
+			So(plan.Scans[0].Index, ShouldBeNil)
+			So(plan.Returned, ShouldBeGreaterThanOrEqualTo, 3)
+		})
+
+		Convey("limit and offset", func() {
+			plan, err := testing.Explain(nq("Kind").Offset(1).Limit(1))
+			So(err, ShouldBeNil)
+			So(plan.Returned, ShouldEqual, 1)
+		})
+
+		Convey("missing index", func() {
+			q := nq("Kind").Gt("Val", 2).Order("Val", "Extra")
+			_, err := testing.Explain(q)
+			So(err, ShouldErrLike, "Insufficient indexes")
+
+			testing.AutoIndex(true)
+			plan, err := testing.Explain(q)
+			So(err, ShouldBeNil)
+			So(plan.Scans[0].Index.Normalize(), ShouldResemble, testing.AutoIndexed()[0].Normalize())
+			So(plan.Returned, ShouldEqual, 2)
+		})
+	})
 }
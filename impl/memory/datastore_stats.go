@@ -0,0 +1,366 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	ds "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/datastore/serialize"
+	"github.com/luci/gkvlite"
+)
+
+// statTotalKeyName is the key name of the __Stat_Total__ and __Stat_Ns_Total__
+// entities.
+const statTotalKeyName = "total_entity_usage"
+
+// These are the suffixes of the statistics kinds, e.g. "Kind" is
+// __Stat_Kind__ (in the default namespace, covering every namespace) and
+// __Stat_Ns_Kind__ (in each namespace, covering just that namespace).
+const (
+	statTotal                        = "Total"
+	statNamespace                    = "Namespace" // global only
+	statKind                         = "Kind"
+	statKindIsRootEntity             = "Kind_IsRootEntity"
+	statKindNotRootEntity            = "Kind_NotRootEntity"
+	statPropertyType                 = "PropertyType"
+	statPropertyTypeKind             = "PropertyType_Kind"
+	statPropertyNameKind             = "PropertyName_Kind"
+	statPropertyTypePropertyNameKind = "PropertyType_PropertyName_Kind"
+)
+
+// statPropertyTypeNames maps property types to the names that production uses
+// for them in the property_type property. PTBytes and PTString are "Blob" and
+// "Text" when unindexed, see statPropertyTypeName.
+var statPropertyTypeNames = map[ds.PropertyType]string{
+	ds.PTNull:        "NULL",
+	ds.PTInt:         "Integer",
+	ds.PTTime:        "Date/Time",
+	ds.PTBool:        "Boolean",
+	ds.PTBytes:       "ShortBlob",
+	ds.PTString:      "String",
+	ds.PTFloat:       "Float",
+	ds.PTGeoPoint:    "GeoPt",
+	ds.PTKey:         "Key",
+	ds.PTBlobKey:     "BlobKey",
+	ds.PTPropertyMap: "EmbeddedEntity",
+}
+
+func statPropertyTypeName(p *ds.Property) string {
+	if p.IndexSetting() == ds.NoIndex {
+		switch p.Type() {
+		case ds.PTBytes:
+			return "Blob"
+		case ds.PTString:
+			return "Text"
+		}
+	}
+	return statPropertyTypeNames[p.Type()]
+}
+
+func isStatKind(kind string) bool {
+	return strings.HasPrefix(kind, "__Stat_") && strings.HasSuffix(kind, "__")
+}
+
+// statUsage is the usage of some subset of the datastore. Sizes are estimated
+// with EstimateSize, so they're only comparable to each other, not to
+// production.
+type statUsage struct {
+	count int64
+
+	entityBytes int64
+
+	builtinIndexBytes int64
+	builtinIndexCount int64
+
+	compositeIndexBytes int64
+	compositeIndexCount int64
+}
+
+func (u *statUsage) add(o *statUsage) {
+	u.count += o.count
+	u.entityBytes += o.entityBytes
+	u.builtinIndexBytes += o.builtinIndexBytes
+	u.builtinIndexCount += o.builtinIndexCount
+	u.compositeIndexBytes += o.compositeIndexBytes
+	u.compositeIndexCount += o.compositeIndexCount
+}
+
+// statSubject identifies the subject of a single statistics entity. Which
+// fields are set depends on the statistics kind.
+type statSubject struct {
+	namespace    string
+	kind         string
+	propertyType string
+	propertyName string
+}
+
+// keyName returns the key name of the statistics entity for s, or "" if it
+// should have the integer ID 1 instead (like the default namespace in
+// __namespace__ metadata).
+func (s statSubject) keyName(suffix string) string {
+	switch suffix {
+	case statTotal:
+		return statTotalKeyName
+	case statNamespace:
+		return s.namespace
+	}
+	parts := make([]string, 0, 3)
+	for _, p := range []string{s.propertyType, s.propertyName, s.kind} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// statTable is the statistics of some part of the datastore, by statistics
+// kind suffix (e.g. statKind) and subject.
+type statTable map[string]map[statSubject]*statUsage
+
+func (t statTable) add(suffix string, subj statSubject, u *statUsage) {
+	subjs := t[suffix]
+	if subjs == nil {
+		subjs = map[statSubject]*statUsage{}
+		t[suffix] = subjs
+	}
+	cur := subjs[subj]
+	if cur == nil {
+		cur = &statUsage{}
+		subjs[subj] = cur
+	}
+	cur.add(u)
+}
+
+// addEntity adds the usage of the entity k to t.
+func (t statTable) addEntity(k *ds.Key, pm ds.PropertyMap, compIdx []*ds.IndexDefinition) {
+	keySize := k.EstimateSize()
+	kind := k.Kind()
+
+	// Every entity has a row in the kind index.
+	u := &statUsage{
+		count:             1,
+		entityBytes:       keySize + pm.EstimateSize(),
+		builtinIndexBytes: keySize,
+		builtinIndexCount: 1,
+	}
+
+	for name, vals := range pm {
+		for i := range vals {
+			v := &vals[i]
+			pu := &statUsage{
+				count:       1,
+				entityBytes: int64(len(name)) + v.EstimateSize(),
+			}
+			if v.IndexSetting() == ds.ShouldIndex {
+				// ascending and descending
+				pu.builtinIndexCount = 2
+				pu.builtinIndexBytes = 2 * (int64(len(name)) + v.EstimateSize() + keySize)
+			}
+			u.builtinIndexBytes += pu.builtinIndexBytes
+			u.builtinIndexCount += pu.builtinIndexCount
+
+			typ := statPropertyTypeName(v)
+			t.add(statPropertyType, statSubject{propertyType: typ}, pu)
+			t.add(statPropertyTypeKind, statSubject{propertyType: typ, kind: kind}, pu)
+			t.add(statPropertyNameKind, statSubject{propertyName: name, kind: kind}, pu)
+			t.add(statPropertyTypePropertyNameKind,
+				statSubject{propertyType: typ, propertyName: name, kind: kind}, pu)
+		}
+	}
+
+	kindIdx := make([]*ds.IndexDefinition, 0, len(compIdx))
+	for _, idx := range compIdx {
+		if idx.Kind == kind {
+			kindIdx = append(kindIdx, idx)
+		}
+	}
+	if len(kindIdx) > 0 {
+		rows := indexEntries(serialize.PropertyMapPartially(k, pm), k.Namespace(), kindIdx)
+		for _, name := range rows.GetCollectionNames() {
+			if !strings.HasPrefix(name, "idx:") {
+				continue
+			}
+			rows.GetCollection(name).VisitItemsAscend(nil, false, func(i *gkvlite.Item) bool {
+				u.compositeIndexCount++
+				u.compositeIndexBytes += int64(len(i.Key))
+				return true
+			})
+		}
+	}
+
+	t.add(statTotal, statSubject{}, u)
+	t.add(statKind, statSubject{kind: kind}, u)
+	if k.Parent() == nil {
+		t.add(statKindIsRootEntity, statSubject{kind: kind}, u)
+	} else {
+		t.add(statKindNotRootEntity, statSubject{kind: kind}, u)
+	}
+}
+
+// entities returns the statistics entities for t, in namespace ns, ordered by
+// key. prefix is "__Stat_" or "__Stat_Ns_".
+func (t statTable) entities(aid, ns, prefix string, now time.Time) statEntities {
+	ret := statEntities{}
+	for suffix, subjs := range t {
+		for subj, u := range subjs {
+			pm := ds.PropertyMap{
+				"count":        {ds.MkProperty(u.count)},
+				"entity_bytes": {ds.MkProperty(u.entityBytes)},
+				"timestamp":    {ds.MkProperty(now)},
+			}
+			total := u.entityBytes
+
+			switch suffix {
+			case statKindIsRootEntity, statKindNotRootEntity:
+				// these only have the entity usage.
+
+			case statTotal, statNamespace, statKind:
+				pm["composite_index_bytes"] = ds.PropertySlice{ds.MkProperty(u.compositeIndexBytes)}
+				pm["composite_index_count"] = ds.PropertySlice{ds.MkProperty(u.compositeIndexCount)}
+				total += u.compositeIndexBytes
+				fallthrough
+
+			default:
+				pm["builtin_index_bytes"] = ds.PropertySlice{ds.MkProperty(u.builtinIndexBytes)}
+				pm["builtin_index_count"] = ds.PropertySlice{ds.MkProperty(u.builtinIndexCount)}
+				total += u.builtinIndexBytes
+			}
+			pm["bytes"] = ds.PropertySlice{ds.MkProperty(total)}
+
+			if suffix == statNamespace {
+				pm["subject_namespace"] = ds.PropertySlice{ds.MkProperty(subj.namespace)}
+			}
+			if subj.kind != "" {
+				pm["kind_name"] = ds.PropertySlice{ds.MkProperty(subj.kind)}
+			}
+			if subj.propertyType != "" {
+				pm["property_type"] = ds.PropertySlice{ds.MkProperty(subj.propertyType)}
+			}
+			if subj.propertyName != "" {
+				pm["property_name"] = ds.PropertySlice{ds.MkProperty(subj.propertyName)}
+			}
+
+			kind := prefix + suffix + "__"
+			key := (*ds.Key)(nil)
+			if name := subj.keyName(suffix); name != "" {
+				key = ds.NewKey(aid, ns, kind, name, 0, nil)
+			} else {
+				key = ds.NewKey(aid, ns, kind, "", 1, nil)
+			}
+			ret = append(ret, statEntity{key, pm})
+		}
+	}
+	// Writes must happen in a deterministic order for SetConsistencyPolicy's
+	// sake.
+	sort.Sort(ret)
+	return ret
+}
+
+type statEntity struct {
+	key *ds.Key
+	pm  ds.PropertyMap
+}
+
+type statEntities []statEntity
+
+func (s statEntities) Len() int           { return len(s) }
+func (s statEntities) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statEntities) Less(i, j int) bool { return s[i].key.Less(s[j].key) }
+
+// updateStats replaces all of the statistics entities with ones computed from
+// the current state of the datastore, timestamped with now.
+func (d *dataStoreData) updateStats(now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	compIdx := []*ds.IndexDefinition{}
+	walkCompIdxs(d.head, nil, func(i *ds.IndexDefinition) bool {
+		compIdx = append(compIdx, i)
+		return true
+	})
+
+	namespaces := []string{}
+	for _, name := range d.head.GetCollectionNames() {
+		if strings.HasPrefix(name, "ents:") {
+			namespaces = append(namespaces, name[len("ents:"):])
+		}
+	}
+
+	global := statTable{}
+	global.add(statTotal, statSubject{}, &statUsage{})
+	nsTables := make(map[string]statTable, len(namespaces))
+	for _, ns := range namespaces {
+		d.deleteStatsLocked(ns)
+
+		t := statTable{}
+		visitUserEntities(d.head, d.aid, ns, func(k *ds.Key, pm ds.PropertyMap) bool {
+			t.addEntity(k, pm, compIdx)
+			global.addEntity(k, pm, compIdx)
+			return true
+		})
+		if len(t) > 0 {
+			global.add(statNamespace, statSubject{namespace: ns}, t[statTotal][statSubject{}])
+			nsTables[ns] = t
+		}
+	}
+
+	for _, ns := range namespaces {
+		if t := nsTables[ns]; t != nil {
+			d.putStatsLocked(ns, t.entities(d.aid, ns, "__Stat_Ns_", now))
+		}
+	}
+	d.putStatsLocked("", global.entities(d.aid, "", "__Stat_", now))
+}
+
+func (d *dataStoreData) deleteStatsLocked(ns string) {
+	ents := d.head.GetCollection("ents:" + ns)
+	if ents == nil {
+		return
+	}
+
+	keys := []*ds.Key{}
+	vals := []ds.PropertyMap{}
+	ents.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+		prop, err := serialize.ReadProperty(bytes.NewBuffer(i.Key), serialize.WithoutContext, d.aid, ns)
+		memoryCorruption(err)
+		k := prop.Value().(*ds.Key)
+		if isStatKind(k.Kind()) {
+			pm, err := rpm(i.Val)
+			memoryCorruption(err)
+			keys = append(keys, k)
+			vals = append(vals, pm)
+		}
+		return true
+	})
+
+	for i, k := range keys {
+		if !d.disableSpecialEntities {
+			incrementLocked(ents, groupMetaKey(k), 1)
+		}
+		ents.Delete(keyBytes(k))
+		updateIndexes(d.head, k, vals[i], nil)
+		if d.sim != nil {
+			d.sim.recordLocked(ents, k, nil)
+		}
+	}
+}
+
+func (d *dataStoreData) putStatsLocked(ns string, stats statEntities) {
+	ents := d.mutableEntsLocked(ns)
+	for _, s := range stats {
+		if !d.disableSpecialEntities {
+			incrementLocked(ents, groupMetaKey(s.key), 1)
+		}
+		ents.Set(keyBytes(s.key), serialize.ToBytes(s.pm))
+		updateIndexes(d.head, s.key, nil, s.pm)
+		if d.sim != nil {
+			d.sim.recordLocked(ents, s.key, s.pm)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	dsS "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/info"
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestUpdateStats(t *testing.T) {
+	t.Parallel()
+
+	Convey("Testable.UpdateStats", t, func() {
+		now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+		c, tc := testclock.UseTime(context.Background(), now)
+		c = Use(c)
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		root := ds.MakeKey("Foo", 1)
+		So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+		So(ds.Put(&Foo{ID: 2, Parent: root, Val: 2}), ShouldBeNil)
+		So(ds.Put(dsS.PropertyMap{
+			"$key":  {dsS.MkPropertyNI(ds.MakeKey("Bar", "a"))},
+			"Name":  {dsS.MkProperty("hi")},
+			"Rank":  {dsS.MkProperty(10)},
+			"Notes": {dsS.MkPropertyNI("unindexed")},
+		}), ShouldBeNil)
+
+		otherC, err := info.Get(c).Namespace("other")
+		So(err, ShouldBeNil)
+		other := dsS.Get(otherC)
+		So(other.Put(&Foo{ID: 1, Val: 3}), ShouldBeNil)
+
+		ds.Testable().UpdateStats()
+
+		getStat := func(ds dsS.Interface, key *dsS.Key) dsS.PropertyMap {
+			pm := dsS.PropertyMap{"$key": {dsS.MkPropertyNI(key)}}
+			So(ds.Get(pm), ShouldBeNil)
+			return pm
+		}
+		intOf := func(pm dsS.PropertyMap, name string) int64 {
+			return pm[name][0].Value().(int64)
+		}
+
+		Convey("__Stat_Total__", func() {
+			pm := getStat(ds, ds.MakeKey("__Stat_Total__", "total_entity_usage"))
+			So(intOf(pm, "count"), ShouldEqual, 4)
+			So(pm["timestamp"][0].Value(), ShouldResemble, now)
+			So(intOf(pm, "builtin_index_count"), ShouldEqual, 4+2*5)
+			So(intOf(pm, "composite_index_count"), ShouldEqual, 0)
+			So(intOf(pm, "bytes"), ShouldEqual,
+				intOf(pm, "entity_bytes")+intOf(pm, "builtin_index_bytes"))
+
+			Convey("isn't visible to metadata queries", func() {
+				kinds, err := dsS.Kinds(ds)
+				So(err, ShouldBeNil)
+				So(kinds, ShouldResemble, []string{"Bar", "Foo"})
+			})
+		})
+
+		Convey("__Stat_Namespace__", func() {
+			pm := getStat(ds, ds.MakeKey("__Stat_Namespace__", 1))
+			So(pm["subject_namespace"][0].Value(), ShouldEqual, "")
+			So(intOf(pm, "count"), ShouldEqual, 3)
+
+			pm = getStat(ds, ds.MakeKey("__Stat_Namespace__", "other"))
+			So(intOf(pm, "count"), ShouldEqual, 1)
+		})
+
+		Convey("__Stat_Kind__", func() {
+			pms := []dsS.PropertyMap{}
+			So(ds.GetAll(dsS.NewQuery("__Stat_Kind__").Eq("kind_name", "Foo"), &pms), ShouldBeNil)
+			So(len(pms), ShouldEqual, 1)
+			So(intOf(pms[0], "count"), ShouldEqual, 3)
+
+			pm := getStat(ds, ds.MakeKey("__Stat_Kind_IsRootEntity__", "Foo"))
+			So(intOf(pm, "count"), ShouldEqual, 2)
+			So(pm["builtin_index_count"], ShouldBeNil)
+			So(intOf(pm, "bytes"), ShouldEqual, intOf(pm, "entity_bytes"))
+
+			pm = getStat(ds, ds.MakeKey("__Stat_Kind_NotRootEntity__", "Foo"))
+			So(intOf(pm, "count"), ShouldEqual, 1)
+		})
+
+		Convey("__Stat_PropertyType__", func() {
+			pm := getStat(ds, ds.MakeKey("__Stat_PropertyType__", "Integer"))
+			So(pm["property_type"][0].Value(), ShouldEqual, "Integer")
+			So(intOf(pm, "count"), ShouldEqual, 4)
+			So(intOf(pm, "builtin_index_count"), ShouldEqual, 8)
+
+			pm = getStat(ds, ds.MakeKey("__Stat_PropertyType__", "Text"))
+			So(intOf(pm, "count"), ShouldEqual, 1)
+			So(intOf(pm, "builtin_index_count"), ShouldEqual, 0)
+
+			pm = getStat(ds, ds.MakeKey("__Stat_PropertyType_PropertyName_Kind__", "String_Name_Bar"))
+			So(pm["property_name"][0].Value(), ShouldEqual, "Name")
+			So(pm["kind_name"][0].Value(), ShouldEqual, "Bar")
+			So(intOf(pm, "count"), ShouldEqual, 1)
+
+			Convey("embedded entities", func() {
+				So(ds.Put(dsS.PropertyMap{
+					"$key":     {dsS.MkPropertyNI(ds.MakeKey("Baz", 1))},
+					"Embedded": {dsS.MkPropertyNI(dsS.PropertyMap{"Val": {dsS.MkProperty(1)}})},
+				}), ShouldBeNil)
+				ds.Testable().UpdateStats()
+
+				pm := getStat(ds, ds.MakeKey("__Stat_PropertyType__", "EmbeddedEntity"))
+				So(pm["property_type"][0].Value(), ShouldEqual, "EmbeddedEntity")
+				So(intOf(pm, "count"), ShouldEqual, 1)
+				So(intOf(pm, "builtin_index_count"), ShouldEqual, 0)
+
+				pm = getStat(ds, ds.MakeKey("__Stat_PropertyType_PropertyName_Kind__", "EmbeddedEntity_Embedded_Baz"))
+				So(intOf(pm, "count"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("__Stat_Ns_*__", func() {
+			pm := getStat(other, other.MakeKey("__Stat_Ns_Total__", "total_entity_usage"))
+			So(intOf(pm, "count"), ShouldEqual, 1)
+
+			pm = getStat(other, other.MakeKey("__Stat_Ns_Kind__", "Foo"))
+			So(intOf(pm, "count"), ShouldEqual, 1)
+
+			pm = getStat(ds, ds.MakeKey("__Stat_Ns_Kind__", "Foo"))
+			So(intOf(pm, "count"), ShouldEqual, 2)
+		})
+
+		Convey("composite indexes", func() {
+			ds.Testable().AddIndexes(&dsS.IndexDefinition{Kind: "Bar", SortBy: []dsS.IndexColumn{
+				{Property: "Name"}, {Property: "Rank", Descending: true},
+			}})
+			ds.Testable().UpdateStats()
+
+			pm := getStat(ds, ds.MakeKey("__Stat_Kind__", "Bar"))
+			So(intOf(pm, "composite_index_count"), ShouldEqual, 1)
+			So(intOf(pm, "bytes"), ShouldEqual,
+				intOf(pm, "entity_bytes")+intOf(pm, "builtin_index_bytes")+intOf(pm, "composite_index_bytes"))
+		})
+
+		Convey("replaces old stats", func() {
+			So(ds.Delete(ds.MakeKey("Bar", "a")), ShouldBeNil)
+			tc.Add(time.Hour)
+			ds.Testable().UpdateStats()
+
+			pm := getStat(ds, ds.MakeKey("__Stat_Total__", "total_entity_usage"))
+			So(intOf(pm, "count"), ShouldEqual, 3)
+			So(pm["timestamp"][0].Value(), ShouldResemble, now.Add(time.Hour))
+
+			So(ds.Get(dsS.PropertyMap{
+				"$key": {dsS.MkPropertyNI(ds.MakeKey("__Stat_Kind__", "Bar"))},
+			}), ShouldEqual, dsS.ErrNoSuchEntity)
+		})
+	})
+}
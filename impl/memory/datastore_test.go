@@ -6,6 +6,9 @@ package memory
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -528,6 +531,102 @@ func TestDatastoreSingleReadWriter(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(count, ShouldEqual, 1) // normally this would include __entity_group__
 		})
+
+		Convey("Testable.StrictLimits", func() {
+			type Blob struct {
+				ID     int64    `gae:"$id"`
+				Parent *dsS.Key `gae:"$parent"`
+
+				Data []byte `gae:",noindex"`
+				Str  string
+			}
+			huge := make([]byte, dsS.MaxEntitySize)
+
+			So(ds.Put(&Blob{ID: 1, Data: huge}), ShouldBeNil)
+
+			ds.Testable().StrictLimits(true)
+
+			err := ds.Put(&Blob{ID: 2, Data: huge})
+			So(err, ShouldHaveSameTypeAs, &dsS.ErrBadRequest{})
+			So(err, ShouldErrLike, "entity is too big")
+			So(ds.Get(&Blob{ID: 2}), ShouldEqual, dsS.ErrNoSuchEntity)
+
+			long := string(make([]byte, dsS.MaxIndexedPropertyBytes+1))
+			So(ds.Put(&Blob{ID: 3, Str: long}), ShouldErrLike, `"Str" is longer than 1500 bytes`)
+			So(ds.Put(&Blob{ID: 3, Data: []byte(long)}), ShouldBeNil)
+
+			Convey("batch sizes", func() {
+				blobs := make([]*Blob, dsS.MaxGetBatchSize+1)
+				keys := make([]*dsS.Key, len(blobs))
+				for i := range blobs {
+					blobs[i] = &Blob{ID: int64(i + 1)}
+					keys[i] = ds.MakeKey("Blob", i+1)
+				}
+
+				err := ds.GetMulti(blobs)
+				So(err, ShouldHaveSameTypeAs, &dsS.ErrBadRequest{})
+				So(err, ShouldErrLike, "more than 1000 keys")
+
+				So(ds.PutMulti(blobs[:dsS.MaxPutBatchSize+1]), ShouldErrLike, "more than 500 entities")
+				So(ds.DeleteMulti(keys[:dsS.MaxDeleteBatchSize+1]), ShouldErrLike, "more than 500 keys")
+
+				Convey("only when enabled", func() {
+					ds.Testable().StrictLimits(false)
+					So(ds.PutMulti(blobs[:dsS.MaxPutBatchSize+1]), ShouldBeNil)
+					So(ds.PutMulti(blobs[dsS.MaxPutBatchSize+1:]), ShouldBeNil)
+					So(ds.GetMulti(blobs), ShouldBeNil)
+					So(ds.DeleteMulti(keys[:dsS.MaxDeleteBatchSize+1]), ShouldBeNil)
+				})
+			})
+
+			Convey("in transactions", func() {
+				root := ds.MakeKey("Root", 1)
+				big := make([]byte, dsS.MaxEntitySize-100)
+				err := ds.RunInTransaction(func(c context.Context) error {
+					ds := dsS.Get(c)
+					So(ds.Put(&Blob{ID: 1, Parent: root, Data: huge}), ShouldErrLike, "entity is too big")
+					for i := 0; i < 11; i++ {
+						So(ds.Put(&Blob{ID: int64(i + 1), Parent: root, Data: big}), ShouldBeNil)
+					}
+					return nil
+				}, nil)
+				So(err, ShouldHaveSameTypeAs, &dsS.ErrBadRequest{})
+				So(err, ShouldErrLike, "transaction or write too big")
+
+				// Like production, the whole commit fails.
+				for i := 0; i < 11; i++ {
+					So(ds.Get(&Blob{ID: int64(i + 1), Parent: root}), ShouldEqual, dsS.ErrNoSuchEntity)
+				}
+			})
+		})
+
+		Convey("Embedded entities round trip", func() {
+			type Inner struct {
+				Key  *dsS.Key `gae:"$key"`
+				Tags []string
+			}
+			type Outer struct {
+				ID    int64   `gae:"$id"`
+				Inner []Inner `gae:",entity"`
+			}
+
+			o := &Outer{ID: 1, Inner: []Inner{
+				{Key: ds.MakeKey("Inner", "a"), Tags: []string{"x", "y"}},
+				{Tags: []string{"z"}},
+			}}
+			So(ds.Put(o), ShouldBeNil)
+
+			got := &Outer{ID: 1}
+			So(ds.Get(got), ShouldBeNil)
+			So(got, ShouldResemble, o)
+
+			Convey("and they're never indexed", func() {
+				ds.Testable().CatchupIndexes()
+				count, err := ds.Count(dsS.NewQuery("Outer").Eq("Inner.Tags", "x"))
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 0)
+			})
+		})
 	})
 }
 
@@ -585,6 +684,105 @@ func TestCompoundIndexes(t *testing.T) {
 	})
 }
 
+func TestIndexesFromYAML(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test AddIndexesFromYAML", t, func() {
+		dir, err := ioutil.TempDir("", "gae_index_yaml")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "index.yaml")
+
+		ds := dsS.Get(Use(context.Background()))
+		ds.Testable().Consistent(true)
+
+		type Model struct {
+			ID     int64 `gae:"$id"`
+			Field1 string
+			Field2 int64
+		}
+		So(ds.PutMulti([]*Model{{1, "a", 2}, {2, "b", 1}}), ShouldBeNil)
+
+		q := dsS.NewQuery("Model").Eq("Field1", "a").Order("-Field2")
+
+		Convey("adds the indexes", func() {
+			So(ioutil.WriteFile(path, []byte(`indexes:
+- kind: Model
+  properties:
+  - name: Field1
+  - name: Field2
+    direction: desc
+`), 0666), ShouldBeNil)
+
+			So(ds.Run(q, func(*Model) {}), ShouldErrLike, "Insufficient indexes")
+			So(ds.Testable().AddIndexesFromYAML(path), ShouldBeNil)
+
+			vals := []*Model(nil)
+			So(ds.GetAll(q, &vals), ShouldBeNil)
+			So(vals, ShouldResemble, []*Model{{1, "a", 2}})
+		})
+
+		Convey("reports errors", func() {
+			So(ioutil.WriteFile(path, []byte("indexes:\n- kind: Model\n  propertys:\n"), 0666), ShouldBeNil)
+			So(ds.Testable().AddIndexesFromYAML(path), ShouldErrLike,
+				`index.yaml line 3: unknown index key "propertys"`)
+
+			So(ds.Testable().AddIndexesFromYAML(filepath.Join(dir, "nope.yaml")), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAutoIndexed(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test AutoIndexed", t, func() {
+		ds := dsS.Get(Use(context.Background()))
+		ds.Testable().Consistent(true)
+		ds.Testable().AutoIndex(true)
+
+		type Model struct {
+			ID     int64 `gae:"$id"`
+			Field1 string
+			Field2 int64
+		}
+		So(ds.Put(&Model{1, "a", 2}), ShouldBeNil)
+		So(ds.Testable().AutoIndexed(), ShouldBeEmpty)
+
+		run := func(q *dsS.Query) {
+			vals := []*Model(nil)
+			So(ds.GetAll(q, &vals), ShouldBeNil)
+		}
+		run(dsS.NewQuery("Model").Eq("Field1", "a").Order("-Field2"))
+		run(dsS.NewQuery("Model").Eq("Field1", "a").Order("-Field2"))
+		run(dsS.NewQuery("Model").Order("Field1").Order("Field2"))
+		run(dsS.NewQuery("Model").Eq("Field1", "a"))
+
+		idxs := ds.Testable().AutoIndexed()
+		So(idxs, ShouldResemble, []*dsS.IndexDefinition{
+			{Kind: "Model", SortBy: []dsS.IndexColumn{{Property: "Field1"}, {Property: "Field2"}, {Property: "__key__"}}},
+			{Kind: "Model", SortBy: []dsS.IndexColumn{{Property: "Field1"}, {Property: "Field2", Descending: true}, {Property: "__key__"}}},
+		})
+
+		merged, diff, err := dsS.MergeIndexYAML("indexes:\n", idxs)
+		So(err, ShouldBeNil)
+		So(diff, ShouldNotEqual, "")
+		_, diff, err = dsS.MergeIndexYAML(merged, idxs)
+		So(err, ShouldBeNil)
+		So(diff, ShouldEqual, "")
+
+		Convey("records equivalent indexes once, normalized", func() {
+			d := newDataStoreData("dev~app")
+			d.recordAutoIndex(&dsS.IndexDefinition{Kind: "Model", SortBy: []dsS.IndexColumn{
+				{Property: "Field1"}, {Property: "Field2"}}})
+			d.recordAutoIndex(&dsS.IndexDefinition{Kind: "Model", SortBy: []dsS.IndexColumn{
+				{Property: "Field1"}, {Property: "Field2"}, {Property: "__key__"}}})
+			So(d.getAutoIndexed(), ShouldResemble, []*dsS.IndexDefinition{
+				{Kind: "Model", SortBy: []dsS.IndexColumn{{Property: "Field1"}, {Property: "Field2"}, {Property: "__key__"}}},
+			})
+		})
+	})
+}
+
 // High level test for regression in how zero time is stored,
 // see https://codereview.chromium.org/1334043003/
 func TestDefaultTimeField(t *testing.T) {
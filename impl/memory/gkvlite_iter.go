@@ -36,6 +36,13 @@ type iterDefinition struct {
 	// included in the interation result). If this is nil, then there's no end
 	// except the natural end of the collection.
 	end []byte
+
+	// index is the index that c contains, or nil if c is the entity table. It's
+	// only used to describe the query plan.
+	index *datastore.IndexDefinition
+
+	// scanned is the number of rows that multiIterate has read from c.
+	scanned int64
 }
 
 func multiIterate(defs []*iterDefinition, cb func(suffix []byte) error) error {
@@ -75,6 +82,7 @@ func multiIterate(defs []*iterDefinition, cb func(suffix []byte) error) error {
 					return
 				}
 
+				def.scanned++
 				sfxRO := itm.Key[pfxLen:]
 
 				if bytes.Compare(sfxRO, suffix) > 0 {
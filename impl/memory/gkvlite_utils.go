@@ -84,6 +84,21 @@ func (ms *memStore) Snapshot() *memStore {
 	return ret
 }
 
+// Copy returns a new, writable store with the same contents as ms. Unlike
+// Snapshot, this copies every item, but it works on read-only stores (i.e.
+// snapshots) too.
+func (ms *memStore) Copy() *memStore {
+	ret := newMemStore()
+	for _, name := range ms.GetCollectionNames() {
+		dst := ret.SetCollection(name, nil)
+		ms.GetCollection(name).VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+			dst.Set(i.Key, i.Val)
+			return true
+		})
+	}
+	return ret
+}
+
 func (ms *memStore) MakePrivateCollection(cmp gkvlite.KeyCompare) *memCollection {
 	return (*memCollection)((*gkvlite.Store)(ms).MakePrivateCollection(cmp))
 }
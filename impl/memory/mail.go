@@ -24,6 +24,10 @@ type mailData struct {
 	adminsPlain []string
 }
 
+type mailContextKeyType int
+
+var mailContextKey mailContextKeyType
+
 // mailImpl is a contextual pointer to the current mailData.
 type mailImpl struct {
 	data *mailData
@@ -40,6 +44,7 @@ func useMail(c context.Context) context.Context {
 		admins:      []string{"admin@example.com"},
 		adminsPlain: []string{"admin@example.com"},
 	}
+	c = context.WithValue(c, mailContextKey, data)
 
 	return mail.SetFactory(c, func(c context.Context) mail.Interface {
 		return &mailImpl{data, c}
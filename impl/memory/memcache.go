@@ -153,27 +153,39 @@ type memcacheImpl struct {
 
 var _ mc.RawInterface = (*memcacheImpl)(nil)
 
+// memcacheNamespaces holds the memcacheData for every namespace.
+type memcacheNamespaces struct {
+	sync.Mutex
+	// TODO(riannucci): just use namespace for automatic key prefixing. Flush
+	// actually wipes the ENTIRE memcache, regardless of namespace.
+	data map[string]*memcacheData
+}
+
+func (m *memcacheNamespaces) get(ns string) *memcacheData {
+	m.Lock()
+	defer m.Unlock()
+
+	mcd, ok := m.data[ns]
+	if !ok {
+		mcd = &memcacheData{items: map[string]*mcDataItem{}}
+		m.data[ns] = mcd
+	}
+	return mcd
+}
+
+type mcContextKeyType int
+
+var mcContextKey mcContextKeyType
+
 // useMC adds a gae.Memcache implementation to context, accessible
 // by gae.GetMC(c)
 func useMC(c context.Context) context.Context {
-	lck := sync.Mutex{}
-	// TODO(riannucci): just use namespace for automatic key prefixing. Flush
-	// actually wipes the ENTIRE memcache, regardless of namespace.
-	mcdMap := map[string]*memcacheData{}
+	mcns := &memcacheNamespaces{data: map[string]*memcacheData{}}
+	c = context.WithValue(c, mcContextKey, mcns)
 
 	return mc.SetRawFactory(c, func(ic context.Context) mc.RawInterface {
-		lck.Lock()
-		defer lck.Unlock()
-
-		ns := curGID(ic).namespace
-		mcd, ok := mcdMap[ns]
-		if !ok {
-			mcd = &memcacheData{items: map[string]*mcDataItem{}}
-			mcdMap[ns] = mcd
-		}
-
 		return &memcacheImpl{
-			mcd,
+			mcns.get(curGID(ic).namespace),
 			ic,
 		}
 	})
@@ -0,0 +1,196 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"errors"
+
+	"github.com/luci/gae/service/mail"
+	tq "github.com/luci/gae/service/taskqueue"
+	"github.com/luci/gae/service/user"
+	"golang.org/x/net/context"
+)
+
+// Snapshot is the saved state of all of the services which Use added to a
+// context. See TakeSnapshot.
+type Snapshot struct {
+	// ds is a read-only snapshot of the datastore's head.
+	ds *memStore
+
+	tqNamed    tq.QueueData
+	tqArchived tq.QueueData
+
+	mc map[string]*memcacheData
+
+	mailQueue       []*mail.TestMessage
+	mailAdmins      []string
+	mailAdminsPlain []string
+
+	user *user.User
+}
+
+// TakeSnapshot saves the state of all of the in-memory services in c: the
+// datastore's entities, indexes and ID counters, the taskqueue's queues and
+// their scheduled and tombstoned tasks, memcache's items and statistics, mail's
+// sent messages and admins, and the current user. c must have been derived
+// from a context passed to Use or UseWithAppID.
+//
+// The datastore's state is saved with a copy-on-write snapshot, so this is
+// cheap even for large datastores. Settings, like Testable.Consistent,
+// AutoIndex and the operation cost totals, aren't part of the snapshot.
+//
+// This allows building a fixture once, and then resetting to it with
+// RestoreSnapshot, e.g. between the cases of a table-driven test.
+func TakeSnapshot(c context.Context) *Snapshot {
+	memctx := mustCurNoTxn(c)
+	ret := &Snapshot{}
+
+	ret.ds = memctx.Get(memContextDSIdx).(*dataStoreData).takeSnapshot()
+
+	tqd := memctx.Get(memContextTQIdx).(*taskQueueData)
+	tqd.Lock()
+	ret.tqNamed = dupQueue(tqd.named)
+	ret.tqArchived = dupQueue(tqd.archived)
+	tqd.Unlock()
+
+	ret.mc = c.Value(mcContextKey).(*memcacheNamespaces).snapshot()
+
+	md := c.Value(mailContextKey).(*mailData)
+	md.Lock()
+	ret.mailQueue = append([]*mail.TestMessage(nil), md.queue...)
+	ret.mailAdmins = md.admins
+	ret.mailAdminsPlain = md.adminsPlain
+	md.Unlock()
+
+	ud := c.Value(userContextKey).(*userData)
+	ud.RLock()
+	if ud.user != nil {
+		u := *ud.user
+		ret.user = &u
+	}
+	ud.RUnlock()
+
+	return ret
+}
+
+// RestoreSnapshot replaces the state of all of the in-memory services in c
+// with the state saved in s by TakeSnapshot. s may be restored any number of
+// times, into c or into any other context which was derived from the same Use
+// call.
+//
+// Restoring the datastore copies every item in the snapshot. If the datastore
+// is eventually consistent, its indexes are caught up to the restored state.
+//
+// This must not be called while a transaction is in progress.
+func RestoreSnapshot(c context.Context, s *Snapshot) {
+	memctx := mustCurNoTxn(c)
+
+	memctx.Get(memContextDSIdx).(*dataStoreData).restoreSnapshot(s.ds)
+
+	tqd := memctx.Get(memContextTQIdx).(*taskQueueData)
+	tqd.Lock()
+	tqd.named = dupQueue(s.tqNamed)
+	tqd.archived = dupQueue(s.tqArchived)
+	tqd.Unlock()
+
+	c.Value(mcContextKey).(*memcacheNamespaces).restore(s.mc)
+
+	md := c.Value(mailContextKey).(*mailData)
+	md.Lock()
+	md.queue = append([]*mail.TestMessage(nil), s.mailQueue...)
+	md.admins = s.mailAdmins
+	md.adminsPlain = s.mailAdminsPlain
+	md.Unlock()
+
+	ud := c.Value(userContextKey).(*userData)
+	ud.Lock()
+	ud.user = nil
+	if s.user != nil {
+		u := *s.user
+		ud.user = &u
+	}
+	ud.Unlock()
+}
+
+func mustCurNoTxn(c context.Context) *memContext {
+	memctx := curNoTxn(c)
+	if memctx == nil {
+		panic(errors.New("memory: context was not derived from memory.Use"))
+	}
+	return memctx
+}
+
+// restoreSnapshot replaces the datastore's contents with a copy of snap.
+func (d *dataStoreData) restoreSnapshot(snap *memStore) {
+	head := snap.Copy()
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.head = head
+	if d.snap != nil {
+		d.snap = head.Snapshot()
+	}
+	if d.sim != nil {
+		d.sim = newConsistencySim(d.sim.policy, d.sim.clk, head)
+	}
+}
+
+// copy returns a copy of m's items and statistics.
+func (m *memcacheData) copy() *memcacheData {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	ret := &memcacheData{
+		items: make(map[string]*mcDataItem, len(m.items)),
+		casID: m.casID,
+		stats: m.stats,
+	}
+	// items are never modified once they're created, so they can be shared.
+	for k, v := range m.items {
+		ret.items[k] = v
+	}
+	return ret
+}
+
+func (m *memcacheNamespaces) snapshot() map[string]*memcacheData {
+	m.Lock()
+	defer m.Unlock()
+
+	ret := make(map[string]*memcacheData, len(m.data))
+	for ns, mcd := range m.data {
+		ret[ns] = mcd.copy()
+	}
+	return ret
+}
+
+func (m *memcacheNamespaces) restore(snap map[string]*memcacheData) {
+	m.Lock()
+	defer m.Unlock()
+
+	// memcacheImpls hold on to their namespace's memcacheData, so it must be
+	// updated in place.
+	for ns, mcd := range m.data {
+		if _, ok := snap[ns]; !ok {
+			mcd.lock.Lock()
+			mcd.reset()
+			mcd.casID = 0
+			mcd.lock.Unlock()
+		}
+	}
+	for ns, saved := range snap {
+		saved = saved.copy()
+		mcd, ok := m.data[ns]
+		if !ok {
+			m.data[ns] = saved
+			continue
+		}
+		mcd.lock.Lock()
+		mcd.items = saved.items
+		mcd.casID = saved.casID
+		mcd.stats = saved.stats
+		mcd.lock.Unlock()
+	}
+}
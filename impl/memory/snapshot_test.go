@@ -0,0 +1,112 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+
+	dsS "github.com/luci/gae/service/datastore"
+	"github.com/luci/gae/service/info"
+	"github.com/luci/gae/service/mail"
+	mcS "github.com/luci/gae/service/memcache"
+	tqS "github.com/luci/gae/service/taskqueue"
+	"github.com/luci/gae/service/user"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	Convey("TakeSnapshot and RestoreSnapshot", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+		mc := mcS.Get(c)
+		tq := tqS.Get(c)
+
+		// build a fixture
+		So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+		So(mc.Set(mc.NewItem("key").SetValue([]byte("value"))), ShouldBeNil)
+		So(tq.Add(&tqS.Task{Name: "task"}, ""), ShouldBeNil)
+		So(mail.Get(c).Send(&mail.Message{
+			Sender: "admin@example.com", To: []string{"a@example.com"}, Body: "hi",
+		}), ShouldBeNil)
+		user.Get(c).Testable().Login("user@example.com", "", false)
+
+		nsC, err := info.Get(c).Namespace("ns")
+		So(err, ShouldBeNil)
+		So(mcS.Get(nsC).Set(mc.NewItem("key").SetValue([]byte("ns value"))), ShouldBeNil)
+
+		snap := TakeSnapshot(c)
+
+		// mess everything up
+		So(ds.Put(&Foo{ID: 1, Val: 100}), ShouldBeNil)
+		So(ds.Put(&Foo{ID: 2, Val: 2}), ShouldBeNil)
+		So(mc.Delete("key"), ShouldBeNil)
+		So(mcS.Get(nsC).Flush(), ShouldBeNil)
+		tq.Testable().ResetTasks()
+		mail.Get(c).Testable().Reset()
+		user.Get(c).Testable().Logout()
+
+		otherC, err := info.Get(c).Namespace("other")
+		So(err, ShouldBeNil)
+		So(mcS.Get(otherC).Set(mc.NewItem("key").SetValue([]byte("x"))), ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			RestoreSnapshot(c, snap)
+
+			foo := &Foo{ID: 1}
+			So(ds.Get(foo), ShouldBeNil)
+			So(foo.Val, ShouldEqual, 1)
+			So(ds.Get(&Foo{ID: 2}), ShouldEqual, dsS.ErrNoSuchEntity)
+
+			count, err := ds.Count(dsS.NewQuery("Foo"))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			itm, err := mc.Get("key")
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldResemble, []byte("value"))
+			itm, err = mcS.Get(nsC).Get("key")
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldResemble, []byte("ns value"))
+			_, err = mcS.Get(otherC).Get("key")
+			So(err, ShouldEqual, mcS.ErrCacheMiss)
+
+			So(tq.Testable().GetScheduledTasks()["default"], ShouldContainKey, "task")
+			So(len(mail.Get(c).Testable().SentMessages()), ShouldEqual, 1)
+			So(user.Get(c).Current().Email, ShouldEqual, "user@example.com")
+
+			// changes after restoring don't affect the snapshot.
+			So(ds.Put(&Foo{ID: 3, Val: 3}), ShouldBeNil)
+			So(mc.Delete("key"), ShouldBeNil)
+			tq.Testable().ResetTasks()
+		}
+
+		Convey("allocated IDs are restored", func() {
+			k, err := ds.AllocateIDs(ds.NewKey("Foo", "", 0, nil), 1)
+			So(err, ShouldBeNil)
+
+			snap := TakeSnapshot(c)
+			_, err = ds.AllocateIDs(ds.NewKey("Foo", "", 0, nil), 10)
+			So(err, ShouldBeNil)
+			RestoreSnapshot(c, snap)
+
+			k2, err := ds.AllocateIDs(ds.NewKey("Foo", "", 0, nil), 1)
+			So(err, ShouldBeNil)
+			So(k2, ShouldEqual, k+1)
+		})
+
+		Convey("eventually consistent indexes are caught up", func() {
+			ds.Testable().Consistent(false)
+			RestoreSnapshot(c, snap)
+
+			count, err := ds.Count(dsS.NewQuery("Foo"))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
@@ -10,6 +10,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	ds "github.com/luci/gae/service/datastore"
 	tq "github.com/luci/gae/service/taskqueue"
 	"github.com/luci/luci-go/common/errors"
 	"github.com/luci/luci-go/common/mathrand"
@@ -173,11 +174,11 @@ func (t *taskqueueTxnImpl) addLocked(task *tq.Task, queueName string) (*tq.Task,
 	for _, vs := range t.anony {
 		numTasks += len(vs)
 	}
-	if numTasks+1 > 5 {
+	if numTasks+1 > ds.MaxTransactionalTasks {
 		// transactional tasks are actually implemented 'for real' as Actions which
 		// ride on the datastore. The current datastore implementation only allows
-		// a maximum of 5 Actions per transaction, and more than that result in a
-		// BAD_REQUEST.
+		// a maximum of ds.MaxTransactionalTasks Actions per transaction, and more
+		// than that result in a BAD_REQUEST.
 		return nil, errors.New("BAD_REQUEST")
 	}
 
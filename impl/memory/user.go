@@ -23,6 +23,10 @@ type userData struct {
 	user *user.User
 }
 
+type userContextKeyType int
+
+var userContextKey userContextKeyType
+
 // userImpl is a contextual pointer to the current userData.
 type userImpl struct {
 	data *userData
@@ -34,6 +38,7 @@ var _ user.Interface = (*userImpl)(nil)
 // by user.Get(c)
 func useUser(c context.Context) context.Context {
 	data := &userData{}
+	c = context.WithValue(c, userContextKey, data)
 
 	return user.SetFactory(c, func(ic context.Context) user.Interface {
 		return &userImpl{data}
@@ -111,6 +111,15 @@ func dsR2FProp(in datastore.Property) (ds.Property, error) {
 		val = bs.Key(x)
 	case appengine.GeoPoint:
 		val = ds.GeoPoint(x)
+	case *datastore.Entity:
+		pm, err := dsR2FProps(x.Properties)
+		if err != nil {
+			return ds.Property{}, err
+		}
+		if x.Key != nil {
+			pm["$key"] = []ds.Property{ds.MkPropertyNI(dsR2F(x.Key))}
+		}
+		val = pm
 	case time.Time:
 		// "appengine" layer instantiates with Local timezone.
 		if x.IsZero() {
@@ -149,33 +158,47 @@ func dsF2RProp(ctx context.Context, in ds.Property) (datastore.Property, error)
 		ret.Value = appengine.BlobKey(in.Value().(bs.Key))
 	case ds.PTGeoPoint:
 		ret.Value = appengine.GeoPoint(in.Value().(ds.GeoPoint))
+	case ds.PTPropertyMap:
+		pm := in.Value().(ds.PropertyMap)
+		ent := &datastore.Entity{}
+		if k, ok := pm.GetMeta("key"); ok {
+			if ent.Key, err = dsF2R(ctx, k.(*ds.Key)); err != nil {
+				break
+			}
+		}
+		ent.Properties, err = dsF2RProps(ctx, pm)
+		ret.Value = ent
 	default:
 		ret.Value = in.Value()
 	}
 	return ret, err
 }
 
-func (tf *typeFilter) Load(props []datastore.Property) error {
-	tf.pm = make(ds.PropertyMap, len(props))
+// dsR2FProps converts a list of SDK properties (either an entity's or an
+// embedded entity's) into a PropertyMap.
+func dsR2FProps(props []datastore.Property) (ds.PropertyMap, error) {
+	pm := make(ds.PropertyMap, len(props))
 	for _, p := range props {
 		prop, err := dsR2FProp(p)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		tf.pm[p.Name] = append(tf.pm[p.Name], prop)
+		pm[p.Name] = append(pm[p.Name], prop)
 	}
-	return nil
+	return pm, nil
 }
 
-func (tf *typeFilter) Save() ([]datastore.Property, error) {
+// dsF2RProps converts a PropertyMap into a list of SDK properties, skipping
+// meta fields.
+func dsF2RProps(ctx context.Context, pm ds.PropertyMap) ([]datastore.Property, error) {
 	props := []datastore.Property{}
-	for name, propList := range tf.pm {
+	for name, propList := range pm {
 		if len(name) != 0 && name[0] == '$' {
 			continue
 		}
 		multiple := len(propList) > 1
 		for _, prop := range propList {
-			toAdd, err := dsF2RProp(tf.ctx, prop)
+			toAdd, err := dsF2RProp(ctx, prop)
 			if err != nil {
 				return nil, err
 			}
@@ -186,3 +209,12 @@ func (tf *typeFilter) Save() ([]datastore.Property, error) {
 	}
 	return props, nil
 }
+
+func (tf *typeFilter) Load(props []datastore.Property) (err error) {
+	tf.pm, err = dsR2FProps(props)
+	return
+}
+
+func (tf *typeFilter) Save() ([]datastore.Property, error) {
+	return dsF2RProps(tf.ctx, tf.pm)
+}
@@ -134,5 +134,25 @@ func TestCheckFilter(t *testing.T) {
 			So(hit, ShouldBeFalse)
 		})
 
+		Convey("doesn't enforce batch limits", func() {
+			keys := make([]*Key, MaxGetBatchSize+1)
+			vals := make([]PropertyMap, len(keys))
+			for i := range keys {
+				keys[i] = mkKey("Kind", i+1)
+				vals[i] = PropertyMap{}
+			}
+
+			So(func() {
+				rds.GetMulti(keys, nil, func(PropertyMap, error) error { return nil })
+			}, ShouldPanic)
+
+			keys, vals = keys[:MaxPutBatchSize+1], vals[:MaxPutBatchSize+1]
+			So(func() {
+				rds.PutMulti(keys, vals, func(*Key, error) error { return nil })
+			}, ShouldPanic)
+			So(func() {
+				rds.DeleteMulti(keys, func(error) error { return nil })
+			}, ShouldPanic)
+		})
 	})
 }
@@ -0,0 +1,123 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+)
+
+// compressedMarker prefixes the []byte value of every compressed Property. It
+// is followed by a single byte holding the original PropertyType (PTString or
+// PTBytes), and then by the zlib-compressed original value.
+//
+// The marker allows compressed and uncompressed values to coexist, so that
+// data written before compression was enabled stays readable.
+const compressedMarker = "\xffgae:zlib\xff"
+
+// CompressProperty returns a compressed version of p, if p is a NoIndex
+// PTString or PTBytes Property. Any other Property is returned unchanged.
+//
+// If compressing wouldn't make the value smaller, p is also returned unchanged
+// (unless its raw value happens to begin with the compression marker, in which
+// case it's compressed anyway so that DecompressProperty can't misinterpret
+// it).
+//
+// The returned Property is always a NoIndex PTBytes. Use DecompressProperty to
+// get back the original.
+func CompressProperty(p Property) (Property, error) {
+	pt := p.Type()
+	if p.IndexSetting() != NoIndex || (pt != PTString && pt != PTBytes) {
+		return p, nil
+	}
+
+	raw := p.value.(byteSequence).bytes()
+	buf := bytes.Buffer{}
+	buf.WriteString(compressedMarker)
+	buf.WriteByte(byte(pt))
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return p, err
+	}
+	if err := w.Close(); err != nil {
+		return p, err
+	}
+
+	if buf.Len() >= len(raw) && !bytes.HasPrefix(raw, []byte(compressedMarker)) {
+		return p, nil
+	}
+	return MkPropertyNI(buf.Bytes()), nil
+}
+
+// DecompressProperty reverses CompressProperty. If p was not compressed, it's
+// returned unchanged.
+func DecompressProperty(p Property) (Property, error) {
+	if p.Type() != PTBytes {
+		return p, nil
+	}
+	data := p.Value().([]byte)
+	if !bytes.HasPrefix(data, []byte(compressedMarker)) {
+		return p, nil
+	}
+	data = data[len(compressedMarker):]
+	if len(data) == 0 {
+		return p, fmt.Errorf("gae: compressed property is missing its type")
+	}
+
+	pt := PropertyType(data[0])
+	if pt != PTString && pt != PTBytes {
+		return p, fmt.Errorf("gae: compressed property has bad type %s", pt)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return p, fmt.Errorf("gae: failed to decompress property: %s", err)
+	}
+	defer r.Close()
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return p, fmt.Errorf("gae: failed to decompress property: %s", err)
+	}
+
+	ret := Property{}
+	if pt == PTString {
+		err = ret.SetValue(string(raw), p.IndexSetting())
+	} else {
+		err = ret.SetValue(raw, p.IndexSetting())
+	}
+	return ret, err
+}
+
+// Compress compresses, in place, all of the NoIndex string and []byte
+// properties in pm with CompressProperty. Meta fields are skipped.
+//
+// This is intended to be called from the Save method of a custom
+// PropertyLoadSaver, with Decompress called from its Load method. Structs
+// using GetPLS can use the `gae:",compress"` struct tag instead.
+func (pm PropertyMap) Compress() error {
+	return pm.mapValues(CompressProperty)
+}
+
+// Decompress reverses Compress, in place. Properties which are not compressed
+// are left untouched, so it's safe to call this on data which was written
+// before compression was enabled.
+func (pm PropertyMap) Decompress() error {
+	return pm.mapValues(DecompressProperty)
+}
+
+func (pm PropertyMap) mapValues(f func(Property) (Property, error)) (err error) {
+	for name, vals := range pm {
+		if isMetaKey(name) {
+			continue
+		}
+		for i := range vals {
+			if vals[i], err = f(vals[i]); err != nil {
+				return fmt.Errorf("%s (property %q)", err, name)
+			}
+		}
+	}
+	return nil
+}
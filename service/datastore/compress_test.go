@@ -0,0 +1,99 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	big := strings.Repeat("compress me! ", 100)
+
+	Convey("CompressProperty", t, func() {
+		Convey("compresses large NoIndex strings and []bytes", func() {
+			for _, v := range []interface{}{big, []byte(big)} {
+				p := mpNI(v)
+				cp, err := CompressProperty(p)
+				So(err, ShouldBeNil)
+				So(cp.Type(), ShouldEqual, PTBytes)
+				So(cp.IndexSetting(), ShouldEqual, NoIndex)
+				So(len(cp.Value().([]byte)), ShouldBeLessThan, len(big))
+
+				dp, err := DecompressProperty(cp)
+				So(err, ShouldBeNil)
+				So(dp, ShouldResemble, p)
+			}
+		})
+
+		Convey("leaves other properties alone", func() {
+			for _, p := range []Property{mp(big), mpNI(10), mpNI("tiny")} {
+				cp, err := CompressProperty(p)
+				So(err, ShouldBeNil)
+				So(cp, ShouldResemble, p)
+			}
+		})
+
+		Convey("always compresses values which look compressed", func() {
+			p := mpNI([]byte(compressedMarker))
+			cp, err := CompressProperty(p)
+			So(err, ShouldBeNil)
+			So(cp, ShouldNotResemble, p)
+
+			dp, err := DecompressProperty(cp)
+			So(err, ShouldBeNil)
+			So(dp, ShouldResemble, p)
+		})
+	})
+
+	Convey("DecompressProperty", t, func() {
+		Convey("passes through uncompressed values", func() {
+			for _, p := range []Property{mpNI([]byte("plain")), mpNI("plain"), mp(1)} {
+				dp, err := DecompressProperty(p)
+				So(err, ShouldBeNil)
+				So(dp, ShouldResemble, p)
+			}
+		})
+
+		Convey("bad data", func() {
+			_, err := DecompressProperty(mpNI([]byte(compressedMarker)))
+			So(err, ShouldErrLike, "missing its type")
+
+			_, err = DecompressProperty(mpNI([]byte(compressedMarker + "\x01")))
+			So(err, ShouldErrLike, "bad type PTInt")
+
+			_, err = DecompressProperty(mpNI([]byte(compressedMarker + "\x05junk")))
+			So(err, ShouldErrLike, "failed to decompress")
+		})
+	})
+
+	Convey("PropertyMap", t, func() {
+		pm := PropertyMap{
+			"$kind": {mpNI(big)},
+			"Big":   {mpNI(big), mpNI([]byte(big))},
+			"Idx":   {mp(big)},
+		}
+		So(pm.Compress(), ShouldBeNil)
+		So(pm["$kind"], ShouldResemble, []Property{mpNI(big)})
+		So(pm["Idx"], ShouldResemble, []Property{mp(big)})
+		So(pm["Big"][0].Type(), ShouldEqual, PTBytes)
+		So(pm["Big"][1].Type(), ShouldEqual, PTBytes)
+
+		So(pm.Decompress(), ShouldBeNil)
+		So(pm, ShouldResemble, PropertyMap{
+			"$kind": {mpNI(big)},
+			"Big":   {mpNI(big), mpNI([]byte(big))},
+			"Idx":   {mp(big)},
+		})
+
+		pm["Bad"] = []Property{mpNI([]byte(compressedMarker))}
+		So(pm.Decompress(), ShouldErrLike, `(property "Bad")`)
+	})
+}
@@ -14,6 +14,7 @@ type key int
 var (
 	rawDatastoreKey       key
 	rawDatastoreFilterKey key = 1
+	inTransactionKey      key = 2
 )
 
 // RawFactory is the function signature for factory methods compatible with
@@ -68,6 +69,7 @@ func Get(c context.Context) Interface {
 		GetRaw(c),
 		inf.FullyQualifiedAppID(),
 		inf.GetNamespace(),
+		c.Value(inTransactionKey) != nil,
 	}
 }
 
@@ -81,6 +83,7 @@ func GetNoTxn(c context.Context) Interface {
 		GetRawNoTxn(c),
 		inf.FullyQualifiedAppID(),
 		inf.GetNamespace(),
+		false,
 	}
 }
 
@@ -0,0 +1,92 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// converter holds the functions registered with RegisterConverter for a type.
+type converter struct {
+	toProperty   func(interface{}) (Property, error)
+	fromProperty func(Property) (interface{}, error)
+}
+
+var (
+	convertersMutex sync.RWMutex
+	converters      = map[reflect.Type]*converter{}
+)
+
+// RegisterConverter allows values of type t, which the datastore doesn't
+// natively support, to be used as struct fields (including in slices, maps
+// and pointers) and as query filter values, without having to implement
+// PropertyConverter on t. This is useful for types that you don't own, like
+// url.URL or *big.Int.
+//
+// toProperty is called with a value of type t, and must return the Property
+// representing it. Its IndexSetting is honored, so toProperty may return an
+// unindexed Property to keep the values of t out of the indexes (a `noindex`
+// struct tag still applies on top of it). fromProperty is called with
+// a Property loaded from the datastore, and must return a value of type t (or
+// nil, for the zero value).
+//
+// Since struct codecs are cached, RegisterConverter should be called from an
+// init function, before any struct using t is serialized. It panics if t is
+// already registered, or if t is natively supported by Property.
+func RegisterConverter(t reflect.Type, toProperty func(interface{}) (Property, error), fromProperty func(Property) (interface{}, error)) {
+	if t == nil {
+		panic(fmt.Errorf("RegisterConverter: nil type"))
+	}
+	if _, err := PropertyTypeOf(reflect.Zero(t).Interface(), false); err == nil {
+		panic(fmt.Errorf("RegisterConverter: %s is natively supported", t))
+	}
+
+	convertersMutex.Lock()
+	defer convertersMutex.Unlock()
+	if _, ok := converters[t]; ok {
+		panic(fmt.Errorf("RegisterConverter: %s is already registered", t))
+	}
+	converters[t] = &converter{toProperty, fromProperty}
+}
+
+// getConverter returns the converter registered for t, or nil if there is
+// none.
+func getConverter(t reflect.Type) *converter {
+	convertersMutex.RLock()
+	defer convertersMutex.RUnlock()
+	return converters[t]
+}
+
+// convertRegistered converts o to a Property if its type has a registered
+// converter. ok is false if it doesn't.
+func convertRegistered(o interface{}) (ret Property, ok bool, err error) {
+	c := getConverter(reflect.TypeOf(o))
+	if c == nil {
+		return Property{}, false, nil
+	}
+	ret, err = c.toProperty(o)
+	return ret, true, err
+}
+
+// load sets the (settable) value v from p. It returns a non-empty reason if p
+// couldn't be converted.
+func (c *converter) load(v reflect.Value, p Property) string {
+	val, err := c.fromProperty(p)
+	if err != nil {
+		return err.Error()
+	}
+	if val == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return ""
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Type() != v.Type() {
+		return fmt.Sprintf("registered converter returned %s, not %s", rv.Type(), v.Type())
+	}
+	v.Set(rv)
+	return ""
+}
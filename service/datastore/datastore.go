@@ -9,6 +9,7 @@ import (
 	"reflect"
 
 	"github.com/luci/luci-go/common/errors"
+	"golang.org/x/net/context"
 )
 
 type datastoreImpl struct {
@@ -16,6 +17,10 @@ type datastoreImpl struct {
 
 	aid string
 	ns  string
+
+	// inTxn is true if this datastore belongs to a transaction started with
+	// RunInTransaction.
+	inTxn bool
 }
 
 var _ Interface = (*datastoreImpl)(nil)
@@ -146,10 +151,13 @@ func (d *datastoreImpl) Run(q *Query, cbIface interface{}) error {
 
 	return d.RawInterface.Run(fq, func(k *Key, pm PropertyMap, gc CursorCB) error {
 		itm := mat.newElem()
-		if err := mat.setPM(itm, pm); err != nil {
+		if err := mat.setPM(itm, schemaFromStored(pm)); err != nil {
 			return err
 		}
 		mat.setKey(itm, k)
+		if err := callAfterLoad(mat.getObj(itm)); err != nil {
+			return err
+		}
 		return cb(itm, gc)
 	})
 }
@@ -194,19 +202,31 @@ func (d *datastoreImpl) GetAll(q *Query, dst interface{}) error {
 	}
 
 	errs := map[int]error{}
+	keys := []*Key(nil)
+	upgraded := []int(nil)
 	i := 0
 	err = d.RawInterface.Run(fq, func(k *Key, pm PropertyMap, _ CursorCB) error {
 		slice.Set(reflect.Append(slice, mat.newElem()))
 		itm := slice.Index(i)
 		mat.setKey(itm, k)
+		pm = schemaFromStored(pm)
 		err := mat.setPM(itm, pm)
+		if err == nil {
+			err = callAfterLoad(mat.getObj(itm))
+		}
 		if err != nil {
 			errs[i] = err
+		} else if d.needsSchemaWriteBack(k, pm, mat.getObj(itm)) {
+			upgraded = append(upgraded, i)
 		}
+		keys = append(keys, k)
 		i++
 		return nil
 	})
 	if err == nil {
+		d.writeBackUpgraded(&mat, slice, keys, upgraded, func(i int, err error) {
+			errs[i] = err
+		})
 		if len(errs) > 0 {
 			me := make(errors.MultiError, slice.Len())
 			for i, e := range errs {
@@ -289,32 +309,103 @@ func (d *datastoreImpl) GetMulti(dst interface{}) error {
 	}
 
 	lme := errors.NewLazyMultiError(len(keys))
+	upgraded := []int(nil)
 	i := 0
 	meta := NewMultiMetaGetter(pms)
 	err = d.RawInterface.GetMulti(keys, meta, func(pm PropertyMap, err error) error {
-		if !lme.Assign(i, err) {
-			lme.Assign(i, mat.setPM(slice.Index(i), pm))
+		slot := slice.Index(i)
+		pm = schemaFromStored(pm)
+		if !lme.Assign(i, err) && !lme.Assign(i, mat.setPM(slot, pm)) &&
+			!lme.Assign(i, callAfterLoad(mat.getObj(slot))) {
+			if d.needsSchemaWriteBack(keys[i], pm, mat.getObj(slot)) {
+				upgraded = append(upgraded, i)
+			}
 		}
 		i++
 		return nil
 	})
 
 	if err == nil {
+		d.writeBackUpgraded(&mat, slice, keys, upgraded, func(i int, err error) {
+			lme.Assign(i, err)
+		})
 		err = lme.Get()
 	}
 	return err
 }
 
+// needsSchemaWriteBack returns true iff obj, which was loaded from pm (the
+// entity at key k), was upgraded to a newer schema version, and its kind has
+// schema write back enabled. Entities are never written back from inside of
+// a transaction, since that would turn reads into writes behind the user's
+// back.
+func (d *datastoreImpl) needsSchemaWriteBack(k *Key, pm PropertyMap, obj interface{}) bool {
+	if d.inTxn || !schemaWriteBack(k.Kind()) {
+		return false
+	}
+	v, _ := getMGS(obj).GetMeta("schema")
+	to, ok := v.(int64)
+	return ok && to > SchemaVersion(pm)
+}
+
+// writeBackUpgraded puts the items of slice at idxs (whose keys are at the
+// same indexes in keys) back to the datastore, after they were upgraded to a
+// newer schema version. Any errors are reported via assign.
+//
+// This isn't a user-initiated Put, so BeforeSave hooks aren't called.
+func (d *datastoreImpl) writeBackUpgraded(mat *multiArgType, slice reflect.Value, keys []*Key, idxs []int, assign func(int, error)) {
+	wbIdxs := make([]int, 0, len(idxs))
+	wbKeys := make([]*Key, 0, len(idxs))
+	wbVals := make([]PropertyMap, 0, len(idxs))
+	for _, i := range idxs {
+		pm, err := mat.getPM(slice.Index(i))
+		if err != nil {
+			assign(i, err)
+			continue
+		}
+		wbIdxs = append(wbIdxs, i)
+		wbKeys = append(wbKeys, keys[i])
+		wbVals = append(wbVals, schemaToStored(pm))
+	}
+	if len(wbKeys) == 0 {
+		return
+	}
+
+	j := 0
+	err := d.RawInterface.PutMulti(wbKeys, wbVals, func(_ *Key, err error) error {
+		if err != nil {
+			assign(wbIdxs[j], err)
+		}
+		j++
+		return nil
+	})
+	if err != nil {
+		for _, i := range wbIdxs {
+			assign(i, err)
+		}
+	}
+}
+
 func (d *datastoreImpl) PutMulti(src interface{}) error {
 	slice := reflect.ValueOf(src)
 	mat := parseMultiArg(slice.Type())
 
+	lme := errors.NewLazyMultiError(slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		lme.Assign(i, callBeforeSave(mat.getObj(slice.Index(i))))
+	}
+	if err := lme.Get(); err != nil {
+		return err
+	}
+
 	keys, vals, err := mat.GetKeysPMs(d.aid, d.ns, slice, false)
 	if err != nil {
 		return err
 	}
+	for i, pm := range vals {
+		vals[i] = schemaToStored(pm)
+	}
 
-	lme := errors.NewLazyMultiError(len(keys))
 	i := 0
 	err = d.RawInterface.PutMulti(keys, vals, func(key *Key, err error) error {
 		if !lme.Assign(i, err) && key != keys[i] {
@@ -332,6 +423,15 @@ func (d *datastoreImpl) PutMulti(src interface{}) error {
 
 func (d *datastoreImpl) DeleteMulti(keys []*Key) (err error) {
 	lme := errors.NewLazyMultiError(len(keys))
+	for i, k := range keys {
+		if bd := getBeforeDeleter(k); bd != nil {
+			lme.Assign(i, bd.BeforeDelete(k))
+		}
+	}
+	if err = lme.Get(); err != nil {
+		return
+	}
+
 	i := 0
 	extErr := d.RawInterface.DeleteMulti(keys, func(internalErr error) error {
 		lme.Assign(i, internalErr)
@@ -345,6 +445,12 @@ func (d *datastoreImpl) DeleteMulti(keys []*Key) (err error) {
 	return
 }
 
+func (d *datastoreImpl) RunInTransaction(f func(c context.Context) error, opts *TransactionOptions) error {
+	return d.RawInterface.RunInTransaction(func(c context.Context) error {
+		return f(context.WithValue(c, inTransactionKey, true))
+	}, opts)
+}
+
 func (d *datastoreImpl) Raw() RawInterface {
 	return d.RawInterface
 }
@@ -8,6 +8,8 @@ package datastore
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/luci/gae/service/info"
@@ -405,6 +407,22 @@ func TestPut(t *testing.T) {
 				So(ds.PutMulti(fplss), ShouldResemble, errors.MultiError{nil, errors.New("PutMulti fail")})
 			})
 
+			Convey("validation failures are reported per item", func() {
+				type Validated struct {
+					ID    int64 `gae:"$id"`
+					Value int64 `gae:",max=10"`
+				}
+				vs := []Validated{{Value: 0}, {Value: 11}}
+				err := ds.PutMulti(vs)
+				So(err, ShouldHaveSameTypeAs, errors.MultiError(nil))
+				So(err.(errors.MultiError)[0], ShouldBeNil)
+				So(err.(errors.MultiError)[1], ShouldResemble, ErrValidation{{
+					StructType: reflect.TypeOf(Validated{}),
+					FieldName:  "Value",
+					Reason:     "value 11 is greater than max 10",
+				}})
+			})
+
 			Convey("put with non-modifyable type is an error", func() {
 				cs := CommonStruct{}
 				So(func() { ds.Put(cs) }, ShouldPanicLike,
@@ -1003,6 +1021,24 @@ type fixedDataDatastore struct {
 	RawInterface
 
 	data map[string]PropertyMap
+	keys map[string]*Key
+}
+
+// Run returns every entity of the query's kind, ignoring the rest of the query.
+func (d *fixedDataDatastore) Run(fq *FinalizedQuery, cb RawRunCB) error {
+	names := make([]string, 0, len(d.keys))
+	for name, k := range d.keys {
+		if k.Kind() == fq.Kind() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := cb(d.keys[name], d.data[name], nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (d *fixedDataDatastore) GetMulti(keys []*Key, _ MultiMetaGetter, cb GetMultiCB) error {
@@ -1020,23 +1056,41 @@ func (d *fixedDataDatastore) GetMulti(keys []*Key, _ MultiMetaGetter, cb GetMult
 func (d *fixedDataDatastore) PutMulti(keys []*Key, vals []PropertyMap, cb PutMultiCB) error {
 	if d.data == nil {
 		d.data = make(map[string]PropertyMap, len(keys))
+		d.keys = make(map[string]*Key, len(keys))
 	}
 	for i, k := range keys {
 		if k.Incomplete() {
 			panic("key is incomplete, don't do that.")
 		}
 		d.data[k.String()], _ = vals[i].Save(false)
+		d.keys[k.String()] = k
 		cb(k, nil)
 	}
 	return nil
 }
 
+func init() {
+	RegisterSchemaUpgrade("Versioned", 0, func(pm PropertyMap) (PropertyMap, error) {
+		pm["Name"] = pm["name"]
+		delete(pm, "name")
+		return pm, nil
+	})
+	RegisterSchemaUpgrade("Versioned", 1, func(pm PropertyMap) (PropertyMap, error) {
+		if len(pm["Name"]) != 1 {
+			return nil, errors.New("missing Name")
+		}
+		pm["Greeting"] = []Property{mp("hello " + pm["Name"][0].Value().(string))}
+		return pm, nil
+	})
+	SetSchemaWriteBack("Versioned", true)
+}
+
 func TestSchemaChange(t *testing.T) {
 	t.Parallel()
 
 	Convey("Test changing schemas", t, func() {
 		fds := fixedDataDatastore{}
-		ds := &datastoreImpl{&fds, "", ""}
+		ds := &datastoreImpl{&fds, "", "", false}
 
 		Convey("Can add fields", func() {
 			initial := PropertyMap{
@@ -1190,6 +1244,137 @@ func TestSchemaChange(t *testing.T) {
 			So(i, ShouldResembleV, &IntChange{ID: 10, Val: "100"})
 		})
 
+		Convey("Can upgrade with registered schema upgrades", func() {
+			type Versioned struct {
+				ID     int64 `gae:"$id"`
+				Schema int64 `gae:"$schema,2"`
+
+				Name     string
+				Greeting string
+			}
+
+			Convey("from an old version, and write back", func() {
+				initial := PropertyMap{
+					"$key": {mpNI(ds.MakeKey("Versioned", 10))},
+					"name": {mp("bob")},
+				}
+				So(ds.Put(initial), ShouldBeNil)
+
+				v := &Versioned{ID: 10}
+				So(ds.Get(v), ShouldBeNil)
+				So(v, ShouldResemble, &Versioned{
+					ID: 10, Schema: 2, Name: "bob", Greeting: "hello bob",
+				})
+
+				stored := fds.data[ds.MakeKey("Versioned", 10).String()]
+				So(stored[SchemaProperty], ShouldResemble, []Property{mpNI(2)})
+				So(stored["Greeting"], ShouldResemble, []Property{mp("hello bob")})
+			})
+
+			Convey("but not from inside of a transaction", func() {
+				initial := PropertyMap{
+					"$key": {mpNI(ds.MakeKey("Versioned", 10))},
+					"name": {mp("bob")},
+				}
+				So(ds.Put(initial), ShouldBeNil)
+
+				txnDS := &datastoreImpl{&fds, "", "", true}
+				v := &Versioned{ID: 10}
+				So(txnDS.Get(v), ShouldBeNil)
+				So(v.Greeting, ShouldEqual, "hello bob")
+
+				stored := fds.data[ds.MakeKey("Versioned", 10).String()]
+				So(stored[SchemaProperty], ShouldBeNil)
+				So(stored["Greeting"], ShouldBeNil)
+			})
+
+			Convey("and saves the current version", func() {
+				So(ds.Put(&Versioned{ID: 11, Name: "alice"}), ShouldBeNil)
+				stored := fds.data[ds.MakeKey("Versioned", 11).String()]
+				So(stored[SchemaProperty], ShouldResemble, []Property{mpNI(2)})
+
+				v := &Versioned{ID: 11}
+				So(ds.Get(v), ShouldBeNil)
+				So(v, ShouldResemble, &Versioned{ID: 11, Schema: 2, Name: "alice"})
+
+				Convey("as a meta field", func() {
+					pm := PropertyMap{"$key": {mpNI(ds.MakeKey("Versioned", 11))}}
+					So(ds.Get(pm), ShouldBeNil)
+					So(SchemaVersion(pm), ShouldEqual, 2)
+					So(pm, ShouldNotContainKey, SchemaProperty)
+				})
+
+				Convey("in query results", func() {
+					pms := []PropertyMap{}
+					So(ds.GetAll(NewQuery("Versioned"), &pms), ShouldBeNil)
+					So(len(pms), ShouldEqual, 1)
+					So(SchemaVersion(pms[0]), ShouldEqual, 2)
+					So(pms[0], ShouldNotContainKey, SchemaProperty)
+
+					vs := []*Versioned{}
+					So(ds.GetAll(NewQuery("Versioned"), &vs), ShouldBeNil)
+					So(vs, ShouldResemble, []*Versioned{{ID: 11, Schema: 2, Name: "alice"}})
+				})
+
+				Convey("which structs without a version ignore", func() {
+					type Plain struct {
+						ID   int64  `gae:"$id"`
+						Kind string `gae:"$kind,Versioned"`
+						Name string
+					}
+					p := &Plain{ID: 11}
+					So(ds.Get(p), ShouldBeNil)
+					So(p.Name, ShouldEqual, "alice")
+
+					So(ds.Put(p), ShouldBeNil)
+					stored := fds.data[ds.MakeKey("Versioned", 11).String()]
+					So(stored, ShouldNotContainKey, SchemaProperty)
+				})
+			})
+
+			Convey("upgrade failures are errors", func() {
+				initial := PropertyMap{
+					"$key":    {mpNI(ds.MakeKey("Versioned", 12))},
+					"Name":    {mp("a"), mp("b")},
+					"$schema": {mpNI(1)},
+				}
+				So(ds.Put(initial), ShouldBeNil)
+				So(ds.Get(&Versioned{ID: 12}), ShouldErrLike,
+					`upgrading "Versioned" from schema version 1: missing Name`)
+			})
+
+			Convey("newer versions are errors", func() {
+				initial := PropertyMap{
+					"$key":    {mpNI(ds.MakeKey("Versioned", 13))},
+					"$schema": {mpNI(3)},
+				}
+				So(ds.Put(initial), ShouldBeNil)
+				So(ds.Get(&Versioned{ID: 13}), ShouldErrLike,
+					"schema version 3, which is newer than 2")
+			})
+
+			Convey("missing upgrades are errors", func() {
+				type Unversioned struct {
+					ID int64 `gae:"$id"`
+					_  int64 `gae:"$schema,1"`
+				}
+				initial := PropertyMap{
+					"$key": {mpNI(ds.MakeKey("Unversioned", 10))},
+				}
+				So(ds.Put(initial), ShouldBeNil)
+				So(ds.Get(&Unversioned{ID: 10}), ShouldErrLike,
+					`no schema upgrade registered for "Unversioned" from version 0`)
+			})
+
+			Convey("$schema must be an integer", func() {
+				type BadVersioned struct {
+					Schema string `gae:"$schema,2"`
+				}
+				So(func() { GetPLS(&BadVersioned{}) }, ShouldPanicLike,
+					`meta field "$schema" must be an integer type, not string`)
+			})
+		})
+
 		Convey("Native fields have priority over Extra fields", func() {
 			type Dup struct {
 				ID    int64 `gae:"$id"`
@@ -1291,3 +1476,133 @@ func TestSchemaChange(t *testing.T) {
 		})
 	})
 }
+
+type HookStruct struct {
+	ID    int64 `gae:"$id"`
+	Value int64
+
+	saved  bool
+	loaded bool
+	fail   bool
+}
+
+func (h *HookStruct) BeforeSave() error {
+	if h.fail {
+		return errors.New("BeforeSave fail")
+	}
+	h.saved = true
+	return nil
+}
+
+func (h *HookStruct) AfterLoad() error {
+	if h.Value == 3 {
+		return errors.New("AfterLoad fail")
+	}
+	h.loaded = true
+	return nil
+}
+
+type DeleteHook struct {
+	ID   int64  `gae:"$id"`
+	Kind string `gae:"$kind,DeleteHook"`
+}
+
+func (d *DeleteHook) BeforeDelete(k *Key) error {
+	if d.ID != k.IntID() {
+		return fmt.Errorf("BeforeDelete: got ID %d for key %s", d.ID, k)
+	}
+	if d.ID == 2 {
+		return errors.New("BeforeDelete fail")
+	}
+	return nil
+}
+
+func init() {
+	RegisterBeforeDeleter(&DeleteHook{})
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test lifecycle hooks", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		c = SetRawFactory(c, fakeDatastoreFactory)
+		ds := Get(c)
+
+		Convey("BeforeSave", func() {
+			Convey("is called on Put", func() {
+				hss := []*HookStruct{{Value: 0}, {Value: 1}}
+				So(ds.PutMulti(hss), ShouldBeNil)
+				So(hss[0].saved, ShouldBeTrue)
+				So(hss[1].saved, ShouldBeTrue)
+			})
+
+			Convey("errors prevent the Put", func() {
+				hss := []HookStruct{{}, {fail: true}}
+				So(ds.PutMulti(hss), ShouldResemble,
+					errors.MultiError{nil, errors.New("BeforeSave fail")})
+			})
+
+			Convey("isn't called on Get", func() {
+				hss := []*HookStruct{{ID: 1}, {ID: 2}}
+				So(ds.GetMulti(hss), ShouldBeNil)
+				So(hss[0].saved, ShouldBeFalse)
+				So(hss[1].saved, ShouldBeFalse)
+			})
+		})
+
+		Convey("AfterLoad", func() {
+			Convey("is called on GetMulti", func() {
+				hss := []*HookStruct{{ID: 1}, {ID: 2}, {ID: 3}}
+				So(ds.GetMulti(hss), ShouldResemble,
+					errors.MultiError{nil, nil, errors.New("AfterLoad fail")})
+				So(hss[0].loaded, ShouldBeTrue)
+				So(hss[1].loaded, ShouldBeTrue)
+				So(hss[2].loaded, ShouldBeFalse)
+			})
+
+			Convey("is called on GetAll", func() {
+				output := []HookStruct(nil)
+				So(ds.GetAll(NewQuery("").Limit(3), &output), ShouldBeNil)
+				So(output[0].loaded, ShouldBeTrue)
+
+				output = nil
+				err := ds.GetAll(NewQuery("").Limit(5), &output)
+				So(err.(errors.MultiError)[3], ShouldErrLike, "AfterLoad fail")
+			})
+
+			Convey("is called on Run", func() {
+				i := 0
+				So(ds.Run(NewQuery("").Limit(5), func(hs *HookStruct) {
+					So(hs.loaded, ShouldBeTrue)
+					i++
+				}), ShouldErrLike, "AfterLoad fail")
+				So(i, ShouldEqual, 3)
+			})
+		})
+
+		Convey("BeforeDelete", func() {
+			Convey("is called for registered kinds", func() {
+				keys := []*Key{
+					ds.MakeKey("DeleteHook", 1),
+					ds.MakeKey("Ok", 2),
+				}
+				So(ds.DeleteMulti(keys), ShouldBeNil)
+			})
+
+			Convey("errors prevent the Delete", func() {
+				keys := []*Key{
+					ds.MakeKey("Fail", 1),
+					ds.MakeKey("DeleteHook", 2),
+				}
+				So(ds.DeleteMulti(keys), ShouldResemble,
+					errors.MultiError{nil, errors.New("BeforeDelete fail")})
+			})
+
+			Convey("registering a kind twice panics", func() {
+				So(func() { RegisterBeforeDeleter(&DeleteHook{}) }, ShouldPanicLike,
+					`kind "DeleteHook" already registered`)
+			})
+		})
+	})
+}
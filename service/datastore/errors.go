@@ -37,3 +37,56 @@ func (e *ErrFieldMismatch) Error() string {
 	return fmt.Sprintf("gae: cannot load field %q into a %q: %s",
 		e.FieldName, e.StructType, e.Reason)
 }
+
+// ErrFieldInvalid describes a struct field whose value violates one of the
+// validation options (e.g. `required` or `maxlen=`) in its `gae` struct tag.
+type ErrFieldInvalid struct {
+	StructType reflect.Type
+	FieldName  string
+	Reason     string
+}
+
+func (e *ErrFieldInvalid) Error() string {
+	return fmt.Sprintf("gae: field %q of a %q is invalid: %s",
+		e.FieldName, e.StructType, e.Reason)
+}
+
+// ErrValidation is returned when saving a struct (e.g. by Put or PutMulti)
+// whose fields violate the validation options in their `gae` struct tags. It
+// contains an ErrFieldInvalid for every violation.
+type ErrValidation []*ErrFieldInvalid
+
+func (e ErrValidation) Error() string {
+	switch len(e) {
+	case 0:
+		return "gae: no validation errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d other validation errors)", e[0].Error(), len(e)-1)
+}
+
+// ErrIndexYAML is returned by ParseIndexYAML when an index.yaml file is
+// malformed, or defines an invalid index. Line is the 1-based line number that
+// the problem was found on.
+type ErrIndexYAML struct {
+	Line   int
+	Reason string
+}
+
+func (e *ErrIndexYAML) Error() string {
+	return fmt.Sprintf("datastore: index.yaml line %d: %s", e.Line, e.Reason)
+}
+
+// ErrBadRequest is returned when a request exceeds one of the production
+// datastore's limits (see limits.go). In production these are returned as
+// appengine API errors with the BAD_REQUEST code; ErrBadRequest has the same
+// Error() string, so that code which reports (or inspects) these errors
+// behaves identically under test.
+type ErrBadRequest struct {
+	Detail string
+}
+
+func (e *ErrBadRequest) Error() string {
+	return fmt.Sprintf("API error 1 (datastore_v3: BAD_REQUEST): %s", e.Detail)
+}
@@ -0,0 +1,505 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luci/gae/service/blobstore"
+)
+
+// Fixtures are a human-editable JSON format for entities, for seeding test
+// data and for golden-file assertions. A fixture is a list of entities, each of
+// which is an object with a "$key" and its properties:
+//
+//   [
+//     {
+//       "$key": ["Parent", 1, "Child", "name"],
+//       "Name": "hello",
+//       "Count": 10,
+//       "Ratio": 1.5,
+//       "Tags": ["a", "b"],
+//       "Notes": {"noindex": "not indexed"},
+//       "When": {"time": "2015-01-01T00:00:00Z"}
+//     }
+//   ]
+//
+// Keys are written like the arguments to Interface.MakeKey: just the path of
+// kind, id pairs, without an app or namespace. Unlike Key.String or the GQL
+// key format, this keeps fixtures portable, but it means that a fixture only
+// ever describes a single namespace. Parsed keys are always in the app and
+// namespace that the fixture is loaded into, and the app and namespace of
+// written keys are dropped. Written keys must be complete, since an
+// incomplete key would get a new id every time the fixture is loaded.
+//
+// JSON strings, booleans and null are PTString, PTBool and PTNull values.
+// Numbers are PTInt values, unless they contain a ".", "e" or "E", in which
+// case they're PTFloat values. Other types are written as an object with
+// a single field naming the type:
+//   {"time": "2015-01-01T00:00:00.5Z"}  (PTTime, RFC 3339)
+//   {"key": ["Kind", 1]}                (PTKey)
+//   {"geopoint": [1.5, -2.5]}           (PTGeoPoint, lat and lng)
+//   {"bytes": "aGVsbG8="}               (PTBytes, base64)
+//   {"blobkey": "some key"}             (PTBlobKey)
+//   {"entity": {"$key": [...], ...}}    (PTPropertyMap, "$key" is optional)
+//
+// A list is a multi-valued property. Wrapping a value, or a list of values, in
+// {"noindex": ...} makes it NoIndex.
+
+// ParseFixture parses the fixture in r, returning its entities with their
+// keys in the "$key" meta field. The keys are in the aid app and ns namespace.
+func ParseFixture(r io.Reader, aid, ns string) ([]PropertyMap, error) {
+	return parseFixture(r, func(kind, stringID string, intID int64, parent *Key) *Key {
+		return NewKey(aid, ns, kind, stringID, intID, parent)
+	})
+}
+
+// LoadFixture parses the fixture in r and puts all of its entities into ds.
+func LoadFixture(ds Interface, r io.Reader) error {
+	pms, err := parseFixture(r, ds.NewKey)
+	if err != nil {
+		return err
+	}
+	return ds.PutMulti(pms)
+}
+
+// WriteFixture writes pms to w as a fixture. Every entity must have a complete
+// "$key" meta field. The app and namespace of keys aren't written, so pms
+// should all be from the same namespace. The output is deterministic: entities
+// are ordered by key, and properties by name.
+func WriteFixture(w io.Writer, pms []PropertyMap) error {
+	ents := make(fixtureEntities, len(pms))
+	for i, pm := range pms {
+		k, ok := pm.GetMeta("key")
+		if !ok {
+			return fmt.Errorf("datastore: fixture entity %d has no $key", i)
+		}
+		ents[i] = fixtureEntity{k.(*Key), pm}
+	}
+	sort.Stable(ents)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("[")
+	for i, e := range ents {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {\n    \"$key\": ")
+		if err := writeFixtureKeyPath(buf, e.key); err != nil {
+			return fmt.Errorf("datastore: fixture entity %s: $key: %s", e.key, err)
+		}
+
+		for _, name := range fixturePropertyNames(e.pm) {
+			buf.WriteString(",\n    ")
+			writeFixtureString(buf, name)
+			buf.WriteString(": ")
+			if err := writeFixtureValues(buf, e.pm[name]); err != nil {
+				return fmt.Errorf("datastore: fixture entity %s: property %q: %s", e.key, name, err)
+			}
+		}
+		buf.WriteString("\n  }")
+	}
+	if len(ents) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+type fixtureEntity struct {
+	key *Key
+	pm  PropertyMap
+}
+
+type fixtureEntities []fixtureEntity
+
+func (s fixtureEntities) Len() int           { return len(s) }
+func (s fixtureEntities) Less(i, j int) bool { return s[i].key.Less(s[j].key) }
+func (s fixtureEntities) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+////////////////////////////////// parsing ////////////////////////////////////
+
+type fixtureNewKey func(kind, stringID string, intID int64, parent *Key) *Key
+
+func parseFixture(r io.Reader, newKey fixtureNewKey) ([]PropertyMap, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	raw := []map[string]interface{}{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("datastore: bad fixture: %s", err)
+	}
+
+	ret := make([]PropertyMap, len(raw))
+	for i, obj := range raw {
+		pm, err := parseFixtureEntity(obj, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: fixture entity %d: %s", i, err)
+		}
+		if _, ok := pm.GetMeta("key"); !ok {
+			return nil, fmt.Errorf("datastore: fixture entity %d has no $key", i)
+		}
+		ret[i] = pm
+	}
+	return ret, nil
+}
+
+func parseFixtureEntity(obj map[string]interface{}, newKey fixtureNewKey) (PropertyMap, error) {
+	ret := make(PropertyMap, len(obj))
+	for name, v := range obj {
+		if name == "$key" {
+			k, err := parseFixtureKeyPath(v, newKey)
+			if err != nil {
+				return nil, fmt.Errorf("$key: %s", err)
+			}
+			ret.SetMeta("key", k)
+			continue
+		}
+		if isMetaKey(name) {
+			return nil, fmt.Errorf("unsupported meta field %q", name)
+		}
+
+		vals, err := parseFixtureValues(v, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %s", name, err)
+		}
+		ret[name] = vals
+	}
+	return ret, nil
+}
+
+func parseFixtureKeyPath(v interface{}, newKey fixtureNewKey) (*Key, error) {
+	path, ok := v.([]interface{})
+	if !ok || len(path) == 0 || len(path)%2 != 0 {
+		return nil, fmt.Errorf("key must be a list of kind, id pairs, got %v", v)
+	}
+
+	ret := (*Key)(nil)
+	for i := 0; i < len(path); i += 2 {
+		kind, ok := path[i].(string)
+		if !ok || kind == "" {
+			return nil, fmt.Errorf("bad kind in key: %v", path[i])
+		}
+		switch id := path[i+1].(type) {
+		case string:
+			ret = newKey(kind, id, 0, ret)
+		case json.Number:
+			intID, err := strconv.ParseInt(string(id), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad id in key: %s", id)
+			}
+			ret = newKey(kind, "", intID, ret)
+		default:
+			return nil, fmt.Errorf("bad id in key: %v", id)
+		}
+	}
+	return ret, nil
+}
+
+// parseFixtureValues parses the value of a property, which may be a list.
+func parseFixtureValues(v interface{}, newKey fixtureNewKey) (PropertySlice, error) {
+	if obj, ok := v.(map[string]interface{}); ok && len(obj) == 1 {
+		if inner, ok := obj["noindex"]; ok {
+			if _, isList := inner.([]interface{}); isList {
+				ret, err := parseFixtureValues(inner, newKey)
+				if err != nil {
+					return nil, err
+				}
+				for i := range ret {
+					if err := ret[i].SetValue(ret[i].Value(), NoIndex); err != nil {
+						return nil, err
+					}
+				}
+				return ret, nil
+			}
+		}
+	}
+
+	if list, ok := v.([]interface{}); ok {
+		ret := make(PropertySlice, len(list))
+		for i, itm := range list {
+			if _, isList := itm.([]interface{}); isList {
+				return nil, fmt.Errorf("lists may not be nested")
+			}
+			p, err := parseFixtureValue(itm, newKey)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = p
+		}
+		return ret, nil
+	}
+
+	p, err := parseFixtureValue(v, newKey)
+	if err != nil {
+		return nil, err
+	}
+	return PropertySlice{p}, nil
+}
+
+// parseFixtureValue parses a single value.
+func parseFixtureValue(v interface{}, newKey fixtureNewKey) (ret Property, err error) {
+	val, is := interface{}(nil), ShouldIndex
+
+	switch x := v.(type) {
+	case nil, bool, string:
+		val = x
+
+	case json.Number:
+		if strings.ContainsAny(string(x), ".eE") {
+			val, err = strconv.ParseFloat(string(x), 64)
+		} else {
+			val, err = strconv.ParseInt(string(x), 10, 64)
+		}
+
+	case map[string]interface{}:
+		if len(x) != 1 {
+			return ret, fmt.Errorf("typed values must have exactly one field, got %v", x)
+		}
+		for typ, inner := range x {
+			val, is, err = parseFixtureTypedValue(typ, inner, newKey)
+		}
+
+	default:
+		err = fmt.Errorf("unsupported value %v", v)
+	}
+	if err != nil {
+		return
+	}
+
+	err = ret.SetValue(val, is)
+	return
+}
+
+func parseFixtureTypedValue(typ string, v interface{}, newKey fixtureNewKey) (interface{}, IndexSetting, error) {
+	switch typ {
+	case "noindex":
+		p, err := parseFixtureValue(v, newKey)
+		return p.Value(), NoIndex, err
+
+	case "time":
+		s, ok := v.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("time must be a string, got %v", v)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t.UTC(), ShouldIndex, err
+
+	case "key":
+		k, err := parseFixtureKeyPath(v, newKey)
+		return k, ShouldIndex, err
+
+	case "geopoint":
+		pt, ok := v.([]interface{})
+		if ok && len(pt) == 2 {
+			lat, latOK := pt[0].(json.Number)
+			lng, lngOK := pt[1].(json.Number)
+			if latOK && lngOK {
+				ret := GeoPoint{}
+				var err error
+				if ret.Lat, err = lat.Float64(); err == nil {
+					ret.Lng, err = lng.Float64()
+				}
+				return ret, ShouldIndex, err
+			}
+		}
+		return nil, 0, fmt.Errorf("geopoint must be [lat, lng], got %v", v)
+
+	case "bytes":
+		s, ok := v.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("bytes must be a base64 string, got %v", v)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		return b, ShouldIndex, err
+
+	case "blobkey":
+		s, ok := v.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("blobkey must be a string, got %v", v)
+		}
+		return blobstore.Key(s), ShouldIndex, nil
+
+	case "entity":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, 0, fmt.Errorf("entity must be an object, got %v", v)
+		}
+		pm, err := parseFixtureEntity(obj, newKey)
+		return pm, NoIndex, err
+	}
+	return nil, 0, fmt.Errorf("unknown value type %q", typ)
+}
+
+////////////////////////////////// writing ////////////////////////////////////
+
+func fixturePropertyNames(pm PropertyMap) []string {
+	ret := make([]string, 0, len(pm))
+	for name := range pm {
+		if !isMetaKey(name) {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func writeFixtureString(buf *bytes.Buffer, s string) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(err) // can't happen for strings
+	}
+	buf.Write(data)
+}
+
+// writeFixtureKeyPath writes the path of k, dropping its app and namespace.
+func writeFixtureKeyPath(buf *bytes.Buffer, k *Key) error {
+	_, _, toks := k.Split()
+	for _, t := range toks {
+		if t.Incomplete() {
+			return fmt.Errorf("incomplete key %s", k)
+		}
+	}
+
+	buf.WriteString("[")
+	for i, t := range toks {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		writeFixtureString(buf, t.Kind)
+		buf.WriteString(", ")
+		if t.StringID != "" {
+			writeFixtureString(buf, t.StringID)
+		} else {
+			buf.WriteString(strconv.FormatInt(t.IntID, 10))
+		}
+	}
+	buf.WriteString("]")
+	return nil
+}
+
+func writeFixtureFloat(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("%v can't be written as JSON", f)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0" // so that it's parsed as a float
+	}
+	buf.WriteString(s)
+	return nil
+}
+
+func writeFixtureValues(buf *bytes.Buffer, vals PropertySlice) error {
+	if len(vals) == 1 {
+		return writeFixtureValue(buf, &vals[0], true)
+	}
+
+	allNoIndex := len(vals) > 0
+	for i := range vals {
+		if vals[i].Type() == PTPropertyMap || vals[i].IndexSetting() != NoIndex {
+			allNoIndex = false
+			break
+		}
+	}
+	if allNoIndex {
+		buf.WriteString(`{"noindex": `)
+	}
+	buf.WriteString("[")
+	for i := range vals {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if err := writeFixtureValue(buf, &vals[i], !allNoIndex); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("]")
+	if allNoIndex {
+		buf.WriteString("}")
+	}
+	return nil
+}
+
+// writeFixtureValue writes a single value. If markNoIndex is true and p is
+// NoIndex, it's wrapped in {"noindex": ...}.
+func writeFixtureValue(buf *bytes.Buffer, p *Property, markNoIndex bool) (err error) {
+	if markNoIndex && p.IndexSetting() == NoIndex && p.Type() != PTPropertyMap {
+		buf.WriteString(`{"noindex": `)
+		defer buf.WriteString("}")
+	}
+
+	switch v := p.Value().(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		err = writeFixtureFloat(buf, v)
+	case string:
+		writeFixtureString(buf, v)
+	case time.Time:
+		buf.WriteString(`{"time": `)
+		writeFixtureString(buf, v.UTC().Format(time.RFC3339Nano))
+		buf.WriteString("}")
+	case *Key:
+		buf.WriteString(`{"key": `)
+		err = writeFixtureKeyPath(buf, v)
+		buf.WriteString("}")
+	case GeoPoint:
+		buf.WriteString(`{"geopoint": [`)
+		if err = writeFixtureFloat(buf, v.Lat); err == nil {
+			buf.WriteString(", ")
+			err = writeFixtureFloat(buf, v.Lng)
+		}
+		buf.WriteString("]}")
+	case []byte:
+		buf.WriteString(`{"bytes": `)
+		writeFixtureString(buf, base64.StdEncoding.EncodeToString(v))
+		buf.WriteString("}")
+	case blobstore.Key:
+		buf.WriteString(`{"blobkey": `)
+		writeFixtureString(buf, string(v))
+		buf.WriteString("}")
+	case PropertyMap:
+		buf.WriteString(`{"entity": {`)
+		first := true
+		if k, ok := v.GetMeta("key"); ok {
+			buf.WriteString(`"$key": `)
+			if err = writeFixtureKeyPath(buf, k.(*Key)); err != nil {
+				break
+			}
+			first = false
+		}
+		for _, name := range fixturePropertyNames(v) {
+			if !first {
+				buf.WriteString(", ")
+			}
+			first = false
+			writeFixtureString(buf, name)
+			buf.WriteString(": ")
+			if err = writeFixtureValues(buf, v[name]); err != nil {
+				break
+			}
+		}
+		buf.WriteString("}}")
+	default:
+		err = fmt.Errorf("unsupported value type %T", v)
+	}
+	return
+}
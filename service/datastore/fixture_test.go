@@ -0,0 +1,131 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luci/gae/service/blobstore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testFixture = `[
+  {
+    "$key": ["Kind", 1],
+    "Blob": {"blobkey": "blobby"},
+    "Bytes": {"bytes": "aGVsbG8="},
+    "Embedded": {"entity": {"$key": ["Inner", "id"], "Val": 1}},
+    "Flag": true,
+    "Float": 1.0,
+    "Int": 10,
+    "Notes": {"noindex": "long"},
+    "Nothing": null,
+    "Owner": {"key": ["Parent", "p", "User", 2]},
+    "Tags": ["a", {"noindex": "b"}],
+    "Unindexed": {"noindex": [1, 2]},
+    "When": {"time": "2015-01-01T00:00:00.5Z"},
+    "Where": {"geopoint": [1.5, -2.5]}
+  },
+  {
+    "$key": ["Kind", 1, "Child", "name"]
+  }
+]
+`
+
+func TestFixtures(t *testing.T) {
+	t.Parallel()
+
+	Convey("Fixtures", t, func() {
+		Convey("ParseFixture", func() {
+			pms, err := ParseFixture(strings.NewReader(testFixture), "s~aid", "ns")
+			So(err, ShouldBeNil)
+			So(len(pms), ShouldEqual, 2)
+
+			embedded := PropertyMap{"Val": {mp(1)}}
+			embedded.SetMeta("key", mkKey("Inner", "id"))
+
+			expect := PropertyMap{
+				"Blob":      {mp(blobstore.Key("blobby"))},
+				"Bytes":     {mp([]byte("hello"))},
+				"Embedded":  {mpNI(embedded)},
+				"Flag":      {mp(true)},
+				"Float":     {mp(1.0)},
+				"Int":       {mp(10)},
+				"Nothing":   {mp(nil)},
+				"Notes":     {mpNI("long")},
+				"Owner":     {mp(mkKey("Parent", "p", "User", 2))},
+				"Tags":      {mp("a"), mpNI("b")},
+				"Unindexed": {mpNI(1), mpNI(2)},
+				"When":      {mp(time.Date(2015, 1, 1, 0, 0, 0, 5e8, time.UTC))},
+				"Where":     {mp(GeoPoint{Lat: 1.5, Lng: -2.5})},
+			}
+			expect.SetMeta("key", mkKey("Kind", 1))
+			So(pms[0], ShouldResemble, expect)
+
+			child := PropertyMap{}
+			child.SetMeta("key", mkKey("Kind", 1, "Child", "name"))
+			So(pms[1], ShouldResemble, child)
+		})
+
+		Convey("WriteFixture", func() {
+			pms, err := ParseFixture(strings.NewReader(testFixture), "s~aid", "ns")
+			So(err, ShouldBeNil)
+
+			// out of order
+			pms[0], pms[1] = pms[1], pms[0]
+
+			buf := &bytes.Buffer{}
+			So(WriteFixture(buf, pms), ShouldBeNil)
+			So(buf.String(), ShouldEqual, testFixture)
+
+			Convey("empty", func() {
+				buf := &bytes.Buffer{}
+				So(WriteFixture(buf, nil), ShouldBeNil)
+				So(buf.String(), ShouldEqual, "[]\n")
+			})
+
+			Convey("requires keys", func() {
+				So(WriteFixture(&bytes.Buffer{}, []PropertyMap{{}}), ShouldErrLike, "has no $key")
+			})
+
+			Convey("rejects incomplete keys", func() {
+				pm := PropertyMap{}
+				pm.SetMeta("key", mkKey("Kind", 0))
+				So(WriteFixture(&bytes.Buffer{}, []PropertyMap{pm}), ShouldErrLike, "incomplete key")
+
+				pm = PropertyMap{"Owner": {mp(mkKey("User", 0))}}
+				pm.SetMeta("key", mkKey("Kind", 1))
+				So(WriteFixture(&bytes.Buffer{}, []PropertyMap{pm}), ShouldErrLike, "incomplete key")
+			})
+
+			Convey("drops the app and namespace of keys", func() {
+				pm := PropertyMap{}
+				pm.SetMeta("key", NewKey("other~app", "other", "Kind", "", 1, nil))
+				buf := &bytes.Buffer{}
+				So(WriteFixture(buf, []PropertyMap{pm}), ShouldBeNil)
+				So(buf.String(), ShouldEqual, "[\n  {\n    \"$key\": [\"Kind\", 1]\n  }\n]\n")
+			})
+		})
+
+		Convey("bad fixtures", func() {
+			bad := func(fixture string) error {
+				_, err := ParseFixture(strings.NewReader(fixture), "s~aid", "ns")
+				return err
+			}
+			So(bad(`{}`), ShouldErrLike, "bad fixture")
+			So(bad(`[{"Val": 1}]`), ShouldErrLike, "has no $key")
+			So(bad(`[{"$key": ["Kind"]}]`), ShouldErrLike, "kind, id pairs")
+			So(bad(`[{"$key": ["Kind", 1.5]}]`), ShouldErrLike, "bad id")
+			So(bad(`[{"$key": ["Kind", 1], "$parent": 1}]`), ShouldErrLike, "unsupported meta")
+			So(bad(`[{"$key": ["Kind", 1], "V": {"what": 1}}]`), ShouldErrLike, "unknown value type")
+			So(bad(`[{"$key": ["Kind", 1], "V": [[1]]}]`), ShouldErrLike, "nested")
+			So(bad(`[{"$key": ["Kind", 1], "V": {"geopoint": 1}}]`), ShouldErrLike, "[lat, lng]")
+		})
+	})
+}
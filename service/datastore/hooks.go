@@ -0,0 +1,108 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BeforeSaver may be implemented by a user type (usually a *struct) to be
+// notified immediately before it's written by Put/PutMulti. It's called before
+// the object's key and properties are extracted, so it may be used to fill in
+// derived fields (e.g. a Modified timestamp) or to validate invariants.
+//
+// If BeforeSave returns an error, nothing in the Put/PutMulti batch is written,
+// and the error is reported for that object's slot in the returned MultiError.
+type BeforeSaver interface {
+	BeforeSave() error
+}
+
+// AfterLoader may be implemented by a user type (usually a *struct) to be
+// notified immediately after it's successfully populated from the datastore by
+// Get/GetMulti, GetAll or Run. It may be used to migrate old data or compute
+// non-stored fields.
+//
+// An error returned by AfterLoad is reported the same way that a Load error
+// would be for that object.
+type AfterLoader interface {
+	AfterLoad() error
+}
+
+// BeforeDeleter may be implemented by a user type (usually a *struct) to be
+// notified before an entity of its kind is deleted by Delete/DeleteMulti.
+//
+// Since Delete only takes keys, the type must be registered with
+// RegisterBeforeDeleter to be associated with a kind. For each key of that
+// kind, a new zero-valued object is allocated, has its key metadata set to
+// the key being deleted, and then BeforeDelete is called on it.
+//
+// If BeforeDelete returns an error, nothing in the Delete/DeleteMulti batch is
+// deleted, and the error is reported for that key's slot in the returned
+// MultiError.
+type BeforeDeleter interface {
+	BeforeDelete(*Key) error
+}
+
+var (
+	beforeDeletersMutex sync.RWMutex
+	beforeDeleters      = map[string]reflect.Type{}
+)
+
+// RegisterBeforeDeleter associates the type of obj with the kind of obj (as
+// reported by its "kind" metadata), so that BeforeDelete will be called on
+// a new instance of that type for every key of that kind which is deleted.
+//
+// obj must be a non-nil pointer (e.g. `&MyStruct{}`). Registering the same
+// kind twice panics.
+func RegisterBeforeDeleter(obj BeforeDeleter) {
+	t := reflect.TypeOf(obj)
+	if t == nil || t.Kind() != reflect.Ptr {
+		panic(fmt.Errorf("RegisterBeforeDeleter: %T is not a pointer", obj))
+	}
+	kind, _ := GetMetaDefault(getMGS(obj), "kind", "").(string)
+	if kind == "" {
+		panic(fmt.Errorf("RegisterBeforeDeleter: unable to extract $kind from %T", obj))
+	}
+
+	beforeDeletersMutex.Lock()
+	defer beforeDeletersMutex.Unlock()
+	if old, ok := beforeDeleters[kind]; ok {
+		panic(fmt.Errorf("RegisterBeforeDeleter: kind %q already registered to %s", kind, old))
+	}
+	beforeDeleters[kind] = t.Elem()
+}
+
+// getBeforeDeleter returns a new BeforeDeleter, populated with k, for the type
+// registered for k's kind, or nil if there is none.
+func getBeforeDeleter(k *Key) BeforeDeleter {
+	beforeDeletersMutex.RLock()
+	t, ok := beforeDeleters[k.Kind()]
+	beforeDeletersMutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	obj := reflect.New(t).Interface()
+	setKey(obj, k)
+	return obj.(BeforeDeleter)
+}
+
+// callBeforeSave invokes obj's BeforeSave hook, if it has one.
+func callBeforeSave(obj interface{}) error {
+	if bs, ok := obj.(BeforeSaver); ok {
+		return bs.BeforeSave()
+	}
+	return nil
+}
+
+// callAfterLoad invokes obj's AfterLoad hook, if it has one.
+func callAfterLoad(obj interface{}) error {
+	if al, ok := obj.(AfterLoader); ok {
+		return al.AfterLoad()
+	}
+	return nil
+}
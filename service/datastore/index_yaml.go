@@ -0,0 +1,358 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a single significant line of an index.yaml file, in the form
+// `[- ]key: value`.
+type yamlLine struct {
+	num int
+	// indent is the column of the '-' for list items, or of the key otherwise.
+	indent int
+	// keyCol is the column of the key.
+	keyCol int
+	dash   bool
+	key    string
+	val    string
+}
+
+// ParseIndexYAML parses the contents of an index.yaml file (as deployed with
+// `appcfg.py update_indexes`) into the list of composite indexes it defines,
+// in file order. For example:
+//
+//   indexes:
+//
+//   - kind: Cat
+//     ancestor: no
+//     properties:
+//     - name: name
+//     - name: age
+//       direction: desc
+//
+// Only the subset of YAML which is used by index.yaml files is supported. If the
+// file is malformed, or one of its indexes is invalid (e.g. it's missing its
+// kind, or is a built-in single-property index), an *ErrIndexYAML is returned.
+func ParseIndexYAML(r io.Reader) ([]*IndexDefinition, error) {
+	lines, err := lexIndexYAML(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	bad := func(l *yamlLine, format string, args ...interface{}) error {
+		return &ErrIndexYAML{l.num, fmt.Sprintf(format, args...)}
+	}
+
+	l := lines[0]
+	if l.dash || l.indent != 0 || l.key != "indexes" {
+		return nil, bad(l, `expected "indexes:"`)
+	}
+	switch l.val {
+	case "":
+	case "[]":
+		if len(lines) > 1 {
+			return nil, bad(lines[1], "unexpected content after empty index list")
+		}
+		return nil, nil
+	default:
+		return nil, bad(l, "unexpected value for indexes: %q", l.val)
+	}
+
+	ret := []*IndexDefinition(nil)
+	listIndent := -1
+	for i := 1; i < len(lines); {
+		start := lines[i]
+		if !start.dash || (listIndent != -1 && start.indent != listIndent) {
+			return nil, bad(start, `expected an index definition ("- kind: ...")`)
+		}
+		listIndent = start.indent
+
+		id := &IndexDefinition{}
+		seen := map[string]bool{}
+		inProps := false
+		var prop *IndexColumn
+		propKeyCol := 0
+		propSeen := map[string]bool{}
+
+		for ; i < len(lines); i++ {
+			l := lines[i]
+			if l != start && l.dash && l.indent == listIndent {
+				break
+			}
+
+			if l == start || (!l.dash && l.indent == start.keyCol) {
+				// A key of the index itself.
+				prop = nil
+				inProps = false
+				if seen[l.key] {
+					return nil, bad(l, "duplicate key %q", l.key)
+				}
+				seen[l.key] = true
+				switch l.key {
+				case "kind":
+					if l.val == "" {
+						return nil, bad(l, "kind must not be empty")
+					}
+					id.Kind = l.val
+				case "ancestor":
+					switch strings.ToLower(l.val) {
+					case "yes", "true":
+						id.Ancestor = true
+					case "no", "false":
+					default:
+						return nil, bad(l, "ancestor must be yes or no, not %q", l.val)
+					}
+				case "properties":
+					switch l.val {
+					case "":
+						inProps = true
+					case "[]":
+					default:
+						return nil, bad(l, "unexpected value for properties: %q", l.val)
+					}
+				default:
+					return nil, bad(l, "unknown index key %q", l.key)
+				}
+				continue
+			}
+
+			switch {
+			case inProps && l.dash && l.indent >= start.keyCol:
+				// A new property.
+				if prop != nil && prop.Property == "" {
+					return nil, bad(l, "previous property has no name")
+				}
+				id.SortBy = append(id.SortBy, IndexColumn{})
+				prop = &id.SortBy[len(id.SortBy)-1]
+				propKeyCol = l.keyCol
+				propSeen = map[string]bool{}
+			case prop != nil && !l.dash && l.indent == propKeyCol:
+				// Another key of the current property.
+			default:
+				return nil, bad(l, "unexpected indentation")
+			}
+
+			if propSeen[l.key] {
+				return nil, bad(l, "duplicate key %q", l.key)
+			}
+			propSeen[l.key] = true
+			switch l.key {
+			case "name":
+				if l.val == "" {
+					return nil, bad(l, "property name must not be empty")
+				}
+				prop.Property = l.val
+			case "direction":
+				switch strings.ToLower(l.val) {
+				case "asc":
+				case "desc":
+					prop.Descending = true
+				default:
+					return nil, bad(l, "direction must be asc or desc, not %q", l.val)
+				}
+			default:
+				return nil, bad(l, "unknown property key %q", l.key)
+			}
+		}
+
+		if prop != nil && prop.Property == "" {
+			return nil, bad(start, "index has a property with no name")
+		}
+		if id.Kind == "" {
+			return nil, bad(start, "index has no kind")
+		}
+		if !id.Compound() {
+			return nil, bad(start, "%s is not a composite index", id)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// ParseIndexYAMLString is a convenience for calling ParseIndexYAML on a string.
+func ParseIndexYAMLString(s string) ([]*IndexDefinition, error) {
+	return ParseIndexYAML(strings.NewReader(s))
+}
+
+// lexIndexYAML splits r into its significant lines, skipping blank lines and
+// comments.
+func lexIndexYAML(r io.Reader) ([]*yamlLine, error) {
+	ret := []*yamlLine(nil)
+	scn := bufio.NewScanner(r)
+	for num := 1; scn.Scan(); num++ {
+		raw := scn.Text()
+		if num == 1 {
+			raw = strings.TrimPrefix(raw, "\ufeff")
+		}
+		line := stripYAMLComment(raw)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		bad := func(format string, args ...interface{}) error {
+			return &ErrIndexYAML{num, fmt.Sprintf(format, args...)}
+		}
+
+		l := &yamlLine{num: num}
+		rest := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(rest, "\t") {
+			return nil, bad("tabs may not be used for indentation")
+		}
+		l.indent = len(line) - len(rest)
+		l.keyCol = l.indent
+		if rest == "-" || strings.HasPrefix(rest, "- ") {
+			l.dash = true
+			after := strings.TrimLeft(rest[1:], " ")
+			l.keyCol += len(rest) - len(after)
+			rest = after
+			if rest == "" {
+				return nil, bad("list items must be on the same line as their first key")
+			}
+		}
+
+		colon := strings.Index(rest, ":")
+		if colon == -1 || (colon+1 < len(rest) && rest[colon+1] != ' ') {
+			return nil, bad(`expected "key: value", got %q`, strings.TrimSpace(raw))
+		}
+		l.key = strings.TrimSpace(rest[:colon])
+		val, err := unquoteYAML(strings.TrimSpace(rest[colon+1:]))
+		if err != nil {
+			return nil, bad("bad value: %s", err)
+		}
+		l.val = val
+		ret = append(ret, l)
+	}
+	if err := scn.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// stripYAMLComment removes a trailing `# comment` from line, unless the '#'
+// is inside of a quoted string.
+func stripYAMLComment(line string) string {
+	quote := rune(0)
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquoteYAML removes the quotes from a quoted scalar value.
+func unquoteYAML(v string) (string, error) {
+	if len(v) < 2 {
+		return v, nil
+	}
+	switch {
+	case v[0] == '"' && v[len(v)-1] == '"':
+		return strconv.Unquote(v)
+	case v[0] == '\'' && v[len(v)-1] == '\'':
+		return strings.Replace(v[1:len(v)-1], "''", "'", -1), nil
+	}
+	return v, nil
+}
+
+// MergeIndexYAML adds the indexes in idxs which aren't already defined by the
+// index.yaml file contents in existing (which may be empty) to the end of it.
+// Existing content, including comments, is preserved.
+//
+// diff contains the YAML for just the added indexes, with every line prefixed
+// by "+ ". It's empty iff existing already defines all of idxs, which makes it
+// useful for failing tests (e.g. on CI) when code needs an undeclared index.
+//
+// Indexes in idxs which aren't Compound() are ignored.
+func MergeIndexYAML(existing string, idxs []*IndexDefinition) (merged, diff string, err error) {
+	lines, err := lexIndexYAML(strings.NewReader(existing))
+	if err != nil {
+		return "", "", err
+	}
+	have, err := ParseIndexYAMLString(existing)
+	if err != nil {
+		return "", "", err
+	}
+
+	mergedBuf, diffBuf := bytes.Buffer{}, bytes.Buffer{}
+	switch {
+	case len(lines) == 0:
+		mergedBuf.WriteString(existing)
+		if existing != "" && !strings.HasSuffix(existing, "\n") {
+			mergedBuf.WriteString("\n")
+		}
+		mergedBuf.WriteString("indexes:")
+	case lines[0].val == "[]":
+		// Replace `indexes: []` with `indexes:`, so that we can add to it.
+		rawLines := strings.SplitAfter(existing, "\n")
+		l := rawLines[lines[0].num-1]
+		i := strings.Index(l, "[]")
+		rawLines[lines[0].num-1] = strings.TrimRight(l[:i], " ") + l[i+2:]
+		mergedBuf.WriteString(strings.Join(rawLines, ""))
+	default:
+		mergedBuf.WriteString(existing)
+	}
+	if !strings.HasSuffix(mergedBuf.String(), "\n") {
+		mergedBuf.WriteString("\n")
+	}
+
+	// The added indexes must be indented like the existing ones, since
+	// ParseIndexYAML requires every list item to have the same indentation.
+	indent := ""
+	if len(lines) > 1 && lines[1].dash {
+		indent = strings.Repeat(" ", lines[1].indent)
+	}
+
+	added := false
+	for _, id := range idxs {
+		if !id.Compound() || containsIndex(have, id) {
+			continue
+		}
+		have = append(have, id)
+
+		yaml, err := id.YAMLString()
+		if err != nil {
+			return "", "", err
+		}
+		if indent != "" {
+			yaml = indent + strings.Replace(yaml, "\n", "\n"+indent, -1)
+		}
+		fmt.Fprintf(&mergedBuf, "\n%s\n", yaml)
+		for _, l := range strings.Split(yaml, "\n") {
+			fmt.Fprintf(&diffBuf, "+ %s\n", l)
+		}
+		added = true
+	}
+	if !added {
+		return existing, "", nil
+	}
+	return mergedBuf.String(), diffBuf.String(), nil
+}
+
+// containsIndex returns true iff idxs has an index which is equivalent to id.
+func containsIndex(idxs []*IndexDefinition, id *IndexDefinition) bool {
+	id = id.Normalize()
+	for _, i := range idxs {
+		if i.Normalize().Equal(id) {
+			return true
+		}
+	}
+	return false
+}
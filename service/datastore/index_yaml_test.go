@@ -0,0 +1,176 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseIndexYAML(t *testing.T) {
+	t.Parallel()
+
+	Convey("ParseIndexYAML", t, func() {
+		parse := func(lines ...string) ([]*IndexDefinition, error) {
+			return ParseIndexYAMLString(strings.Join(lines, "\n"))
+		}
+
+		Convey("parses a full file", func() {
+			idxs, err := parse(
+				"# AUTOGENERATED",
+				"",
+				"indexes:",
+				"",
+				"- kind: Cat",
+				"  ancestor: no",
+				"  properties:",
+				"  - name: name",
+				"  - name: age",
+				"    direction: desc",
+				"",
+				"- kind: \"Dog\"  # a comment",
+				"  ancestor: yes",
+				"  properties:",
+				"    - name: 'owner'",
+				"      direction: asc",
+				"",
+				"- kind: Bird",
+				"  ancestor: yes",
+			)
+			So(err, ShouldBeNil)
+			So(idxs, ShouldResemble, []*IndexDefinition{
+				{Kind: "Cat", SortBy: []IndexColumn{
+					{Property: "name"},
+					{Property: "age", Descending: true},
+				}},
+				{Kind: "Dog", Ancestor: true, SortBy: []IndexColumn{{Property: "owner"}}},
+				{Kind: "Bird", Ancestor: true},
+			})
+		})
+
+		Convey("round-trips YAMLString", func() {
+			id := &IndexDefinition{Kind: "Kind", Ancestor: true, SortBy: []IndexColumn{
+				{Property: "prop"},
+				{Property: "other", Descending: true},
+			}}
+			yaml, err := id.YAMLString()
+			So(err, ShouldBeNil)
+			idxs, err := ParseIndexYAMLString("indexes:\n" + yaml)
+			So(err, ShouldBeNil)
+			So(idxs, ShouldResemble, []*IndexDefinition{id})
+		})
+
+		Convey("empty files", func() {
+			for _, src := range []string{"", "# nothing\n\n", "indexes:", "indexes: []"} {
+				idxs, err := ParseIndexYAMLString(src)
+				So(err, ShouldBeNil)
+				So(idxs, ShouldBeNil)
+			}
+		})
+
+		Convey("errors have line numbers", func() {
+			errCase := func(line int, reason string, lines ...string) {
+				_, err := parse(lines...)
+				So(err, ShouldResemble, &ErrIndexYAML{line, reason})
+			}
+
+			errCase(1, `expected "indexes:"`, "- kind: Cat")
+			errCase(3, `expected an index definition ("- kind: ...")`,
+				"indexes:", "", "kind: Cat")
+			errCase(4, "B:Cat/name is not a composite index",
+				"indexes:", "- kind: Cat", "  ancestor: yes", "- kind: Cat",
+				"  properties:", "  - name: name")
+			errCase(2, "index has no kind",
+				"indexes:", "- ancestor: yes", "  properties:", "  - name: a", "  - name: b")
+			errCase(3, `ancestor must be yes or no, not "maybe"`,
+				"indexes:", "- kind: Cat", "  ancestor: maybe")
+			errCase(5, `direction must be asc or desc, not "up"`,
+				"indexes:", "- kind: Cat", "  properties:", "  - name: a", "    direction: up")
+			errCase(3, `unknown index key "kinds"`,
+				"indexes:", "- kind: Cat", "  kinds: Dog")
+			errCase(3, `duplicate key "kind"`,
+				"indexes:", "- kind: Cat", "  kind: Dog")
+			errCase(4, "unexpected indentation",
+				"indexes:", "- kind: Cat", "  properties:", "      direction: desc")
+			errCase(3, "tabs may not be used for indentation",
+				"indexes:", "- kind: Cat", "\tancestor: yes")
+			errCase(2, `expected "key: value", got "- Cat"`,
+				"indexes:", "- Cat")
+		})
+	})
+}
+
+func TestMergeIndexYAML(t *testing.T) {
+	t.Parallel()
+
+	Convey("MergeIndexYAML", t, func() {
+		cat := &IndexDefinition{Kind: "Cat", SortBy: []IndexColumn{
+			{Property: "name"}, {Property: "age", Descending: true}}}
+		dog := &IndexDefinition{Kind: "Dog", Ancestor: true}
+		builtin := &IndexDefinition{Kind: "Dog", SortBy: []IndexColumn{{Property: "name"}}}
+
+		Convey("adds missing indexes to an existing file", func() {
+			existing := strings.Join([]string{
+				"# comment",
+				"indexes:",
+				"",
+				"- kind: Cat",
+				"  properties:",
+				"  - name: name",
+				"  - name: age",
+				"    direction: desc",
+				"  - name: __key__",
+				"",
+			}, "\n")
+			merged, diff, err := MergeIndexYAML(existing, []*IndexDefinition{cat, dog, builtin, dog})
+			So(err, ShouldBeNil)
+			So(merged, ShouldEqual, existing+"\n- kind: Dog\n  ancestor: yes\n  properties:\n")
+			So(diff, ShouldEqual, "+ - kind: Dog\n+   ancestor: yes\n+   properties:\n")
+
+			idxs, err := ParseIndexYAMLString(merged)
+			So(err, ShouldBeNil)
+			So(len(idxs), ShouldEqual, 2)
+			So(idxs[1], ShouldResemble, dog)
+		})
+
+		Convey("matches the indentation of the existing indexes", func() {
+			existing := "indexes:\n  - kind: Cat\n    properties:\n    - name: name\n    - name: age\n      direction: desc\n"
+			merged, diff, err := MergeIndexYAML(existing, []*IndexDefinition{cat, dog})
+			So(err, ShouldBeNil)
+			So(merged, ShouldEqual, existing+"\n  - kind: Dog\n    ancestor: yes\n    properties:\n")
+			So(diff, ShouldEqual, "+   - kind: Dog\n+     ancestor: yes\n+     properties:\n")
+
+			idxs, err := ParseIndexYAMLString(merged)
+			So(err, ShouldBeNil)
+			So(idxs, ShouldResemble, []*IndexDefinition{cat, dog})
+		})
+
+		Convey("no diff when nothing is missing", func() {
+			existing := "indexes:\n- kind: Dog\n  ancestor: yes\n"
+			merged, diff, err := MergeIndexYAML(existing, []*IndexDefinition{dog})
+			So(err, ShouldBeNil)
+			So(merged, ShouldEqual, existing)
+			So(diff, ShouldEqual, "")
+		})
+
+		Convey("creates a file from scratch", func() {
+			for _, existing := range []string{"", "indexes: []"} {
+				merged, _, err := MergeIndexYAML(existing, []*IndexDefinition{cat})
+				So(err, ShouldBeNil)
+				idxs, err := ParseIndexYAMLString(merged)
+				So(err, ShouldBeNil)
+				So(idxs, ShouldResemble, []*IndexDefinition{cat})
+			}
+		})
+
+		Convey("bad files are errors", func() {
+			_, _, err := MergeIndexYAML("indexes:\n- kind: Cat\n", nil)
+			So(err, ShouldErrLike, "line 2")
+		})
+	})
+}
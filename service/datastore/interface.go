@@ -103,6 +103,9 @@ type Interface interface {
 	//   - P or *P where *P is a concrete type implementing PropertyLoadSaver
 	//   - *Key (implies a keys-only query)
 	//
+	// If TYPE implements AfterLoader, AfterLoad is called on each item before
+	// it's passed to cb.
+	//
 	// If the error is omitted from the signature, this will run until the query
 	// returns all its results, or has an error/times out.
 	//
@@ -132,6 +135,9 @@ type Interface interface {
 	//   - *[]P or *[]*P where *P is a concrete type implementing
 	//     PropertyLoadSaver
 	//   - *[]*Key implies a keys-only query.
+	//
+	// Items which implement AfterLoader have AfterLoad called on them after
+	// they're loaded.
 	GetAll(q *Query, dst interface{}) error
 
 	// Does a Get for this key and returns true iff it exists. Will only return
@@ -174,6 +180,9 @@ type Interface interface {
 	//   - []P or []*P where *P is a concrete type implementing PropertyLoadSaver
 	//   - []I where I is some interface type. Each element of the slice must
 	//     be non-nil, and its underlying type must be either *S or *P.
+	//
+	// Items which implement AfterLoader have AfterLoad called on them after
+	// they're successfully loaded.
 	GetMulti(dst interface{}) error
 
 	// PutMulti writes items to the datastore.
@@ -186,9 +195,15 @@ type Interface interface {
 	//
 	// If items in src resolve to Incomplete keys, PutMulti will write the
 	// resolved keys back to the items in src.
+	//
+	// Items which implement BeforeSaver have BeforeSave called on them before
+	// anything is written. If any of them fail, nothing is written.
 	PutMulti(src interface{}) error
 
 	// DeleteMulti removes items from the datastore.
+	//
+	// BeforeDelete is called for each key whose kind was registered with
+	// RegisterBeforeDeleter. If any of them fail, nothing is deleted.
 	DeleteMulti(keys []*Key) error
 
 	// Testable returns the Testable interface for the implementation, or nil if
@@ -0,0 +1,92 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+)
+
+// These are the limits which the production datastore imposes on requests.
+// See https://cloud.google.com/appengine/docs/go/datastore/#Go_Quotas_and_limits
+//
+// The production datastore enforces these itself, so they're only enforced by
+// testing implementations which opt in to them (e.g. impl/memory's
+// Testable.StrictLimits).
+const (
+	// MaxEntitySize is the maximum size of an entity (its Key and all of its
+	// properties), as estimated by Key.EstimateSize and
+	// PropertyMap.EstimateSize.
+	MaxEntitySize = 1048572
+
+	// MaxIndexedPropertyBytes is the maximum length of an indexed string or
+	// []byte property value.
+	MaxIndexedPropertyBytes = 1500
+
+	// MaxIndexEntries is the maximum number of index entries that a single
+	// entity may have.
+	MaxIndexEntries = 20000
+
+	// MaxGetBatchSize is the maximum number of keys in a single GetMulti.
+	MaxGetBatchSize = 1000
+
+	// MaxPutBatchSize is the maximum number of entities in a single PutMulti.
+	MaxPutBatchSize = 500
+
+	// MaxDeleteBatchSize is the maximum number of keys in a single DeleteMulti.
+	MaxDeleteBatchSize = 500
+
+	// MaxTransactionSize is the maximum total size of the entities written by a
+	// single transaction.
+	MaxTransactionSize = 10 * 1024 * 1024
+
+	// MaxTransactionalTasks is the maximum number of tasks which may be added
+	// to task queues within a single transaction.
+	MaxTransactionalTasks = 5
+)
+
+// CheckEntityLimits returns an *ErrBadRequest if the entity with key k and
+// properties pm would be rejected by the production datastore because it
+// exceeds MaxEntitySize, MaxIndexedPropertyBytes or MaxIndexEntries.
+//
+// The number of index entries is estimated from the built-in indexes only:
+// every indexed value has an ascending and a descending entry. Composite
+// indexes aren't counted.
+func CheckEntityLimits(k *Key, pm PropertyMap) error {
+	if size := k.EstimateSize() + pm.EstimateSize(); size > MaxEntitySize {
+		return &ErrBadRequest{fmt.Sprintf(
+			"entity is too big (%d bytes, the maximum is %d)", size, MaxEntitySize)}
+	}
+
+	entries := 0
+	for name, vals := range pm {
+		if isMetaKey(name) {
+			continue
+		}
+		for i := range vals {
+			p := &vals[i]
+			if p.IndexSetting() == NoIndex {
+				continue
+			}
+			entries += 2
+
+			l := 0
+			switch v := p.Value().(type) {
+			case string:
+				l = len(v)
+			case []byte:
+				l = len(v)
+			}
+			if l > MaxIndexedPropertyBytes {
+				return &ErrBadRequest{fmt.Sprintf(
+					"The value of property %q is longer than %d bytes.", name, MaxIndexedPropertyBytes)}
+			}
+		}
+	}
+	if entries > MaxIndexEntries {
+		return &ErrBadRequest{fmt.Sprintf(
+			"Too many indexed properties (%d index entries, the maximum is %d)", entries, MaxIndexEntries)}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckEntityLimits(t *testing.T) {
+	t.Parallel()
+
+	Convey("CheckEntityLimits", t, func() {
+		k := mkKey("Kind", 1)
+		long := strings.Repeat("x", MaxIndexedPropertyBytes+1)
+
+		Convey("allows normal entities", func() {
+			So(CheckEntityLimits(k, PropertyMap{
+				"Val":  {mp(100), mp("hello")},
+				"Long": {mpNI(long), mpNI([]byte(long))},
+				"$id":  {mpNI(long)},
+			}), ShouldBeNil)
+		})
+
+		Convey("entity size", func() {
+			big := make([]byte, MaxEntitySize)
+			err := CheckEntityLimits(k, PropertyMap{"Big": {mpNI(big)}})
+			So(err, ShouldHaveSameTypeAs, &ErrBadRequest{})
+			So(err, ShouldErrLike, "BAD_REQUEST): entity is too big")
+		})
+
+		Convey("indexed values", func() {
+			So(CheckEntityLimits(k, PropertyMap{"Long": {mp(long)}}),
+				ShouldErrLike, `property "Long" is longer than 1500 bytes`)
+			So(CheckEntityLimits(k, PropertyMap{"Long": {mp([]byte(long))}}),
+				ShouldErrLike, `property "Long" is longer than 1500 bytes`)
+		})
+
+		Convey("index entries", func() {
+			vals := make(PropertySlice, MaxIndexEntries/2)
+			for i := range vals {
+				vals[i] = mp(i)
+			}
+			pm := PropertyMap{"Vals": vals}
+			So(CheckEntityLimits(k, pm), ShouldBeNil)
+
+			pm["Other"] = PropertySlice{mp(1), mpNI(2)}
+			So(CheckEntityLimits(k, pm), ShouldErrLike, "Too many indexed properties")
+		})
+	})
+}
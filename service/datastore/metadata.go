@@ -0,0 +1,82 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+)
+
+// These are the kinds of the datastore's metadata entities, which can be
+// queried like any other kind. See
+// https://cloud.google.com/appengine/docs/go/datastore/metadataqueries
+const (
+	// NamespaceKind entities have keys whose string ID is the name of a
+	// namespace which contains entities. The default namespace has the integer
+	// ID 1 instead.
+	NamespaceKind = "__namespace__"
+
+	// KindKind entities have keys whose string ID is the name of a kind in the
+	// current namespace.
+	KindKind = "__kind__"
+
+	// PropertyKind entities have keys whose string ID is the name of an indexed
+	// property, and whose parent is the KindKind key of the kind that has it.
+	// They have a "property_representation" property, which lists the
+	// representations (e.g. "INT64", "STRING") that the property's values have
+	// been indexed as.
+	PropertyKind = "__property__"
+)
+
+// Namespaces returns the names of all of the namespaces which contain
+// entities, in order. The default namespace is "".
+func Namespaces(ds Interface) ([]string, error) {
+	keys := []*Key{}
+	if err := ds.GetAll(NewQuery(NamespaceKind), &keys); err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(keys))
+	for i, k := range keys {
+		ret[i] = k.StringID()
+	}
+	return ret, nil
+}
+
+// Kinds returns the names of all of the kinds in ds's namespace, in order.
+func Kinds(ds Interface) ([]string, error) {
+	keys := []*Key{}
+	if err := ds.GetAll(NewQuery(KindKind), &keys); err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(keys))
+	for i, k := range keys {
+		ret[i] = k.StringID()
+	}
+	return ret, nil
+}
+
+// KindProperties returns the indexed properties of kind in ds's namespace,
+// mapped to the representations that their values have been indexed as.
+func KindProperties(ds Interface, kind string) (map[string][]string, error) {
+	pms := []PropertyMap{}
+	q := NewQuery(PropertyKind).Ancestor(ds.MakeKey(KindKind, kind))
+	if err := ds.GetAll(q, &pms); err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]string, len(pms))
+	for _, pm := range pms {
+		k, ok := pm.GetMeta("key")
+		if !ok {
+			return nil, fmt.Errorf("datastore: %s entity has no key", PropertyKind)
+		}
+		reps := []string{}
+		for _, p := range pm["property_representation"] {
+			if s, ok := p.Value().(string); ok {
+				reps = append(reps, s)
+			}
+		}
+		ret[k.(*Key).StringID()] = reps
+	}
+	return ret, nil
+}
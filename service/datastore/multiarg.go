@@ -18,6 +18,10 @@ type multiArgType struct {
 	setPM     func(slot reflect.Value, pm PropertyMap) error
 	setKey    func(slot reflect.Value, k *Key)
 	newElem   func() reflect.Value
+
+	// getObj returns the user object in slot, for checking optional interfaces
+	// like BeforeSaver and AfterLoader.
+	getObj func(slot reflect.Value) interface{}
 }
 
 func (mat *multiArgType) GetKeysPMs(aid, ns string, slice reflect.Value, meta bool) ([]*Key, []PropertyMap, error) {
@@ -101,6 +105,9 @@ func multiArgTypePLS(et reflect.Type) multiArgType {
 		setKey: func(slot reflect.Value, k *Key) {
 			setKey(slot.Addr().Interface(), k)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Addr().Interface()
+		},
 	}
 	if et.Kind() == reflect.Map {
 		ret.newElem = func() reflect.Value {
@@ -138,6 +145,9 @@ func multiArgTypePLSPtr(et reflect.Type) multiArgType {
 		setKey: func(slot reflect.Value, k *Key) {
 			setKey(slot.Interface(), k)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Interface()
+		},
 	}
 	if et.Kind() == reflect.Map {
 		ret.newElem = func() reflect.Value {
@@ -179,6 +189,9 @@ func multiArgTypeStruct(et reflect.Type) multiArgType {
 		newElem: func() reflect.Value {
 			return reflect.New(et).Elem()
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Addr().Interface()
+		},
 	}
 }
 
@@ -210,6 +223,9 @@ func multiArgTypeStructPtr(et reflect.Type) multiArgType {
 		newElem: func() reflect.Value {
 			return reflect.New(et)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Interface()
+		},
 	}
 }
 
@@ -231,6 +247,9 @@ func multiArgTypeInterface() multiArgType {
 		setKey: func(slot reflect.Value, k *Key) {
 			setKey(slot.Elem().Interface(), k)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Elem().Interface()
+		},
 	}
 }
 
@@ -23,6 +23,14 @@ import (
 // with multiple slices (e.g.  slices of slices, either directly `[][]type` or
 // indirectly `[]Embedded` where Embedded contains a slice.)
 //
+// In addition to the Property types, fields may be:
+//   - a map with string keys, whose values are supported types (or slices of
+//     them). Each map entry is flattened into a property named "Field.key".
+//     Because the map keys are dynamic, a map behaves like a slice when it's
+//     nested in a slice of structs (i.e. it's not allowed).
+//   - a pointer to a supported type (e.g. *int64, *string, *time.Time). A nil
+//     pointer is saved as PTNull, and a PTNull value loads as a nil pointer.
+//
 // GetPLS supports the following struct tag syntax:
 //   `gae:"fieldName[,noindex]"` -- an alternate fieldname for an exportable
 //      field.  When the struct is serialized or deserialized, fieldName will be
@@ -53,6 +61,13 @@ import (
 //      Only exported fields allow SetMeta, but all fields of appropriate type
 //      allow tagged defaults. See Examples.
 //
+//   `gae:"$schema,N"` -- declares that the struct is at schema version N (an
+//      integer). The version is saved with every entity as the $schema meta
+//      field (see SchemaProperty). When an entity saved with an older version
+//      is loaded, the functions registered with RegisterSchemaUpgrade for the
+//      struct's kind are applied to its properties first. If the field is
+//      exported, it's set to N on load. See also SetSchemaWriteBack.
+//
 //   `gae:"[-],extra"` -- indicates that any extra, unrecognized or mismatched
 //      property types (type in datastore doesn't match your struct's field
 //      type) should be loaded into and saved from this field. The precise type
@@ -93,6 +108,60 @@ import (
 //        // transparently upconvert to the new schema on load.
 //        Convert PropertyMap `gae:"-,extra"
 //
+//   `gae:"[fieldName],entity"` -- indicates that a struct (or slice of
+//      structs) field should be saved as an embedded entity (a single
+//      PTPropertyMap Property per struct) instead of being flattened into
+//      dotted property names. Because nothing is flattened, the embedded
+//      struct may itself contain slices, and may even be recursively defined.
+//      Embedded entities are never indexed.
+//
+//      If the embedded struct has a `gae:"$key"` field, its value is saved as
+//      the embedded entity's key.
+//
+//      Example:
+//        type Address struct {
+//          Lines []string
+//        }
+//        type Person struct {
+//          Addresses []Address `gae:",entity"`
+//        }
+//
+//      A field of type PropertyMap (without the extra option) holds a raw
+//      embedded entity.
+//
+//   `gae:"[fieldName],json"` and `gae:"[fieldName],gob"` -- indicate that the
+//      field should be encoded with encoding/json or encoding/gob respectively,
+//      and stored as a single unindexed []byte property. The field may have any
+//      type that the encoding supports (maps, nested slices, etc.); for gob,
+//      concrete types stored in interface fields must be registered with
+//      gob.Register. These options take precedence over PropertyConverter. A
+//      nil field value is stored as a PTNull.
+//
+//      If a stored value can't be decoded into the field, the failure is
+//      reported as an ErrFieldMismatch (or the property goes to the 'extra'
+//      field, if there is one), and the field is left untouched.
+//
+//   `gae:"[fieldName],compress"` -- indicates that a string or []byte field
+//      (or a slice of them) should be stored unindexed and zlib-compressed
+//      (see CompressProperty). Uncompressed values are still loaded normally,
+//      so this option may be added to existing fields. Values which don't
+//      shrink when compressed are stored as-is.
+//
+//   Validation options may be combined with any of the above (except for
+//   metadata and 'extra' fields), and are checked whenever the struct is
+//   saved (e.g. by Put). If any field is invalid, Save returns an
+//   ErrValidation describing every violation, and nothing is written.
+//     - `required` -- the field must not be the zero value (or, for slices
+//       and maps, must not be empty).
+//     - `min=N` and `max=N` -- every numeric value in the field must be within
+//       the bound.
+//     - `minlen=N` and `maxlen=N` -- the length of a string (in runes), or the
+//       number of elements of a slice or map, must be within the bound.
+//     - `match=RE` -- every string value in the field must match the regular
+//       expression RE. Since RE may contain commas, it must be the last option.
+//      Example:
+//        Email string `gae:"email,required,maxlen=100,match=^[^@]+@[^@]+$"`
+//
 // Example "special" structure. This is supposed to be some sort of datastore
 // singleton object.
 //   struct secretFoo {
@@ -195,6 +264,14 @@ import (
 //   type Person struct {
 //     ID Name `gae:"$id"`
 //   }
+//
+// Fields (or slices, maps or pointers) of types which you don't own, and so
+// can't implement PropertyConverter on, may be supported by registering
+// conversion functions for them with RegisterConverter.
+//
+// Indexed properties derived from other fields (which queries can filter on,
+// but which aren't loaded back into the struct) may be declared with
+// RegisterComputedProperty.
 func GetPLS(obj interface{}) interface {
 	PropertyLoadSaver
 	MetaGetterSetter
@@ -0,0 +1,86 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// blobCodec serializes a struct field of arbitrary type to an unindexed []byte
+// Property. It backs the `gae:",json"` and `gae:",gob"` struct tag options.
+type blobCodec struct {
+	name string
+
+	// encode serializes the value pointed to by ptr.
+	encode func(ptr interface{}) ([]byte, error)
+	// decode deserializes data into the value pointed to by ptr.
+	decode func(data []byte, ptr interface{}) error
+}
+
+var blobCodecs = map[string]*blobCodec{
+	"json": {
+		name:   "json",
+		encode: json.Marshal,
+		decode: json.Unmarshal,
+	},
+	"gob": {
+		name: "gob",
+		encode: func(ptr interface{}) ([]byte, error) {
+			buf := bytes.Buffer{}
+			err := gob.NewEncoder(&buf).Encode(ptr)
+			return buf.Bytes(), err
+		},
+		decode: func(data []byte, ptr interface{}) error {
+			return gob.NewDecoder(bytes.NewReader(data)).Decode(ptr)
+		},
+	},
+}
+
+// isNilValue returns true iff v is of a nillable kind and is nil.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// save encodes the (addressable) field value v. nil values are saved as PTNull
+// so that they don't need to round-trip through the encoding.
+func (b *blobCodec) save(v reflect.Value) (ret Property, err error) {
+	if isNilValue(v) {
+		return MkPropertyNI(nil), nil
+	}
+	data, err := b.encode(v.Addr().Interface())
+	if err != nil {
+		return ret, fmt.Errorf("gae: failed to %s-encode %s: %s", b.name, v.Type(), err)
+	}
+	err = ret.SetValue(data, NoIndex)
+	return
+}
+
+// load decodes p into the (addressable) field value v. It returns a non-empty
+// reason if p couldn't be decoded, in which case v is left untouched.
+func (b *blobCodec) load(v reflect.Value, p Property) string {
+	if p.Type() == PTNull {
+		v.Set(reflect.Zero(v.Type()))
+		return ""
+	}
+
+	pVal, err := p.Project(PTBytes)
+	if err != nil {
+		return typeMismatchReason(p.Value(), v)
+	}
+	dec := reflect.New(v.Type())
+	if err := b.decode(pVal.([]byte), dec.Interface()); err != nil {
+		return fmt.Sprintf("failed to %s-decode %s: %s", b.name, v.Type(), err)
+	}
+	v.Set(dec.Elem())
+	return ""
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ComputeFunc computes the value of a computed property. It's passed a pointer
+// to the struct being saved.
+//
+// It may return nil, any value accepted by Property.SetValue, or a slice
+// (other than []byte) of them, in which case the property has multiple values.
+type ComputeFunc func(obj interface{}) (interface{}, error)
+
+type computedProperty struct {
+	name    string
+	compute ComputeFunc
+}
+
+var (
+	computedPropertiesMutex sync.RWMutex
+	computedProperties      = map[reflect.Type][]computedProperty{}
+)
+
+// RegisterComputedProperty declares that structs of obj's type (obj may be
+// a struct or a pointer to one) have an indexed property called name, whose
+// value is computed by fn whenever the struct is saved. This allows queries to
+// filter on values derived from other fields (e.g. a lowercase copy of a name,
+// or a date bucket) without storing them in redundant struct fields.
+//
+// Computed properties are ignored when the struct is loaded. It's an error to
+// save a struct which has a field with the same name as a computed property.
+//
+// Registering the same name twice for a type panics.
+func RegisterComputedProperty(obj interface{}, name string, fn ComputeFunc) {
+	t := reflect.TypeOf(obj)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("RegisterComputedProperty: %T is not a struct or pointer-to-struct", obj))
+	}
+	if !validPropertyName(name) {
+		panic(fmt.Errorf("RegisterComputedProperty: invalid property name %q", name))
+	}
+
+	computedPropertiesMutex.Lock()
+	defer computedPropertiesMutex.Unlock()
+	for _, cp := range computedProperties[t] {
+		if cp.name == name {
+			panic(fmt.Errorf("RegisterComputedProperty: %s already has computed property %q", t, name))
+		}
+	}
+	computedProperties[t] = append(computedProperties[t], computedProperty{name, fn})
+}
+
+func getComputedProperties(t reflect.Type) []computedProperty {
+	computedPropertiesMutex.RLock()
+	defer computedPropertiesMutex.RUnlock()
+	return computedProperties[t]
+}
+
+// isComputed returns true iff name is one of the computed properties in cps.
+func isComputed(cps []computedProperty, name string) bool {
+	for _, cp := range cps {
+		if cp.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// saveComputed adds the computed properties in cps for p to propMap.
+func (p *structPLS) saveComputed(cps []computedProperty, propMap PropertyMap) error {
+	obj := p.o.Addr().Interface()
+	for _, cp := range cps {
+		if _, ok := propMap[cp.name]; ok {
+			return fmt.Errorf("gae: computed property %q conflicts with a field of %s", cp.name, p.o.Type())
+		}
+		val, err := cp.compute(obj)
+		if err != nil {
+			return fmt.Errorf("gae: computing property %q: %s", cp.name, err)
+		}
+
+		v := reflect.ValueOf(val)
+		if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+			prop := Property{}
+			if err := prop.SetValue(val, ShouldIndex); err != nil {
+				return fmt.Errorf("gae: computed property %q: %s", cp.name, err)
+			}
+			propMap[cp.name] = []Property{prop}
+			continue
+		}
+
+		props := make([]Property, v.Len())
+		for i := range props {
+			if err := props[i].SetValue(v.Index(i).Interface(), ShouldIndex); err != nil {
+				return fmt.Errorf("gae: computed property %q: %s", cp.name, err)
+			}
+		}
+		propMap[cp.name] = props
+	}
+	return nil
+}
@@ -25,10 +25,16 @@ type structTag struct {
 	idxSetting     IndexSetting
 	isSlice        bool
 	substructCodec *structCodec
+	entityCodec    *structCodec
+	blob           *blobCodec
+	compress       bool
+	isMap          bool
+	isPtr          bool
 	convert        bool
 	metaVal        interface{}
 	isExtra        bool
 	canSet         bool
+	validator      *fieldValidator
 }
 
 type structCodec struct {
@@ -36,9 +42,10 @@ type structCodec struct {
 	byName    map[string]int
 	bySpecial map[string]int
 
-	byIndex  []structTag
-	hasSlice bool
-	problem  error
+	byIndex      []structTag
+	hasSlice     bool
+	hasValidator bool
+	problem      error
 }
 
 type structPLS struct {
@@ -56,6 +63,16 @@ func typeMismatchReason(val interface{}, v reflect.Value) string {
 }
 
 func (p *structPLS) Load(propMap PropertyMap) error {
+	if idx, ok := p.c.byMeta["schema"]; ok {
+		to := p.c.byIndex[idx].metaVal.(int64)
+		pm, err := UpgradeSchema(GetMetaDefault(p, "kind", "").(string), propMap, to)
+		if err != nil {
+			return err
+		}
+		propMap = pm
+		p.SetMeta("schema", to)
+	}
+
 	convFailures := errors.MultiError(nil)
 
 	useExtra := false
@@ -68,7 +85,11 @@ func (p *structPLS) Load(propMap PropertyMap) error {
 		}
 	}
 	t := reflect.Type(nil)
+	computed := getComputedProperties(p.o.Type())
 	for name, props := range propMap {
+		if isMetaKey(name) || (computed != nil && isComputed(computed, name)) {
+			continue
+		}
 		multiple := len(props) > 1
 		for i, prop := range props {
 			if reason := loadInner(p.c, p.o, i, name, prop, multiple); reason != "" {
@@ -101,17 +122,44 @@ func (p *structPLS) Load(propMap PropertyMap) error {
 	return nil
 }
 
-func loadInner(codec *structCodec, structValue reflect.Value, index int, name string, p Property, requireSlice bool) string {
+func loadInner(codec *structCodec, structValue reflect.Value, index int, name string, p Property, requireSlice bool) (reason string) {
 	var v reflect.Value
+	var st structTag
 	// Traverse a struct's struct-typed fields.
 	for {
 		fieldIndex, ok := codec.byName[name]
 		if !ok {
-			return "no such struct field"
+			if fieldIndex, ok = codec.byPrefix(name); !ok {
+				return "no such struct field"
+			}
 		}
 		v = structValue.Field(fieldIndex)
 
-		st := codec.byIndex[fieldIndex]
+		st = codec.byIndex[fieldIndex]
+		if st.isMap {
+			if len(name) <= len(st.name) {
+				return "no such struct field"
+			}
+
+			// Load into a copy of the map's current value for this key (so that
+			// multiple values append to it), and store it back on success.
+			mapV := v
+			key := reflect.ValueOf(name[len(st.name)+1:]).Convert(mapV.Type().Key())
+			if mapV.IsNil() {
+				mapV.Set(reflect.MakeMap(mapV.Type()))
+			}
+			v = reflect.New(mapV.Type().Elem()).Elem()
+			if cur := mapV.MapIndex(key); cur.IsValid() {
+				v.Set(cur)
+			}
+			elem := v
+			defer func() {
+				if reason == "" {
+					mapV.SetMapIndex(key, elem)
+				}
+			}()
+			break
+		}
 		if st.substructCodec == nil {
 			break
 		}
@@ -131,6 +179,9 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 	}
 
 	doConversion := func(v reflect.Value) (string, bool) {
+		if c := getConverter(v.Type()); c != nil {
+			return c.load(v, p), true
+		}
 		a := v.Addr()
 		if conv, ok := a.Interface().(PropertyConverter); ok {
 			err := conv.FromProperty(p)
@@ -142,10 +193,28 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 		return "", false
 	}
 
+	if st.blob != nil {
+		if requireSlice {
+			return "multiple-valued property requires a slice field type"
+		}
+		return st.blob.load(v, p)
+	}
+
+	if st.compress {
+		var err error
+		if p, err = DecompressProperty(p); err != nil {
+			return err.Error()
+		}
+	}
+
 	if ret, ok := doConversion(v); ok {
 		return ret
 	}
 
+	if st.entityCodec != nil {
+		return loadEntity(st.entityCodec, v, p, requireSlice)
+	}
+
 	var slice reflect.Value
 	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
 		slice = v
@@ -154,6 +223,19 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 		return "multiple-valued property requires a slice field type"
 	}
 
+	var ptr reflect.Value
+	if st.isPtr {
+		if p.Type() == PTNull {
+			v.Set(reflect.Zero(v.Type()))
+			if slice.IsValid() {
+				slice.Set(reflect.Append(slice, v))
+			}
+			return ""
+		}
+		ptr = v
+		v = reflect.New(v.Type().Elem()).Elem()
+	}
+
 	if ret, ok := doConversion(v); ok {
 		if ret != "" {
 			return ret
@@ -203,6 +285,9 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 			set = func(x interface{}) {
 				v.SetBytes(reflect.ValueOf(x).Bytes())
 			}
+		case reflect.Map:
+			project = PTPropertyMap
+			set = func(x interface{}) { v.Set(reflect.ValueOf(x)) }
 		default:
 			panic(fmt.Errorf("helper: impossible: %s", typeMismatchReason(p.Value(), v)))
 		}
@@ -216,6 +301,46 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 		}
 		set(pVal)
 	}
+	if ptr.IsValid() {
+		ptr.Set(v.Addr())
+		v = ptr
+	}
+	if slice.IsValid() {
+		slice.Set(reflect.Append(slice, v))
+	}
+	return ""
+}
+
+// loadEntity loads the embedded entity held by p into v, which is either
+// a struct or a slice of structs described by codec.
+func loadEntity(codec *structCodec, v reflect.Value, p Property, requireSlice bool) string {
+	if codec.problem != nil {
+		return codec.problem.Error()
+	}
+
+	var slice reflect.Value
+	if v.Kind() == reflect.Slice {
+		slice = v
+		v = reflect.New(v.Type().Elem()).Elem()
+	} else if requireSlice {
+		return "multiple-valued property requires a slice field type"
+	}
+
+	pVal, err := p.Project(PTPropertyMap)
+	if err != nil {
+		return typeMismatchReason(p.Value(), v)
+	}
+	pm := pVal.(PropertyMap)
+
+	sub := &structPLS{v, codec}
+	data, _ := pm.Save(false)
+	if err := sub.Load(data); err != nil {
+		return err.Error()
+	}
+	if k, ok := pm.GetMeta("key"); ok {
+		sub.SetMeta("key", k)
+	}
+
 	if slice.IsValid() {
 		slice.Set(reflect.Append(slice, v))
 	}
@@ -229,9 +354,19 @@ func (p *structPLS) Save(withMeta bool) (PropertyMap, error) {
 	} else {
 		ret = make(PropertyMap, len(p.c.byName))
 	}
+	if p.c.hasValidator {
+		if errs := p.validate("", nil); len(errs) > 0 {
+			return nil, errs
+		}
+	}
 	if _, err := p.save(ret, "", ShouldIndex); err != nil {
 		return nil, err
 	}
+	if computed := getComputedProperties(p.o.Type()); computed != nil {
+		if err := p.saveComputed(computed, ret); err != nil {
+			return nil, err
+		}
+	}
 	return ret, nil
 }
 
@@ -256,11 +391,35 @@ func (p *structPLS) save(propMap PropertyMap, prefix string, is IndexSetting) (i
 		}
 
 		prop := Property{}
-		if st.convert {
+		if st.entityCodec != nil {
+			if st.entityCodec.problem != nil {
+				return st.entityCodec.problem
+			}
+			sub := &structPLS{v, st.entityCodec}
+			pm := PropertyMap(nil)
+			if pm, err = sub.Save(false); err != nil {
+				return err
+			}
+			if k, ok := sub.GetMeta("key"); ok && k != nil {
+				pm["$key"] = []Property{MkPropertyNI(k)}
+			}
+			err = prop.SetValue(pm, NoIndex)
+		} else if st.blob != nil {
+			prop, err = st.blob.save(v)
+		} else if st.convert {
 			prop, err = v.Addr().Interface().(PropertyConverter).ToProperty()
+		} else if st.isPtr {
+			if v.IsNil() {
+				err = prop.SetValue(nil, si)
+			} else {
+				err = prop.SetValue(v.Elem().Interface(), si)
+			}
 		} else {
 			err = prop.SetValue(v.Interface(), si)
 		}
+		if err == nil && st.compress {
+			prop, err = CompressProperty(prop)
+		}
 		if err != nil {
 			return err
 		}
@@ -287,7 +446,13 @@ func (p *structPLS) save(propMap PropertyMap, prefix string, is IndexSetting) (i
 		if st.idxSetting == NoIndex {
 			is1 = NoIndex
 		}
-		if st.isSlice {
+		if st.isMap {
+			for _, k := range v.MapKeys() {
+				if err = saveMapValue(saveProp, name+"."+k.String(), is1, v.MapIndex(k), &st); err != nil {
+					return
+				}
+			}
+		} else if st.isSlice {
 			for j := 0; j < v.Len(); j++ {
 				if err = saveProp(name, is1, v.Index(j), &st); err != nil {
 					return
@@ -313,6 +478,20 @@ func (p *structPLS) save(propMap PropertyMap, prefix string, is IndexSetting) (i
 	return
 }
 
+// saveMapValue saves the value v of a map field under the flattened property
+// name.
+func saveMapValue(saveProp func(string, IndexSetting, reflect.Value, *structTag) error, name string, is IndexSetting, v reflect.Value, st *structTag) error {
+	if !st.isSlice {
+		return saveProp(name, is, v, st)
+	}
+	for j := 0; j < v.Len(); j++ {
+		if err := saveProp(name, is, v.Index(j), st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *structPLS) GetMeta(key string) (interface{}, bool) {
 	if idx, ok := p.c.byMeta[key]; ok {
 		if val, ok := p.getMetaFor(idx); ok {
@@ -402,6 +581,24 @@ func (p *structPLS) SetMeta(key string, val interface{}) bool {
 	return true
 }
 
+// byPrefix finds the field for a property name which isn't directly in byName:
+// either a "Field.key" property of a map field, or a property of a flattened
+// substruct which itself has a map field. It returns the field's index, and
+// true iff one was found.
+func (c *structCodec) byPrefix(name string) (int, bool) {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		if idx, ok := c.byName[name[:i]]; ok {
+			if st := &c.byIndex[idx]; st.isMap || st.substructCodec != nil {
+				return idx, true
+			}
+		}
+	}
+	return 0, false
+}
+
 var (
 	// The RWMutex is chosen intentionally, as the majority of access to the
 	// structCodecs map will be in parallel and will be to read an existing codec.
@@ -480,6 +677,21 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			name, opts = name[:i], name[i+1:]
 		}
 		st.canSet = f.PkgPath == "" // blank == exported
+		if !strings.HasPrefix(name, "$") {
+			rest, fv, err := parseValidation(opts)
+			if err == nil && fv != nil {
+				err = fv.check(ft)
+			}
+			if err != nil {
+				c.problem = me("field %q has bad validation option: %s", f.Name, err)
+				return
+			}
+			opts = rest
+			st.validator = fv
+			c.hasValidator = c.hasValidator || fv != nil
+		}
+		isEntity := opts == "entity"
+		blob := blobCodecs[opts]
 		if opts == "extra" {
 			if _, ok := c.bySpecial["extra"]; ok {
 				c.problem = me("struct has multiple fields tagged as 'extra'")
@@ -501,7 +713,7 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 		st.convert = reflect.PtrTo(ft).Implements(typeOfPropertyConverter)
 		switch {
 		case name == "":
-			if !f.Anonymous {
+			if !f.Anonymous || isEntity || blob != nil {
 				name = f.Name
 			}
 		case name[0] == '$':
@@ -519,6 +731,12 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				}
 				st.metaVal = mv
 			}
+			if name == "schema" {
+				if _, ok := st.metaVal.(int64); !ok {
+					c.problem = me("meta field %q must be an integer type, not %s", "$schema", ft)
+					return
+				}
+			}
 			fallthrough
 		case name == "-":
 			st.name = "-"
@@ -534,8 +752,54 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			continue
 		}
 
+		if blob != nil {
+			// Encoded fields are opaque, so they may have any type at all, and
+			// override any PropertyConverter implementation.
+			st.convert = false
+			st.blob = blob
+			if _, ok := c.byName[name]; ok {
+				c.problem = me("struct tag has repeated property name: %q", name)
+				return
+			}
+			c.byName[name] = i
+			st.name = name
+			st.idxSetting = NoIndex
+			continue
+		}
+
+		if isEntity {
+			et := ft
+			if et.Kind() == reflect.Slice {
+				et = et.Elem()
+				st.isSlice = true
+				c.hasSlice = true
+			}
+			if st.convert || et.Kind() != reflect.Struct || et == typeOfTime || et == typeOfGeoPoint {
+				c.problem = me("entity field %q has invalid type: %s", name, ft)
+				return
+			}
+
+			// Embedded entities don't flatten, so unlike substructs, they may be
+			// recursively defined (e.g. a tree of nodes).
+			sub := getStructCodecLocked(et)
+			if sub.problem != nil && sub.problem != errRecursiveStruct {
+				c.problem = me("field %q has problem: %s", f.Name, sub.problem)
+				return
+			}
+			st.entityCodec = sub
+
+			if _, ok := c.byName[name]; ok {
+				c.problem = me("struct tag has repeated property name: %q", name)
+				return
+			}
+			c.byName[name] = i
+			st.name = name
+			continue
+		}
+
 		substructType := reflect.Type(nil)
-		if !st.convert {
+		registered := getConverter(ft) != nil
+		if !st.convert && !registered {
 			switch ft.Kind() {
 			case reflect.Struct:
 				if ft != typeOfTime && ft != typeOfGeoPoint {
@@ -544,11 +808,24 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			case reflect.Slice:
 				if reflect.PtrTo(ft.Elem()).Implements(typeOfPropertyConverter) {
 					st.convert = true
-				} else if ft.Elem().Kind() == reflect.Struct {
+				} else if ft.Elem().Kind() == reflect.Struct && getConverter(ft.Elem()) == nil {
 					substructType = ft.Elem()
 				}
 				st.isSlice = ft.Elem().Kind() != reflect.Uint8
 				c.hasSlice = c.hasSlice || st.isSlice
+			case reflect.Map:
+				if ft == typeOfPropertyMap {
+					break
+				}
+				if ft.Key().Kind() != reflect.String {
+					c.problem = me("map field %q must have string keys, not %s", f.Name, ft.Key())
+					return
+				}
+				// Maps flatten to "Field.key" properties, so, like a slice, they
+				// may not occur in a slice of flattened structs.
+				st.isMap = true
+				st.isSlice = ft.Elem().Kind() == reflect.Slice && ft.Elem().Elem().Kind() != reflect.Uint8
+				c.hasSlice = true
 			case reflect.Interface:
 				c.problem = me("field %q has non-concrete interface type %s",
 					f.Name, ft)
@@ -567,6 +844,7 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				return
 			}
 			st.substructCodec = sub
+			c.hasValidator = c.hasValidator || sub.hasValidator
 			if st.isSlice && sub.hasSlice {
 				c.problem = me(
 					"flattening nested structs leads to a slice of slices: field %q",
@@ -586,15 +864,24 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				c.byName[absName] = i
 			}
 		} else {
-			if !st.convert { // check the underlying static type of the field
+			if !st.convert && !registered { // check the underlying static type of the field
 				t := ft
+				if st.isMap {
+					t = t.Elem()
+				}
 				if st.isSlice {
 					t = t.Elem()
 				}
-				v := UpconvertUnderlyingType(reflect.New(t).Elem().Interface())
-				if _, err := PropertyTypeOf(v, false); err != nil {
-					c.problem = me("field %q has invalid type: %s", name, ft)
-					return
+				if t.Kind() == reflect.Ptr && t != typeOfKey && getConverter(t) == nil {
+					st.isPtr = true
+					t = t.Elem()
+				}
+				if getConverter(t) == nil {
+					v := UpconvertUnderlyingType(reflect.New(t).Elem().Interface())
+					if _, err := PropertyTypeOf(v, false); err != nil {
+						c.problem = me("field %q has invalid type: %s", name, ft)
+						return
+					}
 				}
 			}
 
@@ -605,7 +892,15 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			c.byName[name] = i
 		}
 		st.name = name
-		if opts == "noindex" {
+		switch opts {
+		case "noindex":
+			st.idxSetting = NoIndex
+		case "compress":
+			if !compressible(ft, st) {
+				c.problem = me("compressed field %q has invalid type: %s", name, ft)
+				return
+			}
+			st.compress = true
 			st.idxSetting = NoIndex
 		}
 	}
@@ -615,6 +910,19 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 	return
 }
 
+// compressible returns true iff the field of type ft, described by st, holds
+// string or []byte values which can be compressed.
+func compressible(ft reflect.Type, st *structTag) bool {
+	if st.convert || st.substructCodec != nil || st.isMap || st.isPtr {
+		return false
+	}
+	if st.isSlice {
+		ft = ft.Elem()
+	}
+	pt, _ := PropertyTypeOf(UpconvertUnderlyingType(reflect.New(ft).Elem().Interface()), false)
+	return pt == PTString || pt == PTBytes
+}
+
 func convertMeta(val string, t reflect.Type) (interface{}, error) {
 	switch t.Kind() {
 	case reflect.String:
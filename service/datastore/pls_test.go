@@ -8,9 +8,12 @@ package datastore
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/big"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -309,6 +312,222 @@ type Recursive struct {
 	R []Recursive
 }
 
+type EntityInner struct {
+	K    *Key `gae:"$key"`
+	A    int64
+	Tags []string
+}
+
+type EntityOuter struct {
+	One  EntityInner   `gae:",entity"`
+	Many []EntityInner `gae:"many,entity"`
+}
+
+type EntityTree struct {
+	Name     string
+	Children []EntityTree `gae:",entity"`
+}
+
+type EntityBad struct {
+	I int64 `gae:",entity"`
+}
+
+type EntityRaw struct {
+	PM PropertyMap
+}
+
+type BlobInner struct {
+	X int
+	S []string
+}
+
+func init() {
+	gob.Register(BlobInner{})
+}
+
+type BlobFields struct {
+	M map[string][]int `gae:",json"`
+	I interface{}      `gae:"iface,gob"`
+	N *BlobInner       `gae:",gob"`
+}
+
+type Compressed struct {
+	S  string   `gae:",compress"`
+	B  []byte   `gae:",compress"`
+	SS []string `gae:",compress"`
+}
+
+type BadCompressed struct {
+	I int64 `gae:",compress"`
+}
+
+type MapFields struct {
+	Counts map[string]int64
+	Tags   map[string][]string `gae:"tags"`
+}
+
+type MapInner struct {
+	M map[string]int
+}
+
+type MapOuter struct {
+	Inner MapInner
+}
+
+type MapBadKey struct {
+	M map[int]string
+}
+
+type MapInSlice struct {
+	S []MapInner
+}
+
+type PtrFields struct {
+	I  *int64
+	S  *string
+	T  *time.Time
+	K  *Key
+	IS []*int64
+}
+
+var (
+	testPtrInt    = int64(5)
+	testPtrString = "hi"
+	testPtrTime   = time.Unix(1e9, 0).UTC()
+)
+
+func init() {
+	RegisterConverter(reflect.TypeOf(url.URL{}),
+		func(v interface{}) (Property, error) {
+			u := v.(url.URL)
+			return MkProperty(u.String()), nil
+		},
+		func(p Property) (interface{}, error) {
+			s, err := p.Project(PTString)
+			if err != nil {
+				return nil, err
+			}
+			u, err := url.Parse(s.(string))
+			if err != nil {
+				return nil, err
+			}
+			return *u, nil
+		})
+
+	RegisterConverter(reflect.TypeOf((*big.Int)(nil)),
+		func(v interface{}) (Property, error) {
+			if b := v.(*big.Int); b != nil {
+				return MkProperty(b.String()), nil
+			}
+			return MkProperty(nil), nil
+		},
+		func(p Property) (interface{}, error) {
+			if p.Type() == PTNull {
+				return nil, nil
+			}
+			s, err := p.Project(PTString)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := new(big.Int).SetString(s.(string), 10)
+			if !ok {
+				return nil, fmt.Errorf("bad big.Int %q", s)
+			}
+			return b, nil
+		})
+
+	RegisterConverter(reflect.TypeOf(unindexedValue{}),
+		func(v interface{}) (Property, error) {
+			return MkPropertyNI(v.(unindexedValue).V), nil
+		},
+		func(p Property) (interface{}, error) {
+			s, err := p.Project(PTString)
+			if err != nil {
+				return nil, err
+			}
+			return unindexedValue{s.(string)}, nil
+		})
+
+	RegisterConverter(reflect.TypeOf(unsavable{}),
+		func(interface{}) (Property, error) {
+			return Property{}, fmt.Errorf("unsavable can't be saved")
+		},
+		func(Property) (interface{}, error) {
+			return unsavable{}, nil
+		})
+}
+
+// unindexedValue is registered with a converter which returns unindexed
+// Properties.
+type unindexedValue struct{ V string }
+
+// unsavable is registered with a converter which always fails to save.
+type unsavable struct{}
+
+type Converted struct {
+	U  url.URL
+	UP *url.URL
+	US []url.URL
+	B  *big.Int
+	BM map[string]*big.Int
+}
+
+var (
+	testURL0 = url.URL{Scheme: "http", Host: "example.com", Path: "/a"}
+	testURL1 = url.URL{Scheme: "https", Host: "example.com", Path: "/b"}
+)
+
+type Computed struct {
+	Name string
+	Tags []string `gae:",noindex"`
+}
+
+type ComputedConflict struct {
+	NameLower string
+}
+
+func init() {
+	RegisterComputedProperty(&Computed{}, "NameLower", func(obj interface{}) (interface{}, error) {
+		return strings.ToLower(obj.(*Computed).Name), nil
+	})
+	RegisterComputedProperty(Computed{}, "TagsUpper", func(obj interface{}) (interface{}, error) {
+		ret := make([]string, len(obj.(*Computed).Tags))
+		for i, t := range obj.(*Computed).Tags {
+			ret[i] = strings.ToUpper(t)
+		}
+		return ret, nil
+	})
+	RegisterComputedProperty(&ComputedConflict{}, "NameLower", func(obj interface{}) (interface{}, error) {
+		return nil, nil
+	})
+}
+
+type Validated struct {
+	Email string   `gae:"email,required,maxlen=20,match=^[^@,]+@[^@,]+$"`
+	Age   int64    `gae:",noindex,min=0,max=150"`
+	Tags  []string `gae:",maxlen=2,match=^[a-z]+$"`
+	Inner ValidatedInner
+}
+
+type ValidatedInner struct {
+	Score *float64 `gae:",min=0.5"`
+}
+
+type BadValidated struct {
+	S string `gae:",min=1"`
+}
+
+type BadValidatedRegexp struct {
+	S string `gae:",match=("`
+}
+
+var testPtrScore = 0.25
+
+type BlobExtra struct {
+	M     map[string]int `gae:",json"`
+	Extra PropertyMap    `gae:",extra"`
+}
+
 type MutuallyRecursive0 struct {
 	I int
 	R []MutuallyRecursive1
@@ -1570,6 +1789,398 @@ var testCases = []testCase{
 		src:    &MutuallyRecursive0{},
 		plsErr: `field "R" has problem: field "R" is recursively defined`,
 	},
+	{
+		desc: "embedded entities save as PTPropertyMap",
+		src: &EntityOuter{
+			One: EntityInner{K: testKey0, A: 1, Tags: []string{"a", "b"}},
+			Many: []EntityInner{
+				{A: 2},
+				{K: testKey1a, Tags: []string{"c"}},
+			},
+		},
+		want: PropertyMap{
+			"One": {mpNI(PropertyMap{
+				"$key": {mpNI(testKey0)},
+				"A":    {mp(1)},
+				"Tags": {mp("a"), mp("b")},
+			})},
+			"many": {
+				mpNI(PropertyMap{"A": {mp(2)}}),
+				mpNI(PropertyMap{
+					"$key": {mpNI(testKey1a)},
+					"A":    {mp(0)},
+					"Tags": {mp("c")},
+				}),
+			},
+		},
+	},
+	{
+		desc: "embedded entities round trip",
+		src: &EntityOuter{
+			One: EntityInner{K: testKey0, A: 1, Tags: []string{"a", "b"}},
+			Many: []EntityInner{
+				{A: 2},
+				{K: testKey1a, Tags: []string{"c"}},
+			},
+		},
+		want: &EntityOuter{
+			One: EntityInner{K: testKey0, A: 1, Tags: []string{"a", "b"}},
+			Many: []EntityInner{
+				{A: 2},
+				{K: testKey1a, Tags: []string{"c"}},
+			},
+		},
+	},
+	{
+		desc: "embedded entities may be recursive",
+		src: &EntityTree{
+			Name: "root",
+			Children: []EntityTree{
+				{Name: "a", Children: []EntityTree{{Name: "a.1"}}},
+				{Name: "b"},
+			},
+		},
+		want: &EntityTree{
+			Name: "root",
+			Children: []EntityTree{
+				{Name: "a", Children: []EntityTree{{Name: "a.1"}}},
+				{Name: "b"},
+			},
+		},
+	},
+	{
+		desc:   "embedded entity fields must be structs",
+		src:    &EntityBad{},
+		plsErr: `entity field "I" has invalid type: int64`,
+	},
+	{
+		desc: "multiple embedded entities need a slice",
+		src: PropertyMap{
+			"One": {mpNI(PropertyMap{}), mpNI(PropertyMap{})},
+		},
+		want:    &EntityOuter{},
+		loadErr: "multiple-valued property requires a slice field type",
+	},
+	{
+		desc: "embedded entity into non-entity field",
+		src: PropertyMap{
+			"One": {mp(1)},
+		},
+		want:    &EntityOuter{},
+		loadErr: "type mismatch",
+	},
+	{
+		desc: "PropertyMap fields hold raw embedded entities",
+		src: &EntityRaw{
+			PM: PropertyMap{"A": {mp(1)}},
+		},
+		want: &EntityRaw{
+			PM: PropertyMap{"A": {mp(1)}},
+		},
+	},
+	{
+		desc: "json fields save as unindexed blobs",
+		src: &struct {
+			M map[string]int `gae:",json"`
+		}{M: map[string]int{"a": 1}},
+		want: PropertyMap{
+			"M": {mpNI([]byte(`{"a":1}`))},
+		},
+	},
+	{
+		desc: "json and gob fields round trip",
+		src: &BlobFields{
+			M: map[string][]int{"a": {1, 2}, "b": nil},
+			I: BlobInner{X: 1, S: []string{"hi"}},
+			N: &BlobInner{X: 2, S: []string{"there"}},
+		},
+		want: &BlobFields{
+			M: map[string][]int{"a": {1, 2}, "b": nil},
+			I: BlobInner{X: 1, S: []string{"hi"}},
+			N: &BlobInner{X: 2, S: []string{"there"}},
+		},
+	},
+	{
+		desc: "nil json and gob fields are saved as null",
+		src:  &BlobFields{},
+		want: PropertyMap{
+			"M":     {mpNI(nil)},
+			"iface": {mpNI(nil)},
+			"N":     {mpNI(nil)},
+		},
+	},
+	{
+		desc: "nil json and gob fields round trip",
+		src:  &BlobFields{},
+		want: &BlobFields{},
+	},
+	{
+		desc: "json decode failures are field mismatches",
+		src: PropertyMap{
+			"M": {mpNI([]byte("not json"))},
+		},
+		want:    &BlobFields{},
+		loadErr: "failed to json-decode",
+	},
+	{
+		desc: "decode failures go to extra",
+		src: PropertyMap{
+			"M": {mpNI([]byte("nope"))},
+		},
+		want: &BlobExtra{
+			Extra: PropertyMap{"M": {mpNI([]byte("nope"))}},
+		},
+	},
+	{
+		desc: "compressed fields save compressed",
+		src: &Compressed{
+			S: strings.Repeat("a", 1000),
+			B: []byte("short"),
+		},
+		want: PropertyMap{
+			"S": {mustCompress(mpNI(strings.Repeat("a", 1000)))},
+			"B": {mpNI([]byte("short"))},
+		},
+	},
+	{
+		desc: "compressed fields round trip",
+		src: &Compressed{
+			S:  strings.Repeat("a", 1000),
+			B:  bytes.Repeat([]byte("b"), 1000),
+			SS: []string{strings.Repeat("c", 1000), "d"},
+		},
+		want: &Compressed{
+			S:  strings.Repeat("a", 1000),
+			B:  bytes.Repeat([]byte("b"), 1000),
+			SS: []string{strings.Repeat("c", 1000), "d"},
+		},
+	},
+	{
+		desc: "compressed fields load uncompressed data",
+		src: PropertyMap{
+			"S":  {mp("indexed")},
+			"SS": {mpNI("a"), mpNI("b")},
+		},
+		want: &Compressed{
+			S:  "indexed",
+			SS: []string{"a", "b"},
+		},
+	},
+	{
+		desc:   "compressed fields must be strings or []byte",
+		src:    &BadCompressed{},
+		plsErr: `compressed field "I" has invalid type: int64`,
+	},
+	{
+		desc: "map fields flatten",
+		src: &MapFields{
+			Counts: map[string]int64{"a": 1, "b.c": 2},
+			Tags:   map[string][]string{"x": {"1", "2"}},
+		},
+		want: PropertyMap{
+			"Counts.a":   {mp(1)},
+			"Counts.b.c": {mp(2)},
+			"tags.x":     {mp("1"), mp("2")},
+		},
+	},
+	{
+		desc: "map fields round trip",
+		src: &MapFields{
+			Counts: map[string]int64{"a": 1, "b.c": 2},
+			Tags:   map[string][]string{"x": {"1", "2"}, "y": {"3"}},
+		},
+		want: &MapFields{
+			Counts: map[string]int64{"a": 1, "b.c": 2},
+			Tags:   map[string][]string{"x": {"1", "2"}, "y": {"3"}},
+		},
+	},
+	{
+		desc: "map fields in substructs",
+		src:  &MapOuter{Inner: MapInner{M: map[string]int{"k": 3}}},
+		want: PropertyMap{
+			"Inner.M.k": {mp(3)},
+		},
+	},
+	{
+		desc: "map fields in substructs round trip",
+		src:  &MapOuter{Inner: MapInner{M: map[string]int{"k": 3}}},
+		want: &MapOuter{Inner: MapInner{M: map[string]int{"k": 3}}},
+	},
+	{
+		desc: "map fields need a key",
+		src: PropertyMap{
+			"Counts": {mp(1)},
+		},
+		want:    &MapFields{},
+		loadErr: "no such struct field",
+	},
+	{
+		desc: "multiple values need a slice map value",
+		src: PropertyMap{
+			"Counts.a": {mp(1), mp(2)},
+		},
+		want:    &MapFields{},
+		loadErr: "multiple-valued property requires a slice field type",
+	},
+	{
+		desc:   "map fields must have string keys",
+		src:    &MapBadKey{},
+		plsErr: `map field "M" must have string keys, not int`,
+	},
+	{
+		desc:   "map fields can't be in a slice of structs",
+		src:    &MapInSlice{},
+		plsErr: `flattening nested structs leads to a slice of slices: field "S"`,
+	},
+	{
+		desc: "pointer fields save their values",
+		src: &PtrFields{
+			I:  &testPtrInt,
+			S:  &testPtrString,
+			T:  &testPtrTime,
+			K:  testKey0,
+			IS: []*int64{&testPtrInt, nil},
+		},
+		want: PropertyMap{
+			"I":  {mp(5)},
+			"S":  {mp("hi")},
+			"T":  {mp(testPtrTime)},
+			"K":  {mp(testKey0)},
+			"IS": {mp(5), mp(nil)},
+		},
+	},
+	{
+		desc: "nil pointer fields save as null",
+		src:  &PtrFields{},
+		want: PropertyMap{
+			"I": {mp(nil)},
+			"S": {mp(nil)},
+			"T": {mp(nil)},
+			"K": {mp(nil)},
+		},
+	},
+	{
+		desc: "pointer fields round trip",
+		src: &PtrFields{
+			I:  &testPtrInt,
+			S:  &testPtrString,
+			IS: []*int64{nil, &testPtrInt},
+		},
+		want: &PtrFields{
+			I:  &testPtrInt,
+			S:  &testPtrString,
+			IS: []*int64{nil, &testPtrInt},
+		},
+	},
+	{
+		desc: "pointer fields are type checked on load",
+		src: PropertyMap{
+			"I": {mp("not an int")},
+		},
+		want:    &PtrFields{},
+		loadErr: "type mismatch",
+	},
+	{
+		desc: "registered converters",
+		src: &Converted{
+			U:  testURL0,
+			UP: &testURL1,
+			US: []url.URL{testURL1, testURL0},
+			B:  big.NewInt(1000),
+			BM: map[string]*big.Int{"x": big.NewInt(-1)},
+		},
+		want: PropertyMap{
+			"U":    {mp("http://example.com/a")},
+			"UP":   {mp("https://example.com/b")},
+			"US":   {mp("https://example.com/b"), mp("http://example.com/a")},
+			"B":    {mp("1000")},
+			"BM.x": {mp("-1")},
+		},
+	},
+	{
+		desc: "registered converters round trip",
+		src: &Converted{
+			U:  testURL0,
+			UP: &testURL1,
+			US: []url.URL{testURL1, testURL0},
+			B:  big.NewInt(1000),
+			BM: map[string]*big.Int{"x": big.NewInt(-1)},
+		},
+		want: &Converted{
+			U:  testURL0,
+			UP: &testURL1,
+			US: []url.URL{testURL1, testURL0},
+			B:  big.NewInt(1000),
+			BM: map[string]*big.Int{"x": big.NewInt(-1)},
+		},
+	},
+	{
+		desc: "registered converters report load failures",
+		src: PropertyMap{
+			"B": {mp("not a number")},
+		},
+		want:    &Converted{},
+		loadErr: `bad big.Int "not a number"`,
+	},
+	{
+		desc: "computed properties are saved",
+		src:  &Computed{Name: "Bob", Tags: []string{"a", "b"}},
+		want: PropertyMap{
+			"Name":      {mp("Bob")},
+			"Tags":      {mpNI("a"), mpNI("b")},
+			"NameLower": {mp("bob")},
+			"TagsUpper": {mp("A"), mp("B")},
+		},
+	},
+	{
+		desc: "computed properties are ignored on load",
+		src:  &Computed{Name: "Bob", Tags: []string{"a", "b"}},
+		want: &Computed{Name: "Bob", Tags: []string{"a", "b"}},
+	},
+	{
+		desc:    "computed properties can't conflict with fields",
+		src:     &ComputedConflict{NameLower: "x"},
+		saveErr: `computed property "NameLower" conflicts with a field`,
+	},
+	{
+		desc: "validated fields",
+		src: &Validated{
+			Email: "a@example.com",
+			Age:   20,
+			Tags:  []string{"a", "b"},
+		},
+		want: PropertyMap{
+			"email":       {mp("a@example.com")},
+			"Age":         {mpNI(20)},
+			"Tags":        {mp("a"), mp("b")},
+			"Inner.Score": {mp(nil)},
+		},
+	},
+	{
+		desc:    "validated fields are required",
+		src:     &Validated{},
+		saveErr: `field "email" of a "datastore.Validated" is invalid: is required`,
+	},
+	{
+		desc: "validated fields report every failure",
+		src: &Validated{
+			Email: "not an email",
+			Age:   -1,
+			Tags:  []string{"a", "B", "c"},
+			Inner: ValidatedInner{Score: &testPtrScore},
+		},
+		saveErr: `"not an email" doesn't match "^[^@,]+@[^@,]+$" (and 4 other validation errors)`,
+	},
+	{
+		desc:   "validation options are type checked",
+		src:    &BadValidated{},
+		plsErr: `field "S" has bad validation option: min/max require a numeric type, not string`,
+	},
+	{
+		desc:   "validation regexps must compile",
+		src:    &BadValidatedRegexp{},
+		plsErr: `field "S" has bad validation option: bad match value "("`,
+	},
 	{
 		desc: "non-exported struct fields",
 		src: &struct {
@@ -1601,6 +2212,14 @@ var testCases = []testCase{
 	},
 }
 
+func mustCompress(p Property) Property {
+	p, err := CompressProperty(p)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
 // checkErr returns the empty string if either both want and err are zero,
 // or if want is a non-empty substring of err's string representation.
 func checkErr(want string, err error) string {
@@ -1960,3 +2579,69 @@ func TestMeta(t *testing.T) {
 		})
 	})
 }
+
+func TestRegisteredConverter(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test registered converters", t, func() {
+		Convey("convert Property values", func() {
+			p := Property{}
+			So(p.SetValue(testURL0, ShouldIndex), ShouldBeNil)
+			So(p, ShouldResemble, mp("http://example.com/a"))
+
+			pt, err := PropertyTypeOf(big.NewInt(1), false)
+			So(err, ShouldBeNil)
+			So(pt, ShouldEqual, PTString)
+		})
+
+		Convey("honor the IndexSetting of toProperty", func() {
+			p := Property{}
+			So(p.SetValue(unindexedValue{"hi"}, ShouldIndex), ShouldBeNil)
+			So(p, ShouldResemble, mpNI("hi"))
+
+			type S struct {
+				U  unindexedValue
+				US []unindexedValue
+				N  url.URL `gae:",noindex"`
+			}
+			pm, err := GetPLS(&S{
+				U:  unindexedValue{"a"},
+				US: []unindexedValue{{"b"}},
+				N:  testURL0,
+			}).Save(false)
+			So(err, ShouldBeNil)
+			So(pm, ShouldResemble, PropertyMap{
+				"U":  {mpNI("a")},
+				"US": {mpNI("b")},
+				"N":  {mpNI("http://example.com/a")},
+			})
+		})
+
+		Convey("return the errors of toProperty", func() {
+			p := Property{}
+			So(p.SetValue(unsavable{}, ShouldIndex), ShouldErrLike, "unsavable can't be saved")
+
+			type S struct {
+				U unsavable
+			}
+			_, err := GetPLS(&S{}).Save(false)
+			So(err, ShouldErrLike, "unsavable can't be saved")
+		})
+
+		Convey("can be used as query filter values", func() {
+			fq, err := NewQuery("Foo").Eq("U", testURL0).Finalize()
+			So(err, ShouldBeNil)
+			So(fq.EqFilters()["U"], ShouldResemble, PropertySlice{mp("http://example.com/a")})
+		})
+
+		Convey("can't be registered twice", func() {
+			So(func() { RegisterConverter(reflect.TypeOf(url.URL{}), nil, nil) }, ShouldPanicLike,
+				"url.URL is already registered")
+		})
+
+		Convey("can't be registered for native types", func() {
+			So(func() { RegisterConverter(reflect.TypeOf(""), nil, nil) }, ShouldPanicLike,
+				"string is natively supported")
+		})
+	})
+}
@@ -0,0 +1,245 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldValidator holds the constraints declared by the validation options of
+// a field's `gae` struct tag. It's compiled once, as part of the structCodec.
+type fieldValidator struct {
+	required bool
+
+	min, max       *float64
+	minLen, maxLen int // -1 if unset
+	match          *regexp.Regexp
+}
+
+// parseValidation extracts the validation options from opts (the portion of
+// a `gae` struct tag after the field name), and returns the remaining options
+// along with the compiled validator (or nil, if there were no validation
+// options).
+//
+// Since a regular expression may contain commas, `match=` consumes the rest of
+// the tag, and so must be the last option.
+func parseValidation(opts string) (rest string, fv *fieldValidator, err error) {
+	others := []string(nil)
+	get := func() *fieldValidator {
+		if fv == nil {
+			fv = &fieldValidator{minLen: -1, maxLen: -1}
+		}
+		return fv
+	}
+	for opts != "" {
+		tok := opts
+		if strings.HasPrefix(opts, "match=") {
+			opts = ""
+		} else if i := strings.Index(opts, ","); i != -1 {
+			tok, opts = opts[:i], opts[i+1:]
+		} else {
+			opts = ""
+		}
+
+		key, val := tok, ""
+		if i := strings.Index(tok, "="); i != -1 {
+			key, val = tok[:i], tok[i+1:]
+		}
+		switch key {
+		case "required":
+			get().required = true
+		case "min", "max":
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("bad %s value %q", key, val)
+			}
+			if key == "min" {
+				get().min = &f
+			} else {
+				get().max = &f
+			}
+		case "minlen", "maxlen":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return "", nil, fmt.Errorf("bad %s value %q", key, val)
+			}
+			if key == "minlen" {
+				get().minLen = n
+			} else {
+				get().maxLen = n
+			}
+		case "match":
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return "", nil, fmt.Errorf("bad match value %q: %s", val, err)
+			}
+			get().match = re
+		default:
+			others = append(others, tok)
+		}
+	}
+	return strings.Join(others, ","), fv, nil
+}
+
+// scalarType returns the type of the individual values held by a field of type
+// ft, looking through slices (other than []byte), maps and pointers.
+func scalarType(ft reflect.Type) reflect.Type {
+	if ft.Kind() == reflect.Map {
+		ft = ft.Elem()
+	}
+	if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+		ft = ft.Elem()
+	}
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	return ft
+}
+
+// check returns an error if fv's constraints can't apply to a field of type
+// ft.
+func (fv *fieldValidator) check(ft reflect.Type) error {
+	st := scalarType(ft)
+	if fv.min != nil || fv.max != nil {
+		switch st.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return fmt.Errorf("min/max require a numeric type, not %s", ft)
+		}
+	}
+	if fv.minLen >= 0 || fv.maxLen >= 0 {
+		switch ft.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+		default:
+			return fmt.Errorf("minlen/maxlen require a string, slice or map type, not %s", ft)
+		}
+	}
+	if fv.match != nil && st.Kind() != reflect.String {
+		return fmt.Errorf("match requires a string type, not %s", ft)
+	}
+	return nil
+}
+
+// validate returns a list of reasons why the field value v doesn't satisfy fv.
+func (fv *fieldValidator) validate(v reflect.Value) (reasons []string) {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		if fv.required && v.Len() == 0 {
+			return []string{"is required"}
+		}
+	default:
+		if fv.required && reflect.DeepEqual(reflect.Zero(v.Type()).Interface(), v.Interface()) {
+			return []string{"is required"}
+		}
+	}
+
+	if fv.minLen >= 0 || fv.maxLen >= 0 {
+		l := 0
+		if v.Kind() == reflect.String {
+			l = utf8.RuneCountInString(v.String())
+		} else {
+			l = v.Len()
+		}
+		if fv.minLen >= 0 && l < fv.minLen {
+			reasons = append(reasons, fmt.Sprintf("length %d is less than minlen %d", l, fv.minLen))
+		}
+		if fv.maxLen >= 0 && l > fv.maxLen {
+			reasons = append(reasons, fmt.Sprintf("length %d is greater than maxlen %d", l, fv.maxLen))
+		}
+	}
+
+	if fv.min != nil || fv.max != nil || fv.match != nil {
+		eachScalar(v, func(v reflect.Value) {
+			if fv.match != nil {
+				if s := v.String(); !fv.match.MatchString(s) {
+					reasons = append(reasons, fmt.Sprintf("%q doesn't match %q", s, fv.match))
+				}
+				return
+			}
+
+			f := float64(0)
+			switch v.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				f = float64(v.Int())
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				f = float64(v.Uint())
+			default:
+				f = v.Float()
+			}
+			if fv.min != nil && f < *fv.min {
+				reasons = append(reasons, fmt.Sprintf("value %v is less than min %v", v.Interface(), *fv.min))
+			}
+			if fv.max != nil && f > *fv.max {
+				reasons = append(reasons, fmt.Sprintf("value %v is greater than max %v", v.Interface(), *fv.max))
+			}
+		})
+	}
+	return
+}
+
+// eachScalar calls cb for each individual value held by the field value v,
+// looking through slices (other than []byte), maps and non-nil pointers.
+func eachScalar(v reflect.Value, cb func(reflect.Value)) {
+	switch v.Kind() {
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			eachScalar(v.MapIndex(k), cb)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			cb(v)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			eachScalar(v.Index(i), cb)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			eachScalar(v.Elem(), cb)
+		}
+	default:
+		cb(v)
+	}
+}
+
+// validate appends an ErrFieldInvalid to errs for every constraint violated by
+// the fields of p (including those of flattened substructs), and returns the
+// result.
+func (p *structPLS) validate(prefix string, errs ErrValidation) ErrValidation {
+	for i, st := range p.c.byIndex {
+		if st.name == "-" || st.isExtra {
+			continue
+		}
+		v := p.o.Field(i)
+		if st.validator != nil {
+			for _, reason := range st.validator.validate(v) {
+				errs = append(errs, &ErrFieldInvalid{
+					StructType: p.o.Type(),
+					FieldName:  strings.TrimSuffix(prefix+st.name, "."),
+					Reason:     reason,
+				})
+			}
+		}
+		if st.substructCodec != nil {
+			// st.name already has the trailing "." for substructs.
+			name := prefix + st.name
+			if st.isSlice {
+				for j := 0; j < v.Len(); j++ {
+					errs = (&structPLS{v.Index(j), st.substructCodec}).validate(name, errs)
+				}
+			} else {
+				errs = (&structPLS{v, st.substructCodec}).validate(name, errs)
+			}
+		}
+	}
+	return errs
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/luci/gae/service/blobstore"
@@ -156,6 +157,14 @@ const (
 	// PTBlobKey represents a blobstore.Key
 	PTBlobKey
 
+	// PTPropertyMap represents an embedded entity, stored as a nested
+	// PropertyMap. The embedded entity may optionally carry a *Key in its "$key"
+	// meta field; all other meta fields are dropped.
+	//
+	// Embedded entities are never indexed; a PTPropertyMap Property always has
+	// an IndexSetting of NoIndex.
+	PTPropertyMap
+
 	// PTUnknown is a placeholder value which should never show up in reality.
 	//
 	// NOTE: THIS MUST BE LAST VALUE FOR THE init() ASSERTION BELOW TO WORK.
@@ -234,6 +243,12 @@ func PropertyTypeOf(v interface{}, checkValid bool) (PropertyType, error) {
 	case *Key:
 		// TODO(riannucci): Check key for validity in its own namespace?
 		return PTKey, nil
+	case PropertyMap:
+		err := error(nil)
+		if checkValid {
+			err = checkEmbeddedKey(x)
+		}
+		return PTPropertyMap, err
 	case time.Time:
 		err := error(nil)
 		if checkValid && (x.Before(minTime) || x.After(maxTime)) {
@@ -250,10 +265,30 @@ func PropertyTypeOf(v interface{}, checkValid bool) (PropertyType, error) {
 		}
 		return PTGeoPoint, err
 	default:
+		if c := getConverter(reflect.TypeOf(v)); c != nil {
+			p, err := c.toProperty(v)
+			if err != nil {
+				return PTUnknown, err
+			}
+			return p.Type(), nil
+		}
 		return PTUnknown, fmt.Errorf("gae: Property has bad type %T", v)
 	}
 }
 
+// checkEmbeddedKey ensures that the "$key" meta field of an embedded entity,
+// if present, holds exactly one *Key.
+func checkEmbeddedKey(pm PropertyMap) error {
+	vals, ok := pm["$key"]
+	if !ok || len(vals) == 0 {
+		return nil
+	}
+	if len(vals) > 1 || vals[0].Type() != PTKey {
+		return errors.New("embedded entity has bad \"$key\" meta field")
+	}
+	return nil
+}
+
 // RoundTime rounds a time.Time to microseconds, which is the (undocumented)
 // way that the AppEngine SDK stores it.
 func RoundTime(t time.Time) time.Time {
@@ -293,9 +328,11 @@ func timeLocationIsUTC(l *time.Location) bool {
 
 // UpconvertUnderlyingType takes an object o, and attempts to convert it to
 // its native datastore-compatible type. e.g. int16 will convert to int64, and
-// `type Foo string` will convert to `string`.
+// `type Foo string` will convert to `string`. Values of types registered with
+// RegisterConverter are returned unchanged, since their conversion may fail;
+// Property.SetValue converts them.
 func UpconvertUnderlyingType(o interface{}) interface{} {
-	if o == nil {
+	if o == nil || getConverter(reflect.TypeOf(o)) != nil {
 		return o
 	}
 
@@ -374,6 +411,8 @@ func (p *Property) Type() PropertyType { return p.propType }
 //	- float64
 //	- *Key
 //	- GeoPoint
+//	- PropertyMap
+//    (an embedded entity, which is never indexed)
 // This set is smaller than the set of valid struct field types that the
 // datastore can load and save. A Property Value cannot be a slice (apart
 // from []byte); use multiple Properties instead. Also, a Value's type
@@ -383,6 +422,10 @@ func (p *Property) Type() PropertyType { return p.propType }
 // invalid. Again, this is more restrictive than the set of valid struct
 // field types.
 //
+// A value may also be of any type registered with RegisterConverter, in
+// which case p is set to the Property returned by the registered toProperty
+// function (made unindexed if is is NoIndex), and its error is returned.
+//
 // A value may also be the nil interface value; this is equivalent to
 // Python's None but not directly representable by a Go struct. Loading
 // a nil-valued property into a struct will set that field to the zero
@@ -390,6 +433,16 @@ func (p *Property) Type() PropertyType { return p.propType }
 func (p *Property) SetValue(value interface{}, is IndexSetting) (err error) {
 	pt := PTNull
 	if value != nil {
+		if conv, ok, err := convertRegistered(value); ok {
+			if err != nil {
+				return err
+			}
+			*p = conv
+			if is == NoIndex {
+				p.indexSetting = NoIndex
+			}
+			return nil
+		}
 		value = UpconvertUnderlyingType(value)
 		if pt, err = PropertyTypeOf(value, true); err != nil {
 			return
@@ -408,6 +461,10 @@ func (p *Property) SetValue(value interface{}, is IndexSetting) (err error) {
 		value = RoundTime(t)
 	}
 
+	if pt == PTPropertyMap {
+		is = NoIndex
+	}
+
 	p.propType = pt
 	p.value = value
 	p.indexSetting = is
@@ -429,9 +486,13 @@ func (p *Property) SetValue(value interface{}, is IndexSetting) (err error) {
 //	- []byte
 //	- GeoPoint
 //	- *Key
+//	- PropertyMap
+//
+// Note that PTPropertyMap values are never actually indexed; they are returned
+// as-is so that they may still be serialized and compared.
 func (p Property) IndexTypeAndValue() (PropertyType, interface{}) {
 	switch t := p.propType; t {
-	case PTNull, PTInt, PTBool, PTFloat, PTGeoPoint, PTKey:
+	case PTNull, PTInt, PTBool, PTFloat, PTGeoPoint, PTKey, PTPropertyMap:
 		return t, p.Value()
 
 	case PTTime:
@@ -511,6 +572,8 @@ func (p *Property) Project(to PropertyType) (interface{}, error) {
 			return nil, nil
 		case PTBlobKey:
 			return blobstore.Key(""), nil
+		case PTPropertyMap:
+			return PropertyMap(nil), nil
 		}
 	}
 	return nil, fmt.Errorf("unable to project %s to %s", pt, to)
@@ -610,11 +673,65 @@ func (p *Property) Compare(other *Property) int {
 		}
 		return -1
 
+	case PTPropertyMap:
+		return cmpPropertyMap(av.(PropertyMap), bv.(PropertyMap))
+
 	default:
 		panic(fmt.Errorf("uncomparable type: %s", t))
 	}
 }
 
+// cmpPropertyMap compares two embedded entities. They are compared first by
+// their "$key" meta field, and then property-by-property in name order. Other
+// meta fields are ignored.
+func cmpPropertyMap(a, b PropertyMap) int {
+	ak, _ := a.GetMeta("key")
+	bk, _ := b.GetMeta("key")
+	switch ak, bk := ak.(*Key), bk.(*Key); {
+	case ak == nil && bk != nil:
+		return -1
+	case ak != nil && bk == nil:
+		return 1
+	case ak != nil && !ak.Equal(bk):
+		if ak.Less(bk) {
+			return -1
+		}
+		return 1
+	}
+
+	an, bn := propertyNames(a), propertyNames(b)
+	for i := 0; i < len(an) && i < len(bn); i++ {
+		if an[i] != bn[i] {
+			if an[i] < bn[i] {
+				return -1
+			}
+			return 1
+		}
+		av, bv := a[an[i]], b[bn[i]]
+		for j := 0; j < len(av) && j < len(bv); j++ {
+			if cmp := av[j].Compare(&bv[j]); cmp != 0 {
+				return cmp
+			}
+		}
+		if cmp := len(av) - len(bv); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(an) - len(bn)
+}
+
+// propertyNames returns the sorted non-meta property names of pm.
+func propertyNames(pm PropertyMap) []string {
+	ret := make([]string, 0, len(pm))
+	for k := range pm {
+		if !isMetaKey(k) {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
 // GQL returns a correctly formatted Cloud Datastore GQL literal which
 // is valid for a comparison value in the `WHERE` clause.
 //
@@ -623,10 +740,13 @@ func (p *Property) Compare(other *Property) int {
 //
 // NOTE: GeoPoint values are emitted with speculated future syntax. There is
 // currently no syntax for literal GeoPoint values.
+//
+// Embedded entities (PTPropertyMap) have no literal syntax either, and are
+// emitted as NULL, since they can never match a query filter.
 func (p *Property) GQL() string {
 	v := p.Value()
 	switch p.propType {
-	case PTNull:
+	case PTNull, PTPropertyMap:
 		return "NULL"
 
 	case PTInt, PTFloat, PTBool:
@@ -688,6 +808,13 @@ func (p *Property) EstimateSize() int64 {
 		return 1 + int64(len(p.Value().([]byte)))
 	case PTKey:
 		return 1 + p.Value().(*Key).EstimateSize()
+	case PTPropertyMap:
+		pm := p.Value().(PropertyMap)
+		ret := 1 + pm.EstimateSize()
+		if k, ok := pm.GetMeta("key"); ok {
+			ret += k.(*Key).EstimateSize()
+		}
+		return ret
 	}
 	panic(fmt.Errorf("Unknown property type: %s", p.Type().String()))
 }
@@ -155,6 +155,26 @@ func TestProperties(t *testing.T) {
 				So(pv.IndexSetting(), ShouldEqual, ShouldIndex)
 				So(pv.Type().String(), ShouldEqual, "PTBytes")
 			})
+			Convey("PropertyMap is an embedded entity", func() {
+				pm := PropertyMap{"A": {MkProperty(1)}}
+				pv := Property{}
+				So(pv.SetValue(pm, ShouldIndex), ShouldBeNil)
+				So(pv.Value(), ShouldResemble, pm)
+				So(pv.IndexSetting(), ShouldEqual, NoIndex)
+				So(pv.Type().String(), ShouldEqual, "PTPropertyMap")
+				So(pv.GQL(), ShouldEqual, "NULL")
+				So(pv.EstimateSize(), ShouldEqual, 1+1+9)
+
+				v, err := MkProperty(nil).Project(PTPropertyMap)
+				So(err, ShouldBeNil)
+				So(v, ShouldResemble, PropertyMap(nil))
+			})
+			Convey("embedded entity with a bad $key", func() {
+				pv := Property{}
+				err := pv.SetValue(PropertyMap{"$key": {MkProperty(1)}}, NoIndex)
+				So(err.Error(), ShouldContainSubstring, `bad "$key" meta field`)
+				So(pv.Type().String(), ShouldEqual, "PTNull")
+			})
 		})
 
 		Convey("Comparison", func() {
@@ -163,6 +183,22 @@ func TestProperties(t *testing.T) {
 				b := MkProperty("ohaithere")
 				So(a.Equal(&b), ShouldBeTrue)
 			})
+			Convey(`Embedded entities compare by key, then by properties.`, func() {
+				k := MakeKey("s~aid", "ns", "Kind", 1)
+				a := MkProperty(PropertyMap{"A": {MkProperty(1)}})
+				b := MkProperty(PropertyMap{"A": {MkProperty(1)}})
+				So(a.Equal(&b), ShouldBeTrue)
+
+				b = MkProperty(PropertyMap{"A": {MkProperty(2)}})
+				So(a.Less(&b), ShouldBeTrue)
+
+				b = MkProperty(PropertyMap{"A": {MkProperty(1)}, "B": {}})
+				So(a.Less(&b), ShouldBeTrue)
+
+				b = MkProperty(PropertyMap{"$key": {MkPropertyNI(k)}, "A": {MkProperty(1)}})
+				So(a.Less(&b), ShouldBeTrue)
+				So(b.Less(&a), ShouldBeFalse)
+			})
 		})
 	})
 }
@@ -4,9 +4,9 @@ package datastore
 
 import "fmt"
 
-const _PropertyType_name = "PTNullPTIntPTTimePTBoolPTBytesPTStringPTFloatPTGeoPointPTKeyPTBlobKeyPTUnknown"
+const _PropertyType_name = "PTNullPTIntPTTimePTBoolPTBytesPTStringPTFloatPTGeoPointPTKeyPTBlobKeyPTPropertyMapPTUnknown"
 
-var _PropertyType_index = [...]uint8{0, 6, 11, 17, 23, 30, 38, 45, 55, 60, 69, 78}
+var _PropertyType_index = [...]uint8{0, 6, 11, 17, 23, 30, 38, 45, 55, 60, 69, 82, 91}
 
 func (i PropertyType) String() string {
 	if i >= PropertyType(len(_PropertyType_index)-1) {
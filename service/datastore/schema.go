@@ -0,0 +1,171 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SchemaProperty is the name of the unindexed property in which the datastore
+// stores the schema version of entities saved from a struct with
+// a `gae:"$schema,N"` field.
+//
+// Interface translates between it and the "$schema" meta field: PropertyMaps
+// are saved with their $schema meta field stored in SchemaProperty, and loaded
+// with SchemaProperty moved back into $schema. Since meta fields aren't loaded
+// into struct fields, structs without a $schema field ignore the version.
+// RawInterface users see SchemaProperty as it's stored.
+const SchemaProperty = "_schema"
+
+// SchemaUpgradeFunc upgrades the properties of an entity from one schema
+// version to the next. It may modify and return pm, or return a new
+// PropertyMap.
+type SchemaUpgradeFunc func(pm PropertyMap) (PropertyMap, error)
+
+type schemaKind struct {
+	upgrades  map[int64]SchemaUpgradeFunc
+	writeBack bool
+}
+
+var (
+	schemaKindsMutex sync.RWMutex
+	schemaKinds      = map[string]*schemaKind{}
+)
+
+// getSchemaKindLocked returns the schemaKind for kind, creating it if
+// necessary. schemaKindsMutex must be held for writing.
+func getSchemaKindLocked(kind string) *schemaKind {
+	sk, ok := schemaKinds[kind]
+	if !ok {
+		sk = &schemaKind{upgrades: map[int64]SchemaUpgradeFunc{}}
+		schemaKinds[kind] = sk
+	}
+	return sk
+}
+
+// RegisterSchemaUpgrade registers fn to upgrade entities of the given kind
+// from schema version `from` to version `from+1`.
+//
+// When an entity is loaded into a struct with a `gae:"$schema,N"` field, and
+// the entity was saved with an older schema version (entities without
+// a SchemaProperty are version 0), every upgrade function from the entity's
+// version up to N is applied, in order, to its properties before they're
+// loaded into the struct. It's an error if any of them is missing.
+//
+// Registering the same kind and version twice panics.
+func RegisterSchemaUpgrade(kind string, from int64, fn SchemaUpgradeFunc) {
+	schemaKindsMutex.Lock()
+	defer schemaKindsMutex.Unlock()
+	sk := getSchemaKindLocked(kind)
+	if _, ok := sk.upgrades[from]; ok {
+		panic(fmt.Errorf("RegisterSchemaUpgrade: kind %q already has an upgrade from version %d", kind, from))
+	}
+	sk.upgrades[from] = fn
+}
+
+// SetSchemaWriteBack controls whether entities of the given kind which were
+// upgraded by Interface.Get, GetMulti or GetAll are immediately written back to
+// the datastore in their upgraded form. It's disabled by default.
+//
+// When enabled, reading upgraded entities costs an additional PutMulti, which
+// doesn't call BeforeSave hooks, and whose errors are returned as the errors
+// of the affected entities. Entities read inside of a transaction are never
+// written back.
+func SetSchemaWriteBack(kind string, writeBack bool) {
+	schemaKindsMutex.Lock()
+	defer schemaKindsMutex.Unlock()
+	getSchemaKindLocked(kind).writeBack = writeBack
+}
+
+func schemaWriteBack(kind string) bool {
+	schemaKindsMutex.RLock()
+	defer schemaKindsMutex.RUnlock()
+	sk, ok := schemaKinds[kind]
+	return ok && sk.writeBack
+}
+
+func getSchemaUpgrade(kind string, from int64) SchemaUpgradeFunc {
+	schemaKindsMutex.RLock()
+	defer schemaKindsMutex.RUnlock()
+	if sk, ok := schemaKinds[kind]; ok {
+		return sk.upgrades[from]
+	}
+	return nil
+}
+
+// SchemaVersion returns the schema version in pm's $schema meta field, or 0 if
+// there is none.
+func SchemaVersion(pm PropertyMap) int64 {
+	v, _ := pm.GetMeta("schema")
+	ret, _ := v.(int64)
+	return ret
+}
+
+// schemaToStored returns pm with its $schema meta field, if it has one, stored
+// in SchemaProperty. pm itself isn't modified.
+func schemaToStored(pm PropertyMap) PropertyMap {
+	v, ok := pm.GetMeta("schema")
+	if !ok {
+		return pm
+	}
+	ret := make(PropertyMap, len(pm)+1)
+	for k, props := range pm {
+		ret[k] = props
+	}
+	ret[SchemaProperty] = []Property{MkPropertyNI(v)}
+	return ret
+}
+
+// schemaFromStored reverses schemaToStored: it returns pm with its
+// SchemaProperty, if it has one, moved into the $schema meta field. pm itself
+// isn't modified.
+func schemaFromStored(pm PropertyMap) PropertyMap {
+	props, ok := pm[SchemaProperty]
+	if !ok {
+		return pm
+	}
+	ret := make(PropertyMap, len(pm))
+	for k, v := range pm {
+		if k != SchemaProperty {
+			ret[k] = v
+		}
+	}
+	if len(props) == 1 {
+		ret["$schema"] = []Property{MkPropertyNI(props[0].Value())}
+	}
+	return ret
+}
+
+// UpgradeSchema applies the upgrade functions registered for kind to pm, to
+// bring it from its SchemaVersion to version `to`. The returned PropertyMap
+// doesn't have a $schema meta field.
+//
+// pm itself isn't modified, though it may share Property slices with the
+// returned PropertyMap.
+func UpgradeSchema(kind string, pm PropertyMap, to int64) (PropertyMap, error) {
+	from := SchemaVersion(pm)
+	if from > to {
+		return nil, fmt.Errorf("gae: %q entity has schema version %d, which is newer than %d", kind, from, to)
+	}
+
+	ret := make(PropertyMap, len(pm))
+	for k, v := range pm {
+		if k != "$schema" {
+			ret[k] = v
+		}
+	}
+	for v := from; v < to; v++ {
+		fn := getSchemaUpgrade(kind, v)
+		if fn == nil {
+			return nil, fmt.Errorf("gae: no schema upgrade registered for %q from version %d", kind, v)
+		}
+		var err error
+		if ret, err = fn(ret); err != nil {
+			return nil, fmt.Errorf("gae: upgrading %q from schema version %d: %s", kind, v, err)
+		}
+	}
+	return ret, nil
+}
@@ -270,6 +270,8 @@ func writeIndexValue(buf Buffer, context KeyContext, v interface{}) (err error)
 		err = WriteGeoPoint(buf, t)
 	case *ds.Key:
 		err = WriteKey(buf, context, t)
+	case ds.PropertyMap:
+		err = WriteEmbeddedEntity(buf, context, t)
 
 	default:
 		err = fmt.Errorf("unsupported type: %T", t)
@@ -315,6 +317,8 @@ func ReadProperty(buf Buffer, context KeyContext, appid, namespace string) (p ds
 			break
 		}
 		val = blobstore.Key(s)
+	case ds.PTPropertyMap:
+		val, err = ReadEmbeddedEntity(buf, context, appid, namespace)
 	default:
 		err = fmt.Errorf("read: unknown type! %v", b)
 	}
@@ -399,6 +403,48 @@ func ReadPropertyMap(buf Buffer, context KeyContext, appid, namespace string) (p
 	return
 }
 
+// WriteEmbeddedEntity writes the value of a PTPropertyMap Property to the
+// buffer. `context` behaves the same way that it does for WriteKey, and
+// applies to both the embedded entity's "$key" (if any) and its properties.
+func WriteEmbeddedEntity(buf Buffer, context KeyContext, pm ds.PropertyMap) (err error) {
+	// [0 || 1 ++ key] ++ propertymap
+	defer recoverTo(&err)
+	if k, ok := pm.GetMeta("key"); ok {
+		panicIf(buf.WriteByte(1))
+		panicIf(WriteKey(buf, context, k.(*ds.Key)))
+	} else {
+		panicIf(buf.WriteByte(0))
+	}
+	return WritePropertyMap(buf, context, pm)
+}
+
+// ReadEmbeddedEntity reads the value of a PTPropertyMap Property from the
+// buffer. `context` and friends behave the same way that they do for ReadKey.
+func ReadEmbeddedEntity(buf Buffer, context KeyContext, appid, namespace string) (pm ds.PropertyMap, err error) {
+	defer recoverTo(&err)
+
+	hasKey, e := buf.ReadByte()
+	panicIf(e)
+
+	k := (*ds.Key)(nil)
+	switch hasKey {
+	case 0:
+	case 1:
+		k, e = ReadKey(buf, context, appid, namespace)
+		panicIf(e)
+	default:
+		err = fmt.Errorf("helper: expected embedded key flag to be 0 or 1, got %d", hasKey)
+		return
+	}
+
+	pm, e = ReadPropertyMap(buf, context, appid, namespace)
+	panicIf(e)
+	if k != nil {
+		pm["$key"] = []ds.Property{ds.MkPropertyNI(k)}
+	}
+	return
+}
+
 // WriteIndexColumn writes an IndexColumn to the buffer.
 func WriteIndexColumn(buf Buffer, c ds.IndexColumn) (err error) {
 	defer recoverTo(&err)
@@ -103,6 +103,21 @@ func TestPropertyMapSerialization(t *testing.T) {
 				"E": {},
 			},
 		},
+		{
+			"embedded",
+			ds.PropertyMap{
+				"Sub": {
+					mp(ds.PropertyMap{
+						"$key": {mpNI(mkKey("appy", "ns", "Foo", 7))},
+						"A":    {mp(1), mp("two")},
+						"Deeper": {mp(ds.PropertyMap{
+							"B": {mpNI([]byte("three"))},
+						})},
+					}),
+					mp(ds.PropertyMap{}),
+				},
+			},
+		},
 	}
 
 	Convey("PropertyMap serialization", t, func() {
@@ -389,6 +404,17 @@ func TestSerializationReadMisc(t *testing.T) {
 				_, err := ReadProperty(buf, WithContext, "", "")
 				So(err, ShouldEqual, io.EOF)
 			})
+			Convey("trunc (PTPropertyMap)", func() {
+				die(buf.WriteByte(byte(ds.PTPropertyMap)))
+				_, err := ReadProperty(buf, WithContext, "", "")
+				So(err, ShouldEqual, io.EOF)
+			})
+			Convey("bad embedded key flag", func() {
+				die(buf.WriteByte(byte(ds.PTPropertyMap)))
+				die(buf.WriteByte(2))
+				_, err := ReadProperty(buf, WithContext, "", "")
+				So(err, ShouldErrLike, "embedded key flag")
+			})
 			Convey("invalid type", func() {
 				die(buf.WriteByte(byte(ds.PTUnknown + 1)))
 				_, err := ReadProperty(buf, WithContext, "", "")
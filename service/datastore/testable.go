@@ -4,11 +4,152 @@
 
 package datastore
 
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
 // TestingSnapshot is an opaque implementation-defined snapshot type.
 type TestingSnapshot interface {
 	ImATestingSnapshot()
 }
 
+// IndexScan describes the scan of a single index (see QueryPlan).
+type IndexScan struct {
+	// Index is the index which was scanned. It's nil if the entity table itself
+	// was scanned (e.g. for kindless queries).
+	Index *IndexDefinition
+
+	// Prefix is the encoded value of the equality-filtered columns at the front
+	// of Index, which every scanned row had.
+	Prefix []byte
+
+	// Start and End are the encoded bounds of the rest of the row (after
+	// Prefix), from the inequality filter or cursors. Rows are scanned from
+	// Start (inclusive) to End (exclusive). A nil bound is unbounded.
+	Start, End []byte
+
+	// RowsScanned is the number of rows that were read from this index.
+	RowsScanned int64
+}
+
+// QueryPlan describes how a testing datastore implementation executed a query.
+// See Testable.Explain.
+type QueryPlan struct {
+	// Scans has an entry for every index which was used by the query. If there
+	// is more than one, the indexes were merge-joined: a row is only a result if
+	// it appears (after the Prefix) in every one of them.
+	Scans []IndexScan
+
+	// LowerBound and UpperBound are the encoded bounds of the query's inequality
+	// filter, if it has one.
+	LowerBound, UpperBound []byte
+
+	// Returned is the number of results which were returned, after applying the
+	// offset, limit, and deduplication.
+	Returned int64
+}
+
+// RowsScanned returns the total number of index rows that were read by all of
+// p's Scans.
+func (p *QueryPlan) RowsScanned() int64 {
+	ret := int64(0)
+	for _, s := range p.Scans {
+		ret += s.RowsScanned
+	}
+	return ret
+}
+
+func (p *QueryPlan) String() string {
+	ret := bytes.Buffer{}
+	if len(p.Scans) == 0 {
+		ret.WriteString("no scans (the query can't have any results)\n")
+	}
+	for i, s := range p.Scans {
+		idx := "<entity table>"
+		if s.Index != nil {
+			idx = s.Index.String()
+		}
+		fmt.Fprintf(&ret, "scan %d: %s prefix=%x start=%x end=%x rows=%d\n",
+			i, idx, s.Prefix, s.Start, s.End, s.RowsScanned)
+	}
+	if p.LowerBound != nil || p.UpperBound != nil {
+		fmt.Fprintf(&ret, "inequality bounds: [%x, %x)\n", p.LowerBound, p.UpperBound)
+	}
+	fmt.Fprintf(&ret, "rows scanned: %d, returned: %d", p.RowsScanned(), p.Returned)
+	return ret.String()
+}
+
+// ConsistencyPolicy configures a simulation of the high-replication
+// datastore's eventual consistency. See Testable.SetConsistencyPolicy.
+//
+// Every write is hidden from eventually consistent queries until it has been
+// skipped by a random number of them (chosen uniformly from [0, MaxQueries]),
+// and a random amount of clock time (chosen uniformly from [0, MaxDelay]) has
+// passed. Writes to the same entity group always become visible in the order
+// that they were made.
+type ConsistencyPolicy struct {
+	// Seed seeds the random choices made by the simulation, so that a test which
+	// depends on them behaves the same way every time it's run.
+	Seed int64
+
+	// MaxQueries is the maximum number of eventually consistent queries which
+	// may miss a write. Explain doesn't count as a query.
+	MaxQueries int
+
+	// MaxDelay is the maximum amount of time, according to the clock in the
+	// context which SetConsistencyPolicy was called with, that a write may be
+	// hidden for.
+	MaxDelay time.Duration
+}
+
+// OpCosts is a tally of datastore operations, in the units that production
+// datastore usage is billed in. See Testable.OpCosts.
+type OpCosts struct {
+	// EntityReads counts the entities read by GetMulti (one per key), and by
+	// queries which return full entities. Every query (including Count) also
+	// counts as one read.
+	EntityReads int64
+
+	// EntityWrites counts the entities written by PutMulti or deleted by
+	// DeleteMulti (one per key). Writes in a transaction count when it commits.
+	EntityWrites int64
+
+	// IndexWrites counts the index rows which were added or removed by those
+	// writes, for both built-in and composite indexes. For example, putting a
+	// new entity with one indexed property writes 3 rows: the kind index, and
+	// the ascending and descending property indexes.
+	IndexWrites int64
+
+	// SmallOps counts the results of keys-only queries (including Count) and
+	// projection queries, and calls to AllocateIDs.
+	SmallOps int64
+}
+
+// Add returns the sum of c and o.
+func (c OpCosts) Add(o OpCosts) OpCosts {
+	return OpCosts{
+		c.EntityReads + o.EntityReads,
+		c.EntityWrites + o.EntityWrites,
+		c.IndexWrites + o.IndexWrites,
+		c.SmallOps + o.SmallOps,
+	}
+}
+
+// Sub returns c minus o. This is useful for finding the costs of a block of
+// code from the totals before and after it.
+func (c OpCosts) Sub(o OpCosts) OpCosts {
+	return OpCosts{
+		c.EntityReads - o.EntityReads,
+		c.EntityWrites - o.EntityWrites,
+		c.IndexWrites - o.IndexWrites,
+		c.SmallOps - o.SmallOps,
+	}
+}
+
 // Testable is the testable interface for fake datastore implementations.
 type Testable interface {
 	// AddIndex adds the provided index.
@@ -16,6 +157,20 @@ type Testable interface {
 	// Panics if any of the IndexDefinition objects are not Compound()
 	AddIndexes(...*IndexDefinition)
 
+	// AddIndexesFromYAML reads the index.yaml file at path with ParseIndexYAML,
+	// and adds all of its indexes with AddIndexes. This allows tests to run
+	// against exactly the composite indexes which are deployed.
+	AddIndexesFromYAML(path string) error
+
+	// Explain runs q (discarding its results) and returns a description of how
+	// it was executed: which indexes were scanned, their bounds, and how many
+	// rows were read compared to how many were returned. This is useful for
+	// reasoning about the cost of a query, or why it needs a particular index.
+	//
+	// If q needs an index which doesn't exist (and AutoIndex is false), the
+	// error is the same one that running q would return.
+	Explain(q *Query) (*QueryPlan, error)
+
 	// TakeIndexSnapshot allows you to take a snapshot of the current index
 	// tables, which can be used later with SetIndexSnapshot.
 	TakeIndexSnapshot() TestingSnapshot
@@ -50,6 +205,18 @@ type Testable interface {
 	// CatchupIndexes or use Take/SetIndexSnapshot to manipulate the index state.
 	Consistent(always bool)
 
+	// SetConsistencyPolicy replaces the all-or-nothing eventual consistency
+	// behavior of Consistent(false) with a probabilistic simulation of the
+	// high-replication datastore, like the dev_appserver's consistency policy.
+	// See ConsistencyPolicy for details. Ancestor queries and Get are still
+	// always consistent, CatchupIndexes makes all pending writes visible, and
+	// SetIndexSnapshot has no effect.
+	//
+	// The simulation starts from the current state of the datastore, and lasts
+	// until Consistent is called. SetConsistencyPolicy(nil) is equivalent to
+	// Consistent(false).
+	SetConsistencyPolicy(*ConsistencyPolicy)
+
 	// AutoIndex controls the index creation behavior. If it is set to true, then
 	// any time the datastore encounters a missing index, it will silently create
 	// one and allow the query to succeed. If it's false, then the query will
@@ -58,6 +225,13 @@ type Testable interface {
 	// By default this is false.
 	AutoIndex(bool)
 
+	// AutoIndexed returns every compound index which was automatically created
+	// because AutoIndex was enabled, normalized (see IndexDefinition.Normalize),
+	// without duplicates, ordered by IndexDefinition.Less. Combined with
+	// MergeIndexYAML, this allows tests to detect (and fix) index.yaml files
+	// which are missing indexes that the code needs.
+	AutoIndexed() []*IndexDefinition
+
 	// DisableSpecialEntities turns off maintenance of special __entity_group__
 	// type entities. By default this mainenance is enabled, but it can be
 	// disabled by calling this with true.
@@ -73,4 +247,37 @@ type Testable interface {
 	// but never wants the in-memory versions of these entities to bleed through
 	// to the user code.
 	DisableSpecialEntities(bool)
+
+	// OpCosts returns the total costs of every operation which has been
+	// performed on this datastore.
+	OpCosts() OpCosts
+
+	// TrackOpCosts returns a context derived from c, which also adds the costs
+	// of the operations performed with it to tally. This includes transactions
+	// started with it, and contexts derived from it (which may track their own
+	// tallies too). This allows the costs of e.g. a single request handler to be
+	// asserted on.
+	//
+	// tally is updated with atomic operations, so it may be shared by
+	// concurrent goroutines, but it should only be read once they're done.
+	TrackOpCosts(c context.Context, tally *OpCosts) context.Context
+
+	// StrictLimits controls whether the limits which the production datastore
+	// imposes on batches, entities and transactions (MaxGetBatchSize,
+	// MaxPutBatchSize, MaxDeleteBatchSize, MaxEntitySize,
+	// MaxIndexedPropertyBytes, MaxIndexEntries and MaxTransactionSize) are
+	// enforced. If enabled, violating them returns an *ErrBadRequest, like
+	// production does.
+	//
+	// By default this is false.
+	StrictLimits(bool)
+
+	// UpdateStats recomputes the datastore statistics entities (e.g.
+	// __Stat_Total__, __Stat_Kind__ and __Stat_PropertyType__, and their
+	// __Stat_Ns_*__ per-namespace variants) from the current entities and
+	// indexes, like production's periodic statistics job does. They have the
+	// same kinds, key names and properties as the production ones, and are
+	// written like any other entity (so e.g. they obey Consistent). They aren't
+	// updated at any other time.
+	UpdateStats()
 }
@@ -0,0 +1,183 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package example
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/luci/gae/service/datastore"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	mp   = datastore.MkProperty
+	mpNI = datastore.MkPropertyNI
+)
+
+type plsMGS interface {
+	datastore.PropertyLoadSaver
+	datastore.MetaGetterSetter
+}
+
+// equivalent asserts that gen (a generated implementation) and ref
+// (datastore.GetPLS of a value of the same type) behave identically when
+// loading pm, and saving the result. pm must not cause more than one property
+// to fail to load, since the order of the errors depends on map iteration.
+func equivalent(gen, ref plsMGS, pm datastore.PropertyMap) {
+	So(gen.Load(pm), ShouldResemble, ref.Load(pm))
+
+	for _, withMeta := range []bool{false, true} {
+		genPM, genErr := gen.Save(withMeta)
+		refPM, refErr := ref.Save(withMeta)
+		So(genErr, ShouldResemble, refErr)
+		So(genPM, ShouldResemble, refPM)
+	}
+	So(gen.GetAllMeta(), ShouldResemble, ref.GetAllMeta())
+}
+
+func TestGeneratedEquivalence(t *testing.T) {
+	t.Parallel()
+
+	key := datastore.MakeKey("aid", "ns", "Parent", 1)
+	now := time.Date(2015, 10, 21, 16, 29, 0, 0, time.UTC)
+
+	Convey("Generated methods behave like datastore.GetPLS", t, func() {
+		Convey("Simple", func() {
+			load := func(pm datastore.PropertyMap) {
+				gen, ref := &Simple{}, &Simple{}
+				equivalent(gen, datastore.GetPLS(ref), pm)
+				So(gen, ShouldResemble, ref)
+			}
+
+			Convey("empty", func() {
+				load(datastore.PropertyMap{})
+			})
+			Convey("value", func() {
+				load(datastore.PropertyMap{"Value": {mp("hi")}})
+			})
+			Convey("unknown property", func() {
+				load(datastore.PropertyMap{"Value": {mp("hi")}, "Nope": {mp(1)}})
+			})
+			Convey("type mismatch", func() {
+				load(datastore.PropertyMap{"Value": {mp(100)}})
+			})
+			Convey("multiple values", func() {
+				load(datastore.PropertyMap{"Value": {mp("a"), mp("b")}})
+			})
+			Convey("meta fields are ignored", func() {
+				load(datastore.PropertyMap{"Value": {mp("hi")}, "$schema": {mp(1)}})
+			})
+		})
+
+		Convey("Everything", func() {
+			load := func(pm datastore.PropertyMap) {
+				gen, ref := &Everything{}, &Everything{}
+				equivalent(gen, datastore.GetPLS(ref), pm)
+				So(gen, ShouldResemble, ref)
+			}
+
+			Convey("empty", func() {
+				load(datastore.PropertyMap{})
+			})
+			Convey("all fields", func() {
+				load(datastore.PropertyMap{
+					"Int":     {mp(1)},
+					"Int8":    {mp(2)},
+					"Int16":   {mp(3)},
+					"Int32":   {mp(4)},
+					"i64":     {mp(5)},
+					"Bool":    {mp(true)},
+					"str":     {mpNI("str")},
+					"Float32": {mp(1.5)},
+					"Float64": {mp(2.5)},
+					"Bytes":   {mp([]byte("bytes"))},
+					"Time":    {mp(now)},
+					"Geo":     {mp(datastore.GeoPoint{Lat: 1, Lng: 2})},
+					"Key":     {mp(key)},
+					"Ints":    {mp(1), mp(2), mp(3)},
+					"Strs":    {mpNI("a"), mpNI("b")},
+					"Keys":    {mp(key), mp(nil)},
+					"Times":   {mp(now), mp(now.Add(time.Hour))},
+				})
+			})
+			Convey("nulls", func() {
+				load(datastore.PropertyMap{
+					"Int":  {mp(nil)},
+					"Time": {mp(nil)},
+					"Key":  {mp(nil)},
+					"Ints": {mp(nil)},
+				})
+			})
+			Convey("overflows go to extra", func() {
+				load(datastore.PropertyMap{
+					"Int8":    {mp(1000)},
+					"Float32": {mp(math.MaxFloat64)},
+					"Int":     {mp(7)},
+				})
+			})
+			Convey("mismatches and unknown properties go to extra", func() {
+				load(datastore.PropertyMap{
+					"Bool":  {mp("true")},
+					"Ints":  {mp(1), mp("two"), mp(3)},
+					"Other": {mp(1), mp(2)},
+					"Int":   {mp(1), mp(2)},
+				})
+			})
+			Convey("meta fields don't go to extra", func() {
+				load(datastore.PropertyMap{"Int": {mp(1)}, "$schema": {mp(1)}})
+			})
+			Convey("extra doesn't override fields on save", func() {
+				gen, ref := &Everything{Int: 1}, &Everything{Int: 1}
+				gen.Extra = datastore.PropertyMap{"Int": {mp(2)}, "Other": {mp(3)}}
+				ref.Extra = datastore.PropertyMap{"Int": {mp(2)}, "Other": {mp(3)}}
+				equivalent(gen, datastore.GetPLS(ref), datastore.PropertyMap{})
+			})
+			Convey("meta", func() {
+				gen, ref := &Everything{}, datastore.GetPLS(&Everything{})
+				for _, k := range []string{"id", "parent", "kind", "nope"} {
+					gv, gok := gen.GetMeta(k)
+					rv, rok := ref.GetMeta(k)
+					So(gv, ShouldResemble, rv)
+					So(gok, ShouldEqual, rok)
+				}
+
+				So(gen.SetMeta("id", "hello"), ShouldEqual, ref.SetMeta("id", "hello"))
+				So(gen.SetMeta("parent", key), ShouldEqual, ref.SetMeta("parent", key))
+				So(gen.SetMeta("kind", "Other"), ShouldEqual, ref.SetMeta("kind", "Other"))
+				So(gen.SetMeta("nope", 10), ShouldEqual, ref.SetMeta("nope", 10))
+				So(gen.GetAllMeta(), ShouldResemble, ref.GetAllMeta())
+			})
+		})
+
+		Convey("Singleton", func() {
+			load := func(pm datastore.PropertyMap) {
+				gen, ref := &Singleton{}, &Singleton{}
+				equivalent(gen, datastore.GetPLS(ref), pm)
+				So(gen, ShouldResemble, ref)
+			}
+
+			Convey("value", func() {
+				load(datastore.PropertyMap{"value": {mp(10)}})
+			})
+			Convey("mismatches are ignored", func() {
+				load(datastore.PropertyMap{"value": {mp("ten")}, "Other": {mp(1)}})
+			})
+			Convey("meta", func() {
+				gen, ref := &Singleton{}, datastore.GetPLS(&Singleton{})
+				for _, k := range []string{"id", "kind", "enable"} {
+					gv, _ := gen.GetMeta(k)
+					rv, _ := ref.GetMeta(k)
+					So(gv, ShouldResemble, rv)
+				}
+				So(gen.SetMeta("id", 2), ShouldEqual, ref.SetMeta("id", 2))
+				So(gen.SetMeta("enable", false), ShouldEqual, ref.SetMeta("enable", false))
+				So(gen.GetAllMeta(), ShouldResemble, ref.GetAllMeta())
+			})
+		})
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package example contains struct types with pls-gae generated methods. Its
+// tests verify that the generated methods behave like datastore.GetPLS.
+package example
+
+import (
+	"time"
+
+	"github.com/luci/gae/service/datastore"
+)
+
+//go:generate pls-gae -type Simple -type Everything -type Singleton
+
+// Simple has no meta fields, so its kind is "Simple".
+type Simple struct {
+	ID    int64 `gae:"$id"`
+	Value string
+}
+
+// Everything uses every field type and tag that pls-gae supports.
+type Everything struct {
+	ID     string         `gae:"$id"`
+	Parent *datastore.Key `gae:"$parent"`
+	Kind   string         `gae:"$kind,Thing"`
+
+	Int     int
+	Int8    int8
+	Int16   int16
+	Int32   int32
+	Int64   int64 `gae:"i64"`
+	Bool    bool
+	Str     string `gae:"str,noindex"`
+	Float32 float32
+	Float64 float64
+	Bytes   []byte
+	Time    time.Time
+	Geo     datastore.GeoPoint
+	Key     *datastore.Key
+
+	Ints  []int64
+	Strs  []string `gae:",noindex"`
+	Keys  []*datastore.Key
+	Times []time.Time
+
+	Ignored string `gae:"-"`
+	private int
+
+	Extra datastore.PropertyMap `gae:",extra"`
+}
+
+// Singleton has unexported meta fields, and silently drops unknown properties.
+type Singleton struct {
+	id     int64            `gae:"$id,1"`
+	kind   string           `gae:"$kind,TheSingleton"`
+	Enable datastore.Toggle `gae:"$enable,true"`
+
+	Value int64 `gae:"value"`
+
+	_ datastore.PropertyMap `gae:"-,extra"`
+}
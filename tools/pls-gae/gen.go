@@ -0,0 +1,413 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// knownImports are the packages which the generated code may use, in the
+// order in which they're imported. Group 0 is the standard library.
+var knownImports = []struct {
+	name, path string
+	group      int
+}{
+	{"fmt", "fmt", 0},
+	{"math", "math", 0},
+	{"reflect", "reflect", 0},
+	{"time", "time", 0},
+	{"datastore", datastorePkg, 1},
+	{"errors", "github.com/luci/luci-go/common/errors", 1},
+}
+
+// generator renders the generated file, keeping track of the packages that
+// the rendered code uses.
+type generator struct {
+	// imports is the set of names (from knownImports) of the used packages.
+	imports map[string]bool
+}
+
+// use records that the generated code uses the named packages. It returns ""
+// so that the template can call it next to the code which uses them.
+func (g *generator) use(names ...string) string {
+	for _, name := range names {
+		g.imports[name] = true
+	}
+	return ""
+}
+
+// loadCode returns the statements which load props[i] into f, setting
+// `reason` if it can't be loaded. `v` is the projected value.
+func (g *generator) loadCode(f *field) string {
+	g.use("fmt", "datastore")
+	if f.Type.pkg != "" {
+		g.use(f.Type.pkg)
+	}
+	tgt := "s." + f.GoName
+	set := func(val string) string {
+		if f.Slice {
+			return fmt.Sprintf("%s = append(%s, %s)", tgt, tgt, val)
+		}
+		return fmt.Sprintf("%s = %s", tgt, val)
+	}
+	overflow := func(cond string) string {
+		return fmt.Sprintf(`if x := v.(%s); %s {
+			reason = fmt.Sprintf("value %%v overflows struct field of type %s", x)
+		} else {
+			%s
+		}`, f.Type.native, cond, f.Type.reflectName, set(f.Type.goType+"(x)"))
+	}
+
+	body := ""
+	switch f.Type.goType {
+	case "int8", "int16", "int32", "int":
+		body = overflow(fmt.Sprintf("int64(%s(x)) != x", f.Type.goType))
+	case "float32":
+		g.use("math")
+		body = overflow("math.Abs(x) > math.MaxFloat32 && !math.IsInf(x, 0)")
+	case "*datastore.Key":
+		if f.Slice {
+			body = "k, _ := v.(*datastore.Key)\n" + set("k")
+		} else {
+			body = "if k, ok := v.(*datastore.Key); ok {\n" + set("k") + "\n}"
+		}
+	default:
+		body = set(fmt.Sprintf("v.(%s)", f.Type.native))
+	}
+
+	multiple := ""
+	if !f.Slice {
+		multiple = `if len(props) > 1 {
+			reason = "multiple-valued property requires a slice field type"
+		} else `
+	}
+	return fmt.Sprintf(`%sif v, err := props[i].Project(datastore.%s); err != nil {
+			reason = fmt.Sprintf("type mismatch: %%T versus %s", props[i].Value())
+		} else {
+			%s
+		}`, multiple, f.Type.pt, f.Type.reflectName, body)
+}
+
+// saveCode returns the statements which save f to the property map.
+func (g *generator) saveCode(f *field) string {
+	g.use("datastore")
+	is := "datastore.ShouldIndex"
+	if f.NoIndex {
+		is = "datastore.NoIndex"
+	}
+	val := "v"
+	switch f.Type.goType {
+	case "int8", "int16", "int32", "int":
+		val = "int64(v)"
+	case "float32":
+		val = "float64(v)"
+	}
+	name := strconv.Quote(f.PropName)
+	if f.Slice {
+		return fmt.Sprintf(`for _, v := range s.%s {
+			if err := add(%s, %s, %s); err != nil {
+				return nil, err
+			}
+		}`, f.GoName, name, val, is)
+	}
+	return fmt.Sprintf(`if err := add(%s, %s, %s); err != nil {
+			return nil, err
+		}`, name, strings.Replace(val, "v", "s."+f.GoName, 1), is)
+}
+
+// getMetaCode returns the statements which return the value of mf.
+func (g *generator) getMetaCode(mf *metaField) string {
+	if !mf.Exported {
+		return fmt.Sprintf("return %s, true", mf.Default)
+	}
+	tgt := "s." + mf.GoName
+	switch mf.Kind {
+	case "int":
+		val := tgt
+		if mf.GoType != "int64" {
+			val = "int64(" + tgt + ")"
+		}
+		return fmt.Sprintf(`if %s != 0 {
+			return %s, true
+		}
+		return %s, true`, tgt, val, mf.Default)
+	case "string":
+		return fmt.Sprintf(`if %s != "" {
+			return %s, true
+		}
+		return %s, true`, tgt, tgt, mf.Default)
+	case "key":
+		return fmt.Sprintf(`if %s != nil {
+			return %s, true
+		}
+		return nil, true`, tgt, tgt)
+	}
+	g.use("datastore")
+	return fmt.Sprintf(`if %s != datastore.Auto {
+			return %s == datastore.On, true
+		}
+		return %s, true`, tgt, tgt, mf.Default)
+}
+
+// setMetaCode returns the statements which set mf to `val`, and return
+// whether it was set.
+func (g *generator) setMetaCode(mf *metaField) string {
+	if !mf.Exported {
+		return "return false"
+	}
+	tgt := "s." + mf.GoName
+	switch mf.Kind {
+	case "int":
+		return fmt.Sprintf(`switch x := val.(type) {
+		case nil:
+			%s = 0
+		case int64:
+			%s = %s(x)
+		case int:
+			%s = %s(x)
+		default:
+			return false
+		}
+		return true`, tgt, tgt, mf.GoType, tgt, mf.GoType)
+	case "string":
+		return fmt.Sprintf(`switch x := val.(type) {
+		case nil:
+			%s = ""
+		case string:
+			%s = x
+		default:
+			return false
+		}
+		return true`, tgt, tgt)
+	case "key":
+		g.use("datastore")
+		return fmt.Sprintf(`switch x := val.(type) {
+		case nil:
+			%s = nil
+		case *datastore.Key:
+			%s = x
+		default:
+			return false
+		}
+		return true`, tgt, tgt)
+	}
+	g.use("datastore")
+	return fmt.Sprintf(`switch x := val.(type) {
+		case nil:
+			%s = datastore.Auto
+		case bool:
+			if x {
+				%s = datastore.On
+			} else {
+				%s = datastore.Off
+			}
+		case datastore.Toggle:
+			%s = x
+		default:
+			return false
+		}
+		return true`, tgt, tgt, tgt, tgt)
+}
+
+// tmplText is the template of the generated file. Wherever it uses a package
+// directly, it calls `use`, so that the package is imported.
+const tmplText = `// AUTOGENERATED: Do not edit
+
+package {{.Package}}
+
+import (
+	IMPORTS
+){{range .Types}}
+
+var (
+	_ datastore.PropertyLoadSaver = (*{{.Name}})(nil){{use "datastore"}}
+	_ datastore.MetaGetterSetter  = (*{{.Name}})(nil)
+)
+
+// Load implements datastore.PropertyLoadSaver.
+func (s *{{.Name}}) Load(pm datastore.PropertyMap) error {
+	{{- if not .Extra}}
+	convFailures := errors.MultiError(nil){{use "errors"}}
+	{{- end}}
+	for name, props := range pm {
+		if name == "" || name[0] == '$' {
+			// Meta fields (e.g. "$schema") aren't properties.
+			continue
+		}
+		for {{if .Fields}}i{{else}}_{{end}} := range props {
+			reason := ""
+			switch name {
+			{{- range .Fields}}
+			case {{quote .PropName}}:
+				{{load .}}
+			{{- end}}
+			default:
+				reason = "no such struct field"
+			}
+			if reason != "" {
+				{{- if .Extra}}
+				{{- if .Extra.Exported}}
+				if s.{{.Extra.GoName}} == nil {
+					s.{{.Extra.GoName}} = make(datastore.PropertyMap, 1)
+				}
+				s.{{.Extra.GoName}}[name] = props
+				{{- end}}
+				break
+				{{- else}}
+				convFailures = append(convFailures, &datastore.ErrFieldMismatch{
+					StructType: reflect.TypeOf(*s),{{use "reflect"}}
+					FieldName:  name,
+					Reason:     reason,
+				})
+				{{- end}}
+			}
+		}
+	}
+	{{- if .Extra}}
+	return nil
+	{{- else}}
+	if len(convFailures) > 0 {
+		return convFailures
+	}
+	return nil
+	{{- end}}
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (s *{{.Name}}) Save(withMeta bool) (datastore.PropertyMap, error) {
+	ret := datastore.PropertyMap(nil)
+	if withMeta {
+		ret = s.GetAllMeta()
+	} else {
+		ret = make(datastore.PropertyMap, {{len .Fields}})
+	}
+	{{- if .Fields}}
+	idxCount := 0
+	add := func(name string, v interface{}, is datastore.IndexSetting) error {
+		prop := datastore.Property{}
+		if err := prop.SetValue(v, is); err != nil {
+			return err
+		}
+		ret[name] = append(ret[name], prop)
+		if prop.IndexSetting() == datastore.ShouldIndex {
+			if idxCount++; idxCount > 20000 { // the reflective codec's limit
+				return errors.New("gae: too many indexed properties"){{use "errors"}}
+			}
+		}
+		return nil
+	}
+	{{- range .Fields}}
+	{{save .}}
+	{{- end}}
+	{{- end}}
+	{{- if .Extra}}{{if .Extra.Save}}
+	for name, props := range s.{{.Extra.GoName}} {
+		if _, ok := ret[name]; !ok {
+			ret[name] = props
+		}
+	}
+	{{- end}}{{end}}
+	return ret, nil
+}
+
+// GetMeta implements datastore.MetaGetter.
+func (s *{{.Name}}) GetMeta(key string) (interface{}, bool) {
+	switch key {
+	{{- range .Meta}}
+	case {{quote .Key}}:
+		{{getMeta .}}
+	{{- end}}
+	{{- if not .HasKindMeta}}
+	case "kind":
+		return {{quote .Name}}, true
+	{{- end}}
+	}
+	return nil, false
+}
+
+// GetAllMeta implements datastore.MetaGetterSetter.
+func (s *{{.Name}}) GetAllMeta() datastore.PropertyMap {
+	ret := make(datastore.PropertyMap, {{len .Meta}}+1)
+	for _, key := range {{metaKeys .}} {
+		val, _ := s.GetMeta(key)
+		prop := datastore.Property{}
+		if err := prop.SetValue(val, datastore.NoIndex); err == nil {
+			ret["$"+key] = []datastore.Property{prop}
+		}
+	}
+	return ret
+}
+
+// SetMeta implements datastore.MetaGetterSetter.
+func (s *{{.Name}}) SetMeta(key string, val interface{}) bool {
+	switch key {
+	{{- range .Meta}}
+	case {{quote .Key}}:
+		{{setMeta .}}
+	{{- end}}
+	}
+	return false
+}{{end}}
+`
+
+// metaKeys returns a []string literal of the meta keys that si's GetAllMeta
+// returns.
+func metaKeys(si *structInfo) string {
+	keys := make([]string, 0, len(si.Meta)+1)
+	for _, mf := range si.Meta {
+		keys = append(keys, strconv.Quote(mf.Key))
+	}
+	if !si.HasKindMeta {
+		keys = append(keys, `"kind"`)
+	}
+	return "[]string{" + strings.Join(keys, ", ") + "}"
+}
+
+// generate returns the formatted source code of the generated file.
+func generate(pkgName string, structs []*structInfo) ([]byte, error) {
+	g := &generator{imports: map[string]bool{}}
+	tmpl, err := template.New("main").Funcs(template.FuncMap{
+		"load":     g.loadCode,
+		"save":     g.saveCode,
+		"getMeta":  g.getMetaCode,
+		"setMeta":  g.setMetaCode,
+		"use":      g.use,
+		"quote":    strconv.Quote,
+		"metaKeys": metaKeys,
+	}).Parse(tmplText)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Package string
+		Types   []*structInfo
+	}{pkgName, structs}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, &data); err != nil {
+		return nil, err
+	}
+
+	imports := [2][]string{}
+	for _, imp := range knownImports {
+		if g.imports[imp.name] {
+			imports[imp.group] = append(imports[imp.group], strconv.Quote(imp.path))
+		}
+	}
+	src := strings.Replace(buf.String(), "IMPORTS",
+		strings.Join(imports[0], "\n")+"\n\n"+strings.Join(imports[1], "\n"), 1)
+
+	ret, err := format.Source([]byte(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %s", err)
+	}
+	return ret, nil
+}
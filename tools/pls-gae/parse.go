@@ -0,0 +1,340 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	datastorePkg = "github.com/luci/gae/service/datastore"
+)
+
+// scalar describes one of the Go types which pls-gae knows how to load and
+// save.
+type scalar struct {
+	// goType is how the type is spelled in the generated code.
+	goType string
+	// reflectName is the type's reflect.Type.String(), which the reflective
+	// codec uses in its error messages.
+	reflectName string
+	// pt is the datastore.PropertyType that property values are projected to
+	// when loading.
+	pt string
+	// native is the type that the projected value has.
+	native string
+	// pkg is the name of the package which native is from, if any.
+	pkg string
+}
+
+var scalars = map[string]*scalar{
+	"int":     {"int", "int", "PTInt", "int64", ""},
+	"int8":    {"int8", "int8", "PTInt", "int64", ""},
+	"int16":   {"int16", "int16", "PTInt", "int64", ""},
+	"int32":   {"int32", "int32", "PTInt", "int64", ""},
+	"int64":   {"int64", "int64", "PTInt", "int64", ""},
+	"bool":    {"bool", "bool", "PTBool", "bool", ""},
+	"string":  {"string", "string", "PTString", "string", ""},
+	"float32": {"float32", "float32", "PTFloat", "float64", ""},
+	"float64": {"float64", "float64", "PTFloat", "float64", ""},
+	"[]byte":  {"[]byte", "[]uint8", "PTBytes", "[]byte", ""},
+
+	"time.Time":          {"time.Time", "time.Time", "PTTime", "time.Time", "time"},
+	"datastore.GeoPoint": {"datastore.GeoPoint", "datastore.GeoPoint", "PTGeoPoint", "datastore.GeoPoint", "datastore"},
+	"*datastore.Key":     {"*datastore.Key", "*datastore.Key", "PTKey", "*datastore.Key", "datastore"},
+}
+
+// The following types have exported fields so that they may be used by the
+// template.
+
+// field is a struct field which is saved as a property.
+type field struct {
+	GoName   string
+	PropName string
+	NoIndex  bool
+	Slice    bool
+	Type     *scalar
+}
+
+// metaField is a struct field tagged as `gae:"$key[,default]"`.
+type metaField struct {
+	GoName   string
+	Key      string
+	Exported bool
+	Kind     string // "int", "string", "key" or "toggle"
+	GoType   string
+	Default  string // Go expression for the default value
+}
+
+// extraField is the struct field tagged as `gae:"[-],extra"`.
+type extraField struct {
+	GoName   string
+	Exported bool
+	Save     bool
+}
+
+// structInfo is everything pls-gae needs to know about a struct type to
+// generate its methods.
+type structInfo struct {
+	Name   string
+	Fields []*field
+	Meta   []*metaField
+	Extra  *extraField
+
+	HasKindMeta bool
+}
+
+// parseDir parses the non-test Go files in dir (other than skipFile), and
+// returns the structInfo for each of the named types.
+func parseDir(dir, skipFile string, typeNames []string) (pkgName string, ret []*structInfo, err error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != skipFile
+	}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(pkgs) != 1 {
+		return "", nil, fmt.Errorf("expected exactly one package in %q, found %d", dir, len(pkgs))
+	}
+
+	found := map[string]*structInfo{}
+	for _, pkg := range pkgs {
+		pkgName = pkg.Name
+		for _, f := range pkg.Files {
+			imports := fileImports(f)
+			for _, decl := range f.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok || gd.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, n := range typeNames {
+						if n == ts.Name.Name {
+							si, err := parseStruct(ts.Name.Name, st, imports)
+							if err != nil {
+								return "", nil, fmt.Errorf("type %s: %s", n, err)
+							}
+							found[n] = si
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, n := range typeNames {
+		si, ok := found[n]
+		if !ok {
+			return "", nil, fmt.Errorf("struct type %s not found", n)
+		}
+		ret = append(ret, si)
+	}
+	return
+}
+
+// fileImports maps the names that f uses for its imports to their paths.
+func fileImports(f *ast.File) map[string]string {
+	ret := map[string]string{}
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		ret[name] = path
+	}
+	return ret
+}
+
+// typeName returns the canonical spelling of the type expression e (with
+// "time" and "datastore" as the package names), or "" if it's not a type that
+// pls-gae understands.
+func typeName(e ast.Expr, imports map[string]string) string {
+	switch t := e.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		if n := typeName(t.X, imports); n != "" {
+			return "*" + n
+		}
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return ""
+		}
+		if n := typeName(t.Elt, imports); n != "" {
+			return "[]" + n
+		}
+	case *ast.SelectorExpr:
+		pkg, ok := t.X.(*ast.Ident)
+		if !ok {
+			return ""
+		}
+		switch imports[pkg.Name] {
+		case "time":
+			return "time." + t.Sel.Name
+		case datastorePkg:
+			return "datastore." + t.Sel.Name
+		}
+	}
+	return ""
+}
+
+func parseStruct(name string, st *ast.StructType, imports map[string]string) (*structInfo, error) {
+	si := &structInfo{Name: name}
+	byName := map[string]bool{}
+	byMeta := map[string]bool{}
+
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("embedded fields are not supported")
+		}
+		tag := ""
+		if f.Tag != nil {
+			raw, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(raw).Get("gae")
+		}
+		pname, opts := tag, ""
+		if i := strings.Index(tag, ","); i != -1 {
+			pname, opts = tag[:i], tag[i+1:]
+		}
+		tn := typeName(f.Type, imports)
+
+		for _, id := range f.Names {
+			pname := pname
+			exported := ast.IsExported(id.Name)
+
+			if opts == "extra" {
+				if si.Extra != nil {
+					return nil, fmt.Errorf("struct has multiple fields tagged as 'extra'")
+				}
+				if pname != "" && pname != "-" {
+					return nil, fmt.Errorf("struct 'extra' field has invalid name %s", pname)
+				}
+				if tn != "datastore.PropertyMap" {
+					return nil, fmt.Errorf("struct 'extra' field has invalid type, expecting PropertyMap")
+				}
+				si.Extra = &extraField{id.Name, exported, pname != "-"}
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(pname, "$"):
+				mf, err := parseMeta(id.Name, pname[1:], opts, tn, exported)
+				if err != nil {
+					return nil, err
+				}
+				if byMeta[mf.Key] {
+					return nil, fmt.Errorf("meta field %q set multiple times", pname)
+				}
+				byMeta[mf.Key] = true
+				si.HasKindMeta = si.HasKindMeta || mf.Key == "kind"
+				si.Meta = append(si.Meta, mf)
+				continue
+			case pname == "-" || !exported:
+				continue
+			case pname == "":
+				pname = id.Name
+			case !validPropertyName(pname):
+				return nil, fmt.Errorf("struct tag has invalid property name: %q", pname)
+			}
+
+			fld := &field{GoName: id.Name, PropName: pname}
+			switch opts {
+			case "":
+			case "noindex":
+				fld.NoIndex = true
+			default:
+				return nil, fmt.Errorf("field %s: unsupported struct tag option %q", id.Name, opts)
+			}
+			typ := tn
+			if strings.HasPrefix(typ, "[]") && typ != "[]byte" {
+				fld.Slice = true
+				typ = typ[2:]
+			}
+			if fld.Type = scalars[typ]; fld.Type == nil {
+				return nil, fmt.Errorf("field %s has unsupported type", id.Name)
+			}
+			if byName[pname] {
+				return nil, fmt.Errorf("struct tag has repeated property name: %q", pname)
+			}
+			byName[pname] = true
+			si.Fields = append(si.Fields, fld)
+		}
+	}
+	return si, nil
+}
+
+func parseMeta(goName, key, dflt, tn string, exported bool) (*metaField, error) {
+	if key == "schema" {
+		return nil, fmt.Errorf("$schema fields are not supported")
+	}
+	mf := &metaField{GoName: goName, Key: key, Exported: exported, GoType: tn}
+	switch tn {
+	case "string":
+		mf.Kind = "string"
+		mf.Default = strconv.Quote(dflt)
+	case "int", "int8", "int16", "int32", "int64":
+		mf.Kind = "int"
+		if dflt == "" {
+			dflt = "0"
+		}
+		if _, err := strconv.ParseInt(dflt, 10, 64); err != nil {
+			return nil, fmt.Errorf("meta field %q has bad default %q", "$"+key, dflt)
+		}
+		mf.Default = "int64(" + dflt + ")"
+	case "*datastore.Key":
+		if dflt != "" {
+			return nil, fmt.Errorf("key field is not allowed to have a default: %q", dflt)
+		}
+		mf.Kind = "key"
+		mf.Default = "nil"
+	case "datastore.Toggle":
+		mf.Kind = "toggle"
+		switch dflt {
+		case "on", "On", "true":
+			mf.Default = "true"
+		case "off", "Off", "false":
+			mf.Default = "false"
+		default:
+			return nil, fmt.Errorf("Toggle field has bad/missing default, got %q", dflt)
+		}
+	default:
+		return nil, fmt.Errorf("meta field %q has unsupported type", "$"+key)
+	}
+	return mf, nil
+}
+
+// validPropertyName returns whether name consists of one or more valid Go
+// identifiers joined by ".".
+func validPropertyName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, s := range strings.Split(name, ".") {
+		if s == "" {
+			return false
+		}
+		for i, c := range s {
+			if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+				return false
+			}
+		}
+	}
+	return true
+}
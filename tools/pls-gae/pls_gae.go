@@ -0,0 +1,116 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/luci/luci-go/common/errors"
+	"github.com/luci/luci-go/common/flag/stringsetflag"
+)
+
+type app struct {
+	out io.Writer
+
+	packageName string
+	typeNames   stringsetflag.Flag
+	outFile     string
+}
+
+const help = `Usage of %s:
+
+%s is a go-generator program that generates PropertyLoadSaver and
+MetaGetterSetter implementations for struct types, so that they can be used
+with the "github.com/luci/gae" library without the cost of reflection. It can
+be used in a go generation file like:
+
+  //go:generate pls-gae -type MyStruct -type OtherStruct
+
+This will produce a new file which implements the Load, Save, GetMeta,
+GetAllMeta and SetMeta methods for the named types. They behave exactly like
+the implementation returned by datastore.GetPLS, and honor the same "gae"
+struct tags ($kind, $id, $parent and other meta fields, noindex, extra).
+
+Only fields of the following types (or slices of them) are supported:
+int*, bool, string, float*, []byte, time.Time, datastore.GeoPoint and
+*datastore.Key. Structs with other field types, embedded fields or other tag
+options should continue to use datastore.GetPLS.
+
+Options:
+`
+
+func (a *app) parseArgs(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(a.out)
+	fs.Usage = func() {
+		fmt.Fprintf(a.out, help, args[0], args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.Var(&a.typeNames, "type",
+		"A struct type to generate methods for (required, repeatable)")
+	fs.StringVar(&a.outFile, "out", "pls_gae.gen.go",
+		"The name of the output file")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	fail := errors.MultiError(nil)
+	if a.typeNames.Data == nil || a.typeNames.Data.Len() == 0 {
+		fail = append(fail, errors.New("must specify one or more -type"))
+	}
+	if !strings.HasSuffix(a.outFile, ".go") {
+		fail = append(fail, errors.New("-out must end with '.go'"))
+	}
+	if len(fail) > 0 {
+		for _, e := range fail {
+			fmt.Fprintln(a.out, "error:", e)
+		}
+		fmt.Fprintln(a.out)
+		fs.Usage()
+		return fail
+	}
+	return nil
+}
+
+// generateDir returns the generated source for the structs in dir.
+func (a *app) generateDir(dir string) ([]byte, error) {
+	typeNames := a.typeNames.Data.ToSlice()
+	sort.Strings(typeNames)
+
+	pkgName, structs, err := parseDir(dir, a.outFile, typeNames)
+	if err != nil {
+		return nil, err
+	}
+	if a.packageName != "" {
+		pkgName = a.packageName
+	}
+	return generate(pkgName, structs)
+}
+
+func (a *app) main() {
+	if err := a.parseArgs(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args); err != nil {
+		os.Exit(1)
+	}
+	src, err := a.generateDir(".")
+	if err != nil {
+		fmt.Fprintf(a.out, "error: %s\n", err)
+		os.Exit(2)
+	}
+	if err := ioutil.WriteFile(a.outFile, src, 0666); err != nil {
+		fmt.Fprintf(a.out, "error while writing: %s\n", err)
+		os.Remove(a.outFile)
+		os.Exit(3)
+	}
+}
+
+func main() {
+	(&app{out: os.Stderr, packageName: os.Getenv("GOPACKAGE")}).main()
+}
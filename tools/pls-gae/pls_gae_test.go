@@ -0,0 +1,95 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func parseSrc(src string) (*structInfo, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "test.go", "package test\n"+src, 0)
+	if err != nil {
+		panic(err)
+	}
+	ts := f.Decls[len(f.Decls)-1].(*ast.GenDecl).Specs[0].(*ast.TypeSpec)
+	return parseStruct(ts.Name.Name, ts.Type.(*ast.StructType), fileImports(f))
+}
+
+func TestPLSGae(t *testing.T) {
+	t.Parallel()
+
+	Convey("pls-gae", t, func() {
+		Convey("regenerates the example package exactly", func() {
+			dir := "example"
+			pkg, structs, err := parseDir(dir, "pls_gae.gen.go", []string{"Everything", "Simple", "Singleton"})
+			So(err, ShouldBeNil)
+			src, err := generate(pkg, structs)
+			So(err, ShouldBeNil)
+
+			golden, err := ioutil.ReadFile(filepath.Join(dir, "pls_gae.gen.go"))
+			So(err, ShouldBeNil)
+			So(string(src), ShouldEqual, string(golden))
+		})
+
+		Convey("only imports the packages which the generated code uses", func() {
+			si, err := parseSrc("type T struct { A string `gae:\"time.a\"` }")
+			So(err, ShouldBeNil)
+			src, err := generate("test", []*structInfo{si})
+			So(err, ShouldBeNil)
+			So(string(src), ShouldContainSubstring, `case "time.a":`)
+			So(string(src), ShouldNotContainSubstring, `"time"`)
+			So(string(src), ShouldNotContainSubstring, `"math"`)
+		})
+
+		Convey("parses struct tags", func() {
+			si, err := parseSrc(`import "github.com/luci/gae/service/datastore"` + "\n" +
+				"type T struct {\n" +
+				"  ID   int64                 `gae:\"$id\"`\n" +
+				"  kind string                `gae:\"$kind,Thing\"`\n" +
+				"  A, B string                `gae:\",noindex\"`\n" +
+				"  C    []*datastore.Key      `gae:\"c\"`\n" +
+				"  d    int\n" +
+				"  E    datastore.PropertyMap `gae:\"-,extra\"`\n" +
+				"}")
+			So(err, ShouldBeNil)
+			So(si.HasKindMeta, ShouldBeTrue)
+			So(si.Meta, ShouldResemble, []*metaField{
+				{GoName: "ID", Key: "id", Exported: true, Kind: "int", GoType: "int64", Default: "int64(0)"},
+				{GoName: "kind", Key: "kind", Kind: "string", GoType: "string", Default: `"Thing"`},
+			})
+			So(si.Fields, ShouldResemble, []*field{
+				{GoName: "A", PropName: "A", NoIndex: true, Type: scalars["string"]},
+				{GoName: "B", PropName: "B", NoIndex: true, Type: scalars["string"]},
+				{GoName: "C", PropName: "c", Slice: true, Type: scalars["*datastore.Key"]},
+			})
+			So(si.Extra, ShouldResemble, &extraField{GoName: "E", Exported: true})
+		})
+
+		Convey("rejects unsupported structs", func() {
+			for src, msg := range map[string]string{
+				"type T struct { M map[string]int }":                         "field M has unsupported type",
+				"type T struct { S struct{ A int } }":                        "field S has unsupported type",
+				"type T struct { A int `gae:\",json\"` }":                    `field A: unsupported struct tag option "json"`,
+				"type T struct { A, B int `gae:\"x\"` }":                     `struct tag has repeated property name: "x"`,
+				"type T struct { A int `gae:\"$id\"`; B int `gae:\"$id\"` }": `meta field "$id" set multiple times`,
+				"type T struct { A int `gae:\"$schema,1\"` }":                "$schema fields are not supported",
+				"type T struct { A int `gae:\"$id,nope\"` }":                 `meta field "$id" has bad default "nope"`,
+				"type T struct { A int `gae:\"a b\"` }":                      `struct tag has invalid property name: "a b"`,
+				"type E struct{}\ntype T struct { E }":                       "embedded fields are not supported",
+			} {
+				_, err := parseSrc(src)
+				So(err, ShouldErrLike, msg)
+			}
+		})
+	})
+}